@@ -12,31 +12,46 @@ import (
 )
 
 func main() {
-	newConfig := config.NewConfig()
-	// Create and start the TCP server on port 8080
+	// redis-server [/path/to/redis.conf] [--directive args...]
+	newConfig, err := config.Load(os.Args[1:], os.Environ())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Create and start the TCP server on the configured port
 	tcpServer := server.NewServer(newConfig)
 	
 	// Start the server in a goroutine
+	errChan := make(chan error, 1)
 	go func() {
-		if err := tcpServer.RunIoMultiplexingServer(); err != nil {
-			log.Fatalf("Server error: %v", err)
-		}
+		errChan <- tcpServer.Run()
 	}()
 	
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	
-	fmt.Println("TCP Server is running...")
+	fmt.Printf("TCP Server is running in %s mode...\n", newConfig.ServerMode)
 	fmt.Println("Press Ctrl+C to stop the server")
 	
-	// Wait for shutdown signal
-	<-sigChan
-	fmt.Println("\nShutting down server...")
-	
-	// Gracefully stop the server
-	if err := tcpServer.Stop(); err != nil {
-		log.Printf("Error stopping server: %v", err)
+	// Wait for shutdown signal, or for the server to be shut down by the
+	// SHUTDOWN command or to fail
+	select {
+	case err := <-errChan:
+		if err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	case <-sigChan:
+		fmt.Println("\nShutting down server...")
+		
+		// Gracefully stop the server, SIGTERM goes through the same steps
+		// as SHUTDOWN
+		if err := tcpServer.Stop(); err != nil {
+			log.Printf("Error stopping server: %v", err)
+		}
+		if err := <-errChan; err != nil {
+			log.Printf("Server error: %v", err)
+		}
 	}
 	
 	fmt.Println("Server stopped successfully")
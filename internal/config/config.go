@@ -1,11 +1,155 @@
 package config
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type Config struct {
+	// ConfigFile is the path of the configuration file the config was
+	// loaded from, empty when there is none
+	ConfigFile string
 	Protocol string
 	Port string
+	// Bind are the addresses the TCP port is bound to, the host of Port
+	// when empty. "*" is every IPv4 address, "::*" every IPv6 one, and an
+	// address prefixed with "-" is skipped when it is not available.
+	Bind []string
+	// BindSourceAddr is the local address of the outgoing connections. It is
+	// only checked and kept for now, nothing uses it until the server makes
+	// outgoing connections.
+	BindSourceAddr string
+	// ProtectedMode refuses the clients that are not local while no
+	// password is set
+	ProtectedMode bool
+	// RequirePass is the password of the default user, the clients have to
+	// give it with AUTH before running commands. None is required when
+	// empty.
+	RequirePass string
+	// ACLFile is the file the users are loaded from at startup and by ACL
+	// LOAD, and saved to by ACL SAVE. None is used when empty.
+	ACLFile string
+	// ACLLogMaxLen is the number of entries the ACL log keeps
+	ACLLogMaxLen int
+	// RenamedCommands maps the upper case name of the commands renamed by
+	// rename-command to their new name, an empty name disables the command
+	RenamedCommands map[string]string
+	// IgnoredDirectives are the Redis directives met while loading the
+	// config that this server does not support, the server warns about
+	// them when it starts
+	IgnoredDirectives []string
+	// EnableProtectedConfigs tells which clients may change the protected
+	// parameters with CONFIG SET: all of them, none, the default, or the
+	// local ones
+	EnableProtectedConfigs string
+	// EnableDebugCommand tells which clients may run DEBUG, with the values
+	// of EnableProtectedConfigs. The server has no DEBUG command, the
+	// setting is taken so that hardened Redis configs load.
+	EnableDebugCommand string
+	// UnixSocket is the path of the Unix socket the server listens on, none
+	// when empty. UnixSocketPerm is the mode set on it, 0 keeps the one of
+	// the umask.
+	UnixSocket     string
+	UnixSocketPerm uint32
+	// MaxConnections is the number of clients that can be connected at
+	// once, the connections beyond it are rejected
 	MaxConnections int
+	// TCPBacklog is the size of the queue of the connections waiting to be
+	// accepted
+	TCPBacklog int
+	// Timeout is the number of seconds after which idle clients are
+	// closed, 0 disables it
+	Timeout int
+	// TCPKeepAlive is the interval in seconds of the TCP keepalive probes
+	// sent to the clients, 0 disables them
+	TCPKeepAlive int
+	// TLSPort is the port of the TLS listeners, bound to the same addresses
+	// as Port, 0 disables TLS
+	TLSPort int
+	// TLSCertFile and TLSKeyFile are the PEM files of the certificate of the
+	// server and of its private key. TLSCACertFile holds the certificates
+	// the certificates of the clients are verified against.
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSCACertFile string
+	// TLSAuthClients is TLSAuthClientsYes when the clients must present a
+	// certificate, TLSAuthClientsOptional when it is only verified if given
+	TLSAuthClients string
+	// TLSProtocols lists the accepted versions, like "TLSv1.2 TLSv1.3",
+	// every version from TLSv1.2 when empty
+	TLSProtocols string
+	// TLSCiphers lists the cipher suites of TLSv1.2 and earlier, separated
+	// by colons, the default ones of crypto/tls when empty
+	TLSCiphers string
+	// MaxMemory is the memory limit of the server in bytes, the commands
+	// growing the dataset are refused while the heap is over it. 0 means no
+	// limit.
+	MaxMemory int64
+	// ProtoMaxBulkLen is the longest argument a client may send, in bytes.
+	// ClientQueryBufferLimit bounds the bytes buffered for the command a
+	// client is sending, the client is closed once it goes over it.
+	ProtoMaxBulkLen        int64
+	ClientQueryBufferLimit int64
+	// ServerMode selects how clients are served, ServerModeEventLoop or
+	// ServerModeThreadPool
+	ServerMode string
+	// ThreadPoolSize is the number of workers of the thread pool mode. A
+	// connection holds a worker as long as it is open, so it also caps the
+	// number of clients served at once.
+	ThreadPoolSize int
+	// IOBackend selects the I/O multiplexing backend, IOBackendDefault uses
+	// epoll on Linux and kqueue on macOS
+	IOBackend string
+	// NonBlockingClients makes accepted client sockets non-blocking and
+	// accepts every pending connection at once
+	NonBlockingClients bool
+	// EdgeTriggered registers fds in edge-triggered mode (EPOLLET on epoll,
+	// EV_CLEAR on kqueue) and drains sockets on every event. It requires
+	// NonBlockingClients.
+	EdgeTriggered bool
+	// IOThreads is the number of threads reading, parsing and writing for
+	// the clients of the readiness based event loop, the main thread
+	// included. Commands are still executed by the main thread only; 1
+	// disables threaded I/O.
+	IOThreads int
+	// Shards is the number of event loops of the sharded mode, each one
+	// owning the keys hashing to it. 1 runs the single event loop server.
+	Shards int
+	// LogLevel is the verbosity of the server log, one of LogLevelDebug,
+	// LogLevelVerbose, LogLevelNotice and LogLevelWarning
+	LogLevel string
+	// ShutdownTimeout is the number of seconds a shutdown waits for the
+	// replicas to catch up and for the clients to be sent their replies
+	ShutdownTimeout int
+	// MetricsPort is the port of the HTTP listener serving /metrics in the
+	// Prometheus format, 0 disables it
+	MetricsPort int
+	// MetricsBind are the addresses the metrics port is bound to, like Bind.
+	// The scrapes are not authenticated, so only the loopback interface is
+	// listened on by default.
+	MetricsBind []string
+	// SlowlogLogSlowerThan is the execution time in microseconds from which
+	// commands are recorded in the slow log, a negative value disables it
+	SlowlogLogSlowerThan int
+	// SlowlogMaxLen is the number of entries the slow log keeps
+	SlowlogMaxLen int
+	// LatencyMonitorThreshold is the duration in milliseconds from which
+	// events are recorded by the latency monitor, 0 disables it
+	LatencyMonitorThreshold int
+	// ClientOutputBufferLimits maps a client class to the output buffer
+	// limits enforced on clients of that class
+	ClientOutputBufferLimits map[string]ClientOutputBufferLimit
 }
 
+// ClientOutputBufferLimit is a client-output-buffer-limit entry. A client is
+// disconnected as soon as its output buffer reaches HardLimitBytes, or when it
+// stays at or above SoftLimitBytes for more than SoftLimitSeconds. Zero
+// disables the corresponding limit.
+type ClientOutputBufferLimit struct {
+	HardLimitBytes   int64
+	SoftLimitBytes   int64
+	SoftLimitSeconds int64
+}
 
 const (
 	Protocol = "tcp"
@@ -13,13 +157,141 @@ const (
 	MaxConnections = 20000
 )
 
-var defaultConfig = &Config{
-	Protocol: Protocol,
-	Port: Port,
-	MaxConnections: MaxConnections,
-}
+// Server modes
+const (
+	// ServerModeEventLoop serves every client from an I/O multiplexing event
+	// loop, or from one loop per shard
+	ServerModeEventLoop = "event-loop"
+	// ServerModeThreadPool serves every connection from its own worker of a
+	// thread pool with blocking I/O, commands are executed under a lock
+	ServerModeThreadPool = "thread-pool"
+)
+
+// I/O multiplexing backends
+const (
+	IOBackendDefault = "default"
+	// IOBackendIOUring lets the kernel accept, receive and send through an
+	// io_uring. It is only available on Linux and falls back to the default
+	// backend when the kernel does not support it.
+	IOBackendIOUring = "io_uring"
+)
 
+// Log levels, from the most to the least verbose
+const (
+	LogLevelDebug   = "debug"
+	LogLevelVerbose = "verbose"
+	LogLevelNotice  = "notice"
+	LogLevelWarning = "warning"
+)
+
+// Values of enable-protected-configs
+const (
+	EnableNo    = "no"
+	EnableYes   = "yes"
+	EnableLocal = "local"
+)
+
+// Values of tls-auth-clients
+const (
+	TLSAuthClientsNo       = "no"
+	TLSAuthClientsYes      = "yes"
+	TLSAuthClientsOptional = "optional"
+)
 
+// Client classes used by client-output-buffer-limit, CLIENT LIST and CLIENT
+// KILL also filter the clients by class
+const (
+	ClientClassNormal  = "normal"
+	ClientClassReplica = "replica"
+	ClientClassPubSub  = "pubsub"
+)
+
+func defaultConfig() *Config {
+	return &Config{
+		Protocol: Protocol,
+		Port: Port,
+		MaxConnections: MaxConnections,
+		ProtectedMode: true,
+		ProtoMaxBulkLen: 512 << 20,
+		ClientQueryBufferLimit: 1 << 30,
+		ACLLogMaxLen: 128,
+		EnableProtectedConfigs: EnableNo,
+		EnableDebugCommand: EnableNo,
+		TCPBacklog: 511,
+		TCPKeepAlive: 300,
+		TLSAuthClients: TLSAuthClientsYes,
+		ServerMode: ServerModeEventLoop,
+		ThreadPoolSize: 1024,
+		IOBackend: IOBackendDefault,
+		NonBlockingClients: true,
+		EdgeTriggered: false,
+		IOThreads: 1,
+		Shards: 1,
+		LogLevel: LogLevelNotice,
+		ShutdownTimeout: 10,
+		MetricsBind: []string{"127.0.0.1", "-::1"},
+		SlowlogLogSlowerThan: 10000,
+		SlowlogMaxLen: 128,
+		ClientOutputBufferLimits: map[string]ClientOutputBufferLimit{
+			ClientClassNormal:  {HardLimitBytes: 0, SoftLimitBytes: 0, SoftLimitSeconds: 0},
+			ClientClassReplica: {HardLimitBytes: 256 << 20, SoftLimitBytes: 64 << 20, SoftLimitSeconds: 60},
+			ClientClassPubSub:  {HardLimitBytes: 32 << 20, SoftLimitBytes: 8 << 20, SoftLimitSeconds: 60},
+		},
+	}
+}
+
+// NewConfig returns a config holding the default values
 func NewConfig() *Config {
-	return defaultConfig
-}
\ No newline at end of file
+	return defaultConfig()
+}
+
+// Clone returns a deep copy of the config
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Bind = append([]string(nil), c.Bind...)
+	clone.MetricsBind = append([]string(nil), c.MetricsBind...)
+	clone.IgnoredDirectives = append([]string(nil), c.IgnoredDirectives...)
+	clone.RenamedCommands = make(map[string]string, len(c.RenamedCommands))
+	for name, renamed := range c.RenamedCommands {
+		clone.RenamedCommands[name] = renamed
+	}
+	clone.ClientOutputBufferLimits = make(map[string]ClientOutputBufferLimit, len(c.ClientOutputBufferLimits))
+	for class, limit := range c.ClientOutputBufferLimits {
+		clone.ClientOutputBufferLimits[class] = limit
+	}
+	return &clone
+}
+
+// Store holds the live config of a server. Updates are applied to a copy
+// which then replaces the config, so readers on other goroutines always see
+// a consistent config and never need a lock.
+type Store struct {
+	current atomic.Pointer[Config]
+	// mu serializes the updates
+	mu sync.Mutex
+}
+
+func NewStore(c *Config) *Store {
+	s := &Store{}
+	s.current.Store(c)
+	return s
+}
+
+// Load returns the current config, it must not be modified
+func (s *Store) Load() *Config {
+	return s.current.Load()
+}
+
+// Update applies update to a copy of the current config, which replaces it
+// when update succeeds. It returns the config that was replaced.
+func (s *Store) Update(update func(c *Config) error) (*Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.current.Load()
+	c := old.Clone()
+	if err := update(c); err != nil {
+		return nil, err
+	}
+	s.current.Store(c)
+	return old, nil
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArgs(t *testing.T) {
+	args, err := SplitArgs(`  set "a b\x41\n" 'it\'s' plain  `)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"set", "a bA\n", "it's", "plain"}, args)
+
+	_, err = SplitArgs(`port "6379`)
+	assert.NotNil(t, err)
+	_, err = SplitArgs(`port "6379"x`)
+	assert.NotNil(t, err)
+}
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]int64{
+		"100": 100, "1k": 1000, "1kb": 1024, "2MB": 2 << 20, "1gb": 1 << 30, "1g": 1000 * 1000 * 1000,
+	}
+	for in, want := range cases {
+		v, err := ParseMemory(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, want, v, in)
+	}
+	for _, in := range []string{"", "mb", "-1", "1tb", "1.5gb"} {
+		_, err := ParseMemory(in)
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	included := filepath.Join(dir, "included.conf")
+	assert.Nil(t, os.WriteFile(included, []byte("maxclients 100\n"), 0o644))
+	main := filepath.Join(dir, "redis.conf")
+	assert.Nil(t, os.WriteFile(main, []byte(
+		"# a comment\n"+
+			"port 6380\n"+
+			"bind 127.0.0.1 -::1\n"+
+			"bind-source-addr 10.0.0.5\n"+
+			"maxmemory 1mb\n"+
+			"include \""+included+"\"\n"+
+			"client-output-buffer-limit pubsub 64mb 16mb 90\n"+
+			"io-threads 2\n"+
+			"rename-command FLUSHALL \"\"\n"+
+			"rename-command config some-secret-name\n"), 0o644))
+
+	c, err := Load([]string{main, "--maxmemory", "1gb", "--io-threads", "4"},
+		[]string{"REDIS_CONFIG_IO_THREADS=3", "REDIS_CONFIG_SHUTDOWN_TIMEOUT=5", "REDIS_PORT=tcp://10.0.0.1:6379", "HOME=/root"})
+	assert.Nil(t, err)
+	assert.Equal(t, main, c.ConfigFile)
+	assert.Equal(t, ":6380", c.Port)
+	assert.Equal(t, []string{"127.0.0.1", "-::1"}, c.Bind)
+	assert.Equal(t, "10.0.0.5", c.BindSourceAddr)
+	assert.Equal(t, 100, c.MaxConnections)
+	// the command line wins over the environment which wins over the file
+	assert.Equal(t, int64(1<<30), c.MaxMemory)
+	assert.Equal(t, 4, c.IOThreads)
+	assert.Equal(t, 5, c.ShutdownTimeout)
+	assert.Equal(t, ClientOutputBufferLimit{HardLimitBytes: 64 << 20, SoftLimitBytes: 16 << 20, SoftLimitSeconds: 90},
+		c.ClientOutputBufferLimits[ClientClassPubSub])
+	assert.Equal(t, int64(256<<20), c.ClientOutputBufferLimits[ClientClassReplica].HardLimitBytes)
+	assert.Equal(t, map[string]string{"FLUSHALL": "", "CONFIG": "some-secret-name"}, c.RenamedCommands)
+	// the defaults are not shared
+	assert.Equal(t, int64(32<<20), NewConfig().ClientOutputBufferLimits[ClientClassPubSub].HardLimitBytes)
+
+	_, err = Load([]string{"--port", "70000"}, nil)
+	assert.NotNil(t, err)
+	_, err = Load([]string{"--no-such-directive", "1"}, nil)
+	assert.NotNil(t, err)
+	_, err = Load([]string{"--bind", "localhost"}, nil)
+	assert.NotNil(t, err)
+	_, err = Load([]string{"--bind-source-addr", "localhost"}, nil)
+	assert.NotNil(t, err)
+	_, err = Load([]string{"--enable-debug-command", "sometimes"}, nil)
+	assert.NotNil(t, err)
+	_, err = Load([]string{"--edge-triggered", "yes", "--nonblocking-clients", "no"}, nil)
+	assert.NotNil(t, err)
+	_, err = Load([]string{"--rename-command", "config", "a", "--rename-command", "CONFIG", "b"}, nil)
+	assert.ErrorContains(t, err, "renamed twice")
+
+	assert.Nil(t, os.WriteFile(main, []byte("port 6380\nmaxclients many\n"), 0o644))
+	_, err = Load([]string{main}, nil)
+	assert.ErrorContains(t, err, "at line 2")
+
+	// the environment variables are checked like the directives of a file
+	_, err = Load(nil, []string{"REDIS_CONFIG_NO_SUCH_DIRECTIVE=1"})
+	assert.ErrorContains(t, err, "REDIS_CONFIG_NO_SUCH_DIRECTIVE")
+	c, err = Load(nil, []string{"REDIS_CONFIG_RENAME_COMMAND=FLUSHALL \"\"", "REDIS_CONFIG_APPENDONLY=no"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"FLUSHALL": ""}, c.RenamedCommands)
+	assert.Equal(t, []string{"appendonly"}, c.IgnoredDirectives)
+}
+
+func TestLoadRedisConf(t *testing.T) {
+	// the config shipped with Redis loads, the directives this server does
+	// not support are recorded once each
+	c, err := Load([]string{filepath.Join("testdata", "redis-7.2.conf")}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ":6379", c.Port)
+	assert.Equal(t, []string{"127.0.0.1", "-::1"}, c.Bind)
+	assert.Equal(t, 300, c.TCPKeepAlive)
+	assert.Equal(t, int64(256<<20), c.ClientOutputBufferLimits[ClientClassReplica].HardLimitBytes)
+	assert.Contains(t, c.IgnoredDirectives, "daemonize")
+	assert.Contains(t, c.IgnoredDirectives, "appendonly")
+	assert.Contains(t, c.IgnoredDirectives, "dir")
+	assert.Contains(t, c.IgnoredDirectives, "databases")
+	assert.NotContains(t, c.IgnoredDirectives, "port")
+	assert.Equal(t, EnableNo, c.EnableDebugCommand)
+
+	// an unknown directive still fails
+	c = NewConfig()
+	assert.NotNil(t, c.LoadString("no-such-directive yes"))
+	assert.Nil(t, c.LoadString("save 3600 1\nsave 300 100"))
+	assert.Equal(t, []string{"save"}, c.IgnoredDirectives)
+}
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	original := "# keep this comment\n" +
+		"maxmemory 100mb\n" +
+		"\n" +
+		"port 6380\n" +
+		"maxmemory 1mb\n" +
+		"client-output-buffer-limit pubsub 32mb 8mb 60\n" +
+		"# trailing comment\n"
+	assert.Nil(t, os.WriteFile(path, []byte(original), 0o600))
+
+	c, err := Load([]string{path}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetAtRuntime("maxmemory", "2gb"))
+	assert.Nil(t, c.SetAtRuntime("shutdown-timeout", "3"))
+	assert.Nil(t, c.SetAtRuntime("client-output-buffer-limit", "slave 1gb 512mb 30"))
+	assert.ErrorContains(t, c.SetAtRuntime("port", "6381"), "immutable")
+	assert.Equal(t, ErrUnknownParameter, c.SetAtRuntime("no-such-parameter", "1"))
+	value, ok := c.Get("MAXMEMORY")
+	assert.True(t, ok)
+	assert.Equal(t, "2147483648", value)
+
+	assert.Nil(t, c.Rewrite())
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "# keep this comment\n"+
+		"maxmemory 2gb\n"+
+		"\n"+
+		"port 6380\n"+
+		"client-output-buffer-limit normal 0 0 0\n"+
+		"client-output-buffer-limit replica 1gb 512mb 30\n"+
+		"client-output-buffer-limit pubsub 32mb 8mb 60\n"+
+		"# trailing comment\n"+
+		"# Generated by CONFIG REWRITE\n"+
+		"shutdown-timeout 3\n", string(data))
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// rewriting again keeps the file as it is
+	reloaded, err := Load([]string{path}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, reloaded.Rewrite())
+	again, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), string(again))
+
+	assert.Equal(t, ErrNoConfigFile, NewConfig().Rewrite())
+}
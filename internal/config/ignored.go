@@ -0,0 +1,95 @@
+package config
+
+// ignoredDirectives are the Redis directives this server has no use for:
+// persistence, replication, cluster, encodings and the like. Config files
+// written for Redis set them, so they are accepted and recorded in
+// IgnoredDirectives instead of failing the load. CONFIG GET and CONFIG SET
+// still do not know them.
+var ignoredDirectives = map[string]bool{
+	// general
+	"daemonize": true, "supervised": true, "pidfile": true, "logfile": true,
+	"syslog-enabled": true, "syslog-ident": true, "syslog-facility": true,
+	"crash-log-enabled": true, "crash-memcheck-enabled": true,
+	"databases": true, "always-show-logo": true, "set-proc-title": true,
+	"proc-title-template": true, "locale-collate": true,
+	"enable-module-command": true,
+	"loadmodule": true, "ignore-warnings": true, "socket-mark-id": true,
+	"max-new-connections-per-cycle": true, "max-new-tls-connections-per-cycle": true,
+	"io-threads-do-reads": true, "maxmemory-clients": true,
+	// snapshotting
+	"save": true, "stop-writes-on-bgsave-error": true, "rdbcompression": true,
+	"rdbchecksum": true, "sanitize-dump-payload": true, "dbfilename": true,
+	"rdb-del-sync-files": true, "dir": true, "rdb-save-incremental-fsync": true,
+	// replication
+	"replicaof": true, "slaveof": true, "masterauth": true, "masteruser": true,
+	"replica-serve-stale-data": true, "slave-serve-stale-data": true,
+	"replica-read-only": true, "slave-read-only": true,
+	"repl-diskless-sync": true, "repl-diskless-sync-delay": true,
+	"repl-diskless-sync-max-replicas": true, "repl-diskless-load": true,
+	"repl-ping-replica-period": true, "repl-timeout": true,
+	"repl-disable-tcp-nodelay": true, "repl-backlog-size": true,
+	"repl-backlog-ttl": true, "replica-priority": true, "slave-priority": true,
+	"propagation-error-behavior": true, "replica-ignore-disk-write-errors": true,
+	"replica-announced": true, "min-replicas-to-write": true,
+	"min-replicas-max-lag": true, "min-slaves-to-write": true,
+	"min-slaves-max-lag": true, "replica-announce-ip": true,
+	"replica-announce-port": true, "replica-lazy-flush": true,
+	"replica-ignore-maxmemory": true, "tls-replication": true,
+	// memory and lazy freeing
+	"maxmemory-policy": true, "maxmemory-samples": true,
+	"maxmemory-eviction-tenacity": true, "active-expire-effort": true,
+	"lazyfree-lazy-eviction": true, "lazyfree-lazy-expire": true,
+	"lazyfree-lazy-server-del": true, "lazyfree-lazy-user-del": true,
+	"lazyfree-lazy-user-flush": true, "oom-score-adj": true,
+	"oom-score-adj-values": true, "disable-thp": true,
+	"lfu-log-factor": true, "lfu-decay-time": true,
+	"tracking-table-max-keys": true,
+	// append only file
+	"appendonly": true, "appendfilename": true, "appenddirname": true,
+	"appendfsync": true, "no-appendfsync-on-rewrite": true,
+	"auto-aof-rewrite-percentage": true, "auto-aof-rewrite-min-size": true,
+	"aof-load-truncated": true, "aof-use-rdb-preamble": true,
+	"aof-timestamp-enabled": true, "aof-rewrite-incremental-fsync": true,
+	// shutdown and scripting
+	"shutdown-on-sigint": true, "shutdown-on-sigterm": true,
+	"lua-time-limit": true, "busy-reply-threshold": true,
+	// cluster
+	"cluster-enabled": true, "cluster-config-file": true,
+	"cluster-node-timeout": true, "cluster-port": true,
+	"cluster-replica-validity-factor": true, "cluster-migration-barrier": true,
+	"cluster-allow-replica-migration": true, "cluster-require-full-coverage": true,
+	"cluster-replica-no-failover": true, "cluster-allow-reads-when-down": true,
+	"cluster-allow-pubsubshard-when-down": true, "cluster-link-sendbuf-limit": true,
+	"cluster-announce-hostname": true, "cluster-announce-human-nodename": true,
+	"cluster-preferred-endpoint-type": true, "cluster-announce-ip": true,
+	"cluster-announce-port": true, "cluster-announce-tls-port": true,
+	"cluster-announce-bus-port": true, "tls-cluster": true,
+	// TLS settings beyond the supported ones
+	"tls-dh-params-file": true, "tls-ciphersuites": true,
+	"tls-prefer-server-ciphers": true, "tls-session-caching": true,
+	"tls-session-cache-size": true, "tls-session-cache-timeout": true,
+	"tls-key-file-pass": true, "tls-client-cert-file": true,
+	"tls-client-key-file": true, "tls-client-key-file-pass": true,
+	"tls-ca-cert-dir": true,
+	// monitoring and notifications
+	"latency-tracking": true, "latency-tracking-info-percentiles": true,
+	"notify-keyspace-events": true, "acl-pubsub-default": true,
+	// encodings
+	"hash-max-listpack-entries": true, "hash-max-listpack-value": true,
+	"hash-max-ziplist-entries": true, "hash-max-ziplist-value": true,
+	"list-max-listpack-size": true, "list-max-ziplist-size": true,
+	"list-compress-depth": true, "set-max-intset-entries": true,
+	"set-max-listpack-entries": true, "set-max-listpack-value": true,
+	"zset-max-listpack-entries": true, "zset-max-listpack-value": true,
+	"zset-max-ziplist-entries": true, "zset-max-ziplist-value": true,
+	"hll-sparse-max-bytes": true, "stream-node-max-bytes": true,
+	"stream-node-max-entries": true,
+	// background work
+	"activerehashing": true, "hz": true, "dynamic-hz": true,
+	"activedefrag": true, "active-defrag-ignore-bytes": true,
+	"active-defrag-threshold-lower": true, "active-defrag-threshold-upper": true,
+	"active-defrag-cycle-min": true, "active-defrag-cycle-max": true,
+	"active-defrag-max-scan-fields": true, "jemalloc-bg-thread": true,
+	"server-cpulist": true, "bio-cpulist": true, "aof-rewrite-cpulist": true,
+	"bgsave-cpulist": true,
+}
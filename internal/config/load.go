@@ -0,0 +1,306 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the environment variables overriding directives, the
+// rest of the name is the directive in upper case with dashes replaced by
+// underscores: REDIS_CONFIG_MAXMEMORY=1gb, REDIS_CONFIG_IO_THREADS=4. It is
+// not just REDIS_ as Kubernetes sets REDIS_PORT=tcp://... and the like in
+// the pods of a namespace holding a service called redis.
+const EnvPrefix = "REDIS_CONFIG_"
+
+// maxIncludeDepth bounds nested include directives, which also stops
+// include loops
+const maxIncludeDepth = 16
+
+// Load builds the config the server runs with. args are the command line
+// arguments without the program name: an optional config file path
+// followed by directives like --port 6380 --maxmemory 1gb. The config file
+// is applied first, then the environment variables, then the command line,
+// and the result is validated.
+func Load(args []string, environ []string) (*Config, error) {
+	c := NewConfig()
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if err = c.LoadFile(path); err != nil {
+			return nil, err
+		}
+		c.ConfigFile = path
+		args = args[1:]
+	}
+	if err := c.loadEnv(environ); err != nil {
+		return nil, err
+	}
+	if err := c.loadArgs(args); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile applies the directives of a redis.conf style file: one
+// directive per line followed by its arguments, # starting a comment line
+// and include pulling in another file
+func (c *Config) LoadFile(path string) error {
+	return c.loadFile(path, 0)
+}
+
+func (c *Config) loadFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("too many nested includes reading %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("fatal error, can't open config file '%s': %v", path, err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if err = c.loadLine(line, depth); err != nil {
+			return fmt.Errorf("*** FATAL CONFIG FILE ERROR ***\nReading the configuration file %s, at line %d\n>>> '%s'\n%v",
+				path, i+1, strings.TrimSpace(line), err)
+		}
+	}
+	return nil
+}
+
+// LoadString applies directives given as the lines of a config file
+func (c *Config) LoadString(content string) error {
+	for i, line := range strings.Split(content, "\n") {
+		if err := c.loadLine(line, 0); err != nil {
+			return fmt.Errorf("at line %d: '%s': %v", i+1, strings.TrimSpace(line), err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) loadLine(line string, depth int) error {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	args, err := SplitArgs(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	if strings.EqualFold(args[0], "include") {
+		if len(args) != 2 {
+			return errors.New("Bad directive or wrong number of arguments")
+		}
+		return c.loadFile(args[1], depth+1)
+	}
+	return c.Set(args[0], args[1:])
+}
+
+// Set applies a single directive. The Redis directives this server does
+// not support are recorded in IgnoredDirectives, the unknown ones fail.
+func (c *Config) Set(name string, args []string) error {
+	if strings.EqualFold(name, "rename-command") {
+		return c.renameCommand(args)
+	}
+	p, ok := parameters[strings.ToLower(name)]
+	if !ok && ignoredDirectives[strings.ToLower(name)] {
+		if !slices.Contains(c.IgnoredDirectives, strings.ToLower(name)) {
+			c.IgnoredDirectives = append(c.IgnoredDirectives, strings.ToLower(name))
+		}
+		return nil
+	}
+	if !ok || (p.nargs > 0 && len(args) != p.nargs) {
+		return errors.New("Bad directive or wrong number of arguments")
+	}
+	return p.set(c, args)
+}
+
+// renameCommand records a rename-command directive, the server applies it
+// to its command table when it starts. It is not a parameter: CONFIG GET
+// does not show the new names and CONFIG REWRITE keeps the directives of
+// the file as they are.
+func (c *Config) renameCommand(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Bad directive or wrong number of arguments")
+	}
+	name := strings.ToUpper(args[0])
+	if _, ok := c.RenamedCommands[name]; ok {
+		return fmt.Errorf("Command '%s' is renamed twice", args[0])
+	}
+	if c.RenamedCommands == nil {
+		c.RenamedCommands = make(map[string]string)
+	}
+	c.RenamedCommands[name] = args[1]
+	return nil
+}
+
+// loadEnv applies the directives set through REDIS_CONFIG_ environment
+// variables, in the order of their names. A variable naming no directive
+// is an error, like an unknown directive in a config file.
+func (c *Config) loadEnv(environ []string) error {
+	sort.Strings(environ)
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, EnvPrefix), "_", "-"))
+		args, err := SplitArgs(value)
+		if err != nil {
+			return fmt.Errorf("environment variable %s: %v", key, err)
+		}
+		if err = c.Set(name, args); err != nil {
+			return fmt.Errorf("environment variable %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// loadArgs applies command line directives: every --name starts a
+// directive, the arguments up to the next one are its arguments
+func (c *Config) loadArgs(args []string) error {
+	var directive []string
+	apply := func() error {
+		if directive == nil {
+			return nil
+		}
+		if err := c.Set(directive[0], directive[1:]); err != nil {
+			return fmt.Errorf("option --%s: %v", directive[0], err)
+		}
+		return nil
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			if err := apply(); err != nil {
+				return err
+			}
+			directive = []string{arg[2:]}
+			continue
+		}
+		if directive == nil {
+			return fmt.Errorf("unexpected argument '%s', directives start with --", arg)
+		}
+		directive = append(directive, arg)
+	}
+	return apply()
+}
+
+// Validate checks the settings that depend on each other
+func (c *Config) Validate() error {
+	if c.EdgeTriggered && !c.NonBlockingClients {
+		return errors.New("edge-triggered requires nonblocking-clients")
+	}
+	if c.ServerMode == ServerModeThreadPool && (c.Shards > 1 || c.IOThreads > 1) {
+		return fmt.Errorf("shards and io-threads are only supported by the %s server mode", ServerModeEventLoop)
+	}
+	if c.Shards > 1 && c.IOThreads > 1 {
+		return errors.New("shards and io-threads can't be combined")
+	}
+	return nil
+}
+
+// SplitArgs splits a line into arguments the way redis.conf does: they are
+// separated by spaces, double quoted arguments support \n, \r, \t, \b, \a,
+// \\, \" and \xHH escapes, single quoted ones only \'. A closing quote must
+// be followed by a space or the end of the line.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+				break
+			}
+			ch := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case ch == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					v, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(v))
+					i += 3
+				case ch == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case ch == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					inDouble, done = false, true
+				default:
+					arg = append(arg, ch)
+				}
+			case inSingle:
+				switch {
+				case ch == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					arg = append(arg, '\'')
+					i++
+				case ch == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					inSingle, done = false, true
+				default:
+					arg = append(arg, ch)
+				}
+			default:
+				switch {
+				case isSpace(ch):
+					done = true
+				case ch == '"':
+					inDouble = true
+				case ch == '\'':
+					inSingle = true
+				default:
+					arg = append(arg, ch)
+				}
+			}
+			i++
+		}
+		args = append(args, string(arg))
+	}
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == '\v' || ch == '\f'
+}
+
+func isHex(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
@@ -0,0 +1,470 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parameter is a configuration directive of the config file, of the
+// command line and of the environment
+type parameter struct {
+	name string
+	// set parses the arguments of the directive into the config. Directives
+	// taking a fixed number of arguments have it checked before.
+	set   func(c *Config, args []string) error
+	nargs int
+	// get formats the value the way CONFIG GET shows it
+	get func(c *Config) string
+	// rewrite formats the value as the arguments of the directive, one
+	// directive per line of the config file. The value of get is written
+	// when it is nil.
+	rewrite func(c *Config) [][]string
+	// immutable parameters can't be changed while the server runs
+	immutable bool
+	// protected parameters can only be changed by the clients
+	// enable-protected-configs allows
+	protected bool
+}
+
+// parameters are the supported directives, by name
+var parameters = map[string]*parameter{}
+
+func addParameter(p *parameter) {
+	parameters[p.name] = p
+}
+
+func intParameter(name string, min, max int, field func(c *Config) *int) *parameter {
+	return &parameter{
+		name:  name,
+		nargs: 1,
+		get: func(c *Config) string {
+			return strconv.Itoa(*field(c))
+		},
+		set: func(c *Config, args []string) error {
+			v, err := strconv.Atoi(args[0])
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if v < min || v > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+func stringParameter(name string, field func(c *Config) *string) *parameter {
+	return &parameter{
+		name:  name,
+		nargs: 1,
+		get: func(c *Config) string {
+			return *field(c)
+		},
+		set: func(c *Config, args []string) error {
+			*field(c) = args[0]
+			return nil
+		},
+	}
+}
+
+func memoryParameter(name string, field func(c *Config) *int64) *parameter {
+	return &parameter{
+		name:  name,
+		nargs: 1,
+		get: func(c *Config) string {
+			return strconv.FormatInt(*field(c), 10)
+		},
+		rewrite: func(c *Config) [][]string {
+			return [][]string{{FormatMemory(*field(c))}}
+		},
+		set: func(c *Config, args []string) error {
+			v, err := ParseMemory(args[0])
+			if err != nil {
+				return err
+			}
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+func boolParameter(name string, field func(c *Config) *bool) *parameter {
+	return &parameter{
+		name:  name,
+		nargs: 1,
+		get: func(c *Config) string {
+			if *field(c) {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Config, args []string) error {
+			v, err := parseBool(args[0])
+			if err != nil {
+				return err
+			}
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+func enumParameter(name string, values []string, field func(c *Config) *string) *parameter {
+	return &parameter{
+		name:  name,
+		nargs: 1,
+		get: func(c *Config) string {
+			return *field(c)
+		},
+		set: func(c *Config, args []string) error {
+			for _, v := range values {
+				if strings.EqualFold(args[0], v) {
+					*field(c) = v
+					return nil
+				}
+			}
+			return fmt.Errorf("argument must be one of the following: %s", strings.Join(values, ", "))
+		},
+	}
+}
+
+func init() {
+	addParameter(&parameter{
+		name:  "port",
+		nargs: 1,
+		set: func(c *Config, args []string) error {
+			port, err := strconv.Atoi(args[0])
+			if err != nil || port < 0 || port > 65535 {
+				return errors.New("Invalid port")
+			}
+			// the host part of the address is kept
+			host, _, err := net.SplitHostPort(c.Port)
+			if err != nil {
+				host = ""
+			}
+			c.Port = net.JoinHostPort(host, args[0])
+			return nil
+		},
+		get: func(c *Config) string {
+			_, port, _ := net.SplitHostPort(c.Port)
+			return port
+		},
+		immutable: true,
+	})
+	addParameter(bindParameter("bind", func(c *Config) *[]string { return &c.Bind }))
+	addParameter(&parameter{
+		name:  "bind-source-addr",
+		nargs: 1,
+		set: func(c *Config, args []string) error {
+			if args[0] != "" && net.ParseIP(args[0]) == nil {
+				return errors.New("Invalid bind-source-addr")
+			}
+			c.BindSourceAddr = args[0]
+			return nil
+		},
+		get: func(c *Config) string {
+			return c.BindSourceAddr
+		},
+	})
+	addParameter(boolParameter("protected-mode", func(c *Config) *bool { return &c.ProtectedMode }))
+	addParameter(stringParameter("requirepass", func(c *Config) *string { return &c.RequirePass }))
+	addParameter(protected(immutable(stringParameter("aclfile", func(c *Config) *string { return &c.ACLFile }))))
+	addParameter(intParameter("acllog-max-len", 0, 1<<30, func(c *Config) *int { return &c.ACLLogMaxLen }))
+	addParameter(immutable(enumParameter("enable-protected-configs", []string{EnableNo, EnableYes, EnableLocal},
+		func(c *Config) *string { return &c.EnableProtectedConfigs })))
+	addParameter(immutable(enumParameter("enable-debug-command", []string{EnableNo, EnableYes, EnableLocal},
+		func(c *Config) *string { return &c.EnableDebugCommand })))
+	addParameter(protected(immutable(stringParameter("unixsocket", func(c *Config) *string { return &c.UnixSocket }))))
+	addParameter(&parameter{
+		name:  "unixsocketperm",
+		nargs: 1,
+		set: func(c *Config, args []string) error {
+			perm, err := strconv.ParseUint(args[0], 8, 32)
+			if err != nil || perm > 0777 {
+				return errors.New("argument must be an octal number between 0 and 777")
+			}
+			c.UnixSocketPerm = uint32(perm)
+			return nil
+		},
+		get: func(c *Config) string {
+			return strconv.FormatUint(uint64(c.UnixSocketPerm), 8)
+		},
+		immutable: true,
+	})
+	addParameter(intParameter("maxclients", 1, 1<<30, func(c *Config) *int { return &c.MaxConnections }))
+	addParameter(immutable(intParameter("tcp-backlog", 1, 1<<30, func(c *Config) *int { return &c.TCPBacklog })))
+	addParameter(intParameter("timeout", 0, 1<<30, func(c *Config) *int { return &c.Timeout }))
+	addParameter(intParameter("tcp-keepalive", 0, 1<<30, func(c *Config) *int { return &c.TCPKeepAlive }))
+	addParameter(immutable(intParameter("tls-port", 0, 65535, func(c *Config) *int { return &c.TLSPort })))
+	// the files the server reads its keys and the trusted certificates from
+	addParameter(protected(stringParameter("tls-cert-file", func(c *Config) *string { return &c.TLSCertFile })))
+	addParameter(protected(stringParameter("tls-key-file", func(c *Config) *string { return &c.TLSKeyFile })))
+	addParameter(protected(stringParameter("tls-ca-cert-file", func(c *Config) *string { return &c.TLSCACertFile })))
+	addParameter(enumParameter("tls-auth-clients", []string{TLSAuthClientsNo, TLSAuthClientsYes, TLSAuthClientsOptional},
+		func(c *Config) *string { return &c.TLSAuthClients }))
+	addParameter(stringParameter("tls-protocols", func(c *Config) *string { return &c.TLSProtocols }))
+	addParameter(stringParameter("tls-ciphers", func(c *Config) *string { return &c.TLSCiphers }))
+	addParameter(memoryParameter("maxmemory", func(c *Config) *int64 { return &c.MaxMemory }))
+	addParameter(minMemory(1<<20, memoryParameter("proto-max-bulk-len", func(c *Config) *int64 { return &c.ProtoMaxBulkLen })))
+	addParameter(minMemory(1<<20, memoryParameter("client-query-buffer-limit", func(c *Config) *int64 { return &c.ClientQueryBufferLimit })))
+	// the way clients are served is chosen at startup
+	addParameter(immutable(enumParameter("server-mode", []string{ServerModeEventLoop, ServerModeThreadPool},
+		func(c *Config) *string { return &c.ServerMode })))
+	addParameter(immutable(intParameter("thread-pool-size", 1, 1<<20, func(c *Config) *int { return &c.ThreadPoolSize })))
+	addParameter(immutable(enumParameter("io-backend", []string{IOBackendDefault, IOBackendIOUring},
+		func(c *Config) *string { return &c.IOBackend })))
+	addParameter(immutable(boolParameter("nonblocking-clients", func(c *Config) *bool { return &c.NonBlockingClients })))
+	addParameter(immutable(boolParameter("edge-triggered", func(c *Config) *bool { return &c.EdgeTriggered })))
+	addParameter(immutable(intParameter("io-threads", 1, 128, func(c *Config) *int { return &c.IOThreads })))
+	addParameter(immutable(intParameter("shards", 1, 1024, func(c *Config) *int { return &c.Shards })))
+	addParameter(enumParameter("loglevel", []string{LogLevelDebug, LogLevelVerbose, LogLevelNotice, LogLevelWarning},
+		func(c *Config) *string { return &c.LogLevel }))
+	addParameter(intParameter("shutdown-timeout", 0, 1<<30, func(c *Config) *int { return &c.ShutdownTimeout }))
+	addParameter(immutable(intParameter("metrics-port", 0, 65535, func(c *Config) *int { return &c.MetricsPort })))
+	addParameter(bindParameter("metrics-bind", func(c *Config) *[]string { return &c.MetricsBind }))
+	addParameter(intParameter("slowlog-log-slower-than", -1, 1<<30, func(c *Config) *int { return &c.SlowlogLogSlowerThan }))
+	addParameter(intParameter("slowlog-max-len", 0, 1<<30, func(c *Config) *int { return &c.SlowlogMaxLen }))
+	addParameter(intParameter("latency-monitor-threshold", 0, 1<<30, func(c *Config) *int { return &c.LatencyMonitorThreshold }))
+	addParameter(&parameter{
+		name:    "client-output-buffer-limit",
+		set:     setClientOutputBufferLimits,
+		get:     getClientOutputBufferLimits,
+		rewrite: rewriteClientOutputBufferLimits,
+	})
+}
+
+// bindParameter is an immutable list of addresses to listen on: IP
+// addresses, "*" or "::*", each optionally prefixed with "-"
+func bindParameter(name string, field func(c *Config) *[]string) *parameter {
+	return &parameter{
+		name: name,
+		set: func(c *Config, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Bad directive or wrong number of arguments")
+			}
+			if len(args) > 16 {
+				return errors.New("Too many bind addresses specified.")
+			}
+			for _, arg := range args {
+				addr := strings.TrimPrefix(arg, "-")
+				if addr != "*" && addr != "::*" && net.ParseIP(addr) == nil {
+					return fmt.Errorf("Invalid bind address '%s'", arg)
+				}
+			}
+			*field(c) = append([]string(nil), args...)
+			return nil
+		},
+		get: func(c *Config) string {
+			return strings.Join(*field(c), " ")
+		},
+		rewrite: func(c *Config) [][]string {
+			return [][]string{*field(c)}
+		},
+		immutable: true,
+	}
+}
+
+// setClientOutputBufferLimits parses one or more groups of
+// <class> <hard limit> <soft limit> <soft seconds>, slave being an alias of
+// the replica class
+func setClientOutputBufferLimits(c *Config, args []string) error {
+	if len(args) == 0 || len(args)%4 != 0 {
+		return errors.New("Wrong number of arguments in buffer limit configuration.")
+	}
+	limits := make(map[string]ClientOutputBufferLimit)
+	for i := 0; i < len(args); i += 4 {
+		class := strings.ToLower(args[i])
+		if class == "slave" {
+			class = ClientClassReplica
+		}
+		if class != ClientClassNormal && class != ClientClassReplica && class != ClientClassPubSub {
+			return errors.New("Invalid client class specified in buffer limit configuration.")
+		}
+		hard, err := ParseMemory(args[i+1])
+		if err != nil {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		soft, err := ParseMemory(args[i+2])
+		if err != nil {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		seconds, err := strconv.ParseInt(args[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		limits[class] = ClientOutputBufferLimit{HardLimitBytes: hard, SoftLimitBytes: soft, SoftLimitSeconds: seconds}
+	}
+
+	// the classes that are not given keep their limits
+	merged := make(map[string]ClientOutputBufferLimit, len(c.ClientOutputBufferLimits))
+	for class, limit := range c.ClientOutputBufferLimits {
+		merged[class] = limit
+	}
+	for class, limit := range limits {
+		merged[class] = limit
+	}
+	c.ClientOutputBufferLimits = merged
+	return nil
+}
+
+// clientClasses are the client classes in the order they are shown
+var clientClasses = []string{ClientClassNormal, ClientClassReplica, ClientClassPubSub}
+
+func getClientOutputBufferLimits(c *Config) string {
+	var parts []string
+	for _, class := range clientClasses {
+		limit := c.ClientOutputBufferLimits[class]
+		parts = append(parts, class,
+			strconv.FormatInt(limit.HardLimitBytes, 10),
+			strconv.FormatInt(limit.SoftLimitBytes, 10),
+			strconv.FormatInt(limit.SoftLimitSeconds, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+func rewriteClientOutputBufferLimits(c *Config) [][]string {
+	var lines [][]string
+	for _, class := range clientClasses {
+		limit := c.ClientOutputBufferLimits[class]
+		lines = append(lines, []string{class,
+			FormatMemory(limit.HardLimitBytes),
+			FormatMemory(limit.SoftLimitBytes),
+			strconv.FormatInt(limit.SoftLimitSeconds, 10)})
+	}
+	return lines
+}
+
+// minMemory refuses the values of a memory parameter below min
+func minMemory(min int64, p *parameter) *parameter {
+	set := p.set
+	p.set = func(c *Config, args []string) error {
+		if v, err := ParseMemory(args[0]); err == nil && v < min {
+			return fmt.Errorf("argument must be between %d and %d inclusive", min, int64(math.MaxInt64))
+		}
+		return set(c, args)
+	}
+	return p
+}
+
+func immutable(p *parameter) *parameter {
+	p.immutable = true
+	return p
+}
+
+func protected(p *parameter) *parameter {
+	p.protected = true
+	return p
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+// ParseMemory parses a number of bytes with an optional unit: k, m and g
+// are powers of 1000, kb, mb and gb powers of 1024. Units are case
+// insensitive.
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(s)
+	num, mul := lower, int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			num, mul = strings.TrimSuffix(lower, unit.suffix), unit.mul
+			break
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	if v > (1<<63-1)/mul {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return v * mul, nil
+}
+
+// FormatMemory formats a number of bytes with the largest of the gb, mb
+// and kb units dividing it
+func FormatMemory(v int64) string {
+	switch {
+	case v == 0:
+		return "0"
+	case v%(1<<30) == 0:
+		return strconv.FormatInt(v>>30, 10) + "gb"
+	case v%(1<<20) == 0:
+		return strconv.FormatInt(v>>20, 10) + "mb"
+	case v%(1<<10) == 0:
+		return strconv.FormatInt(v>>10, 10) + "kb"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// ParameterNames returns the names of the parameters in alphabetical order
+func ParameterNames() []string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the value of a parameter the way CONFIG GET shows it
+func (c *Config) Get(name string) (string, bool) {
+	p, ok := parameters[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return p.get(c), true
+}
+
+// SetAtRuntime applies a value given to CONFIG SET, the arguments of
+// directives taking several of them are separated by spaces
+func (c *Config) SetAtRuntime(name string, value string) error {
+	p, ok := parameters[strings.ToLower(name)]
+	if !ok {
+		return ErrUnknownParameter
+	}
+	if p.immutable {
+		return errors.New("can't set immutable config")
+	}
+	args := []string{value}
+	if p.nargs != 1 {
+		var err error
+		if args, err = SplitArgs(value); err != nil {
+			return err
+		}
+	}
+	if err := c.Set(p.name, args); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// IsProtected reports whether name is a protected parameter
+func IsProtected(name string) bool {
+	p, ok := parameters[strings.ToLower(name)]
+	return ok && p.protected
+}
+
+// ErrUnknownParameter is returned by SetAtRuntime for an unknown parameter
+var ErrUnknownParameter = errors.New("unknown parameter")
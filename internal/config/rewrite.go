@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoConfigFile is returned by Rewrite when the server was started
+// without a config file
+var ErrNoConfigFile = errors.New("The server is running without a config file")
+
+// rewriteSignature heads the directives Rewrite appends to the file
+const rewriteSignature = "# Generated by CONFIG REWRITE"
+
+// Rewrite writes the config back to the file it was loaded from. Comments,
+// blank lines, includes and the order of the directives are kept, every
+// directive of the file gets the current value of its parameter. The
+// parameters missing from the file are appended unless they hold their
+// default value. The file is replaced atomically.
+func (c *Config) Rewrite() error {
+	if c.ConfigFile == "" {
+		return ErrNoConfigFile
+	}
+	data, err := os.ReadFile(c.ConfigFile)
+	if err != nil {
+		return err
+	}
+	content := strings.TrimSuffix(string(data), "\n")
+	var lines []string
+	if content != "" {
+		lines = strings.Split(content, "\n")
+	}
+
+	// pending holds the lines of every parameter not written yet, the
+	// directives of the file take them in order
+	pending := make(map[string][]string, len(parameters))
+	for name, p := range parameters {
+		pending[name] = p.lines(c)
+	}
+	// out holds the new lines for each line of the file, the lines left
+	// for a parameter go after its last directive
+	out := make([][]string, len(lines))
+	last := make(map[string]int)
+	signed := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == rewriteSignature {
+			signed = true
+		}
+		args, err := SplitArgs(trimmed)
+		if trimmed == "" || trimmed[0] == '#' || err != nil || len(args) == 0 {
+			out[i] = []string{line}
+			continue
+		}
+		name := strings.ToLower(args[0])
+		if _, ok := parameters[name]; !ok {
+			out[i] = []string{line}
+			continue
+		}
+		last[name] = i
+		if left := pending[name]; len(left) > 0 {
+			out[i] = []string{left[0]}
+			pending[name] = left[1:]
+		}
+	}
+
+	defaults := NewConfig()
+	var appended []string
+	for _, name := range ParameterNames() {
+		left := pending[name]
+		if len(left) == 0 {
+			continue
+		}
+		if i, ok := last[name]; ok {
+			out[i] = append(out[i], left...)
+			continue
+		}
+		p := parameters[name]
+		if p.get(c) != p.get(defaults) {
+			appended = append(appended, left...)
+		}
+	}
+
+	var b strings.Builder
+	for _, group := range out {
+		for _, line := range group {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	if len(appended) > 0 {
+		if !signed {
+			b.WriteString(rewriteSignature + "\n")
+		}
+		for _, line := range appended {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	return replaceFile(c.ConfigFile, []byte(b.String()))
+}
+
+// lines formats the parameter as the directive lines of a config file
+func (p *parameter) lines(c *Config) []string {
+	var values [][]string
+	if p.rewrite != nil {
+		values = p.rewrite(c)
+	} else {
+		values = [][]string{{p.get(c)}}
+	}
+	lines := make([]string, 0, len(values))
+	for _, args := range values {
+		quoted := make([]string, 0, len(args)+1)
+		quoted = append(quoted, p.name)
+		for _, arg := range args {
+			quoted = append(quoted, quoteArg(arg))
+		}
+		lines = append(lines, strings.Join(quoted, " "))
+	}
+	return lines
+}
+
+// quoteArg quotes an argument when SplitArgs would not read it back as is
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n\v\f\"'\\") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch ch := arg[i]; {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch == '\n':
+			b.WriteString(`\n`)
+		case ch == '\r':
+			b.WriteString(`\r`)
+		case ch == '\t':
+			b.WriteString(`\t`)
+		case ch < ' ' || ch > '~':
+			fmt.Fprintf(&b, `\x%02x`, ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// replaceFile writes a temporary file next to path and renames it over
+// path, so that a crash never leaves a truncated config file
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".redis.conf-rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
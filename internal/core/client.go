@@ -0,0 +1,169 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+)
+
+// Client flags
+const (
+	ClientFlagReplica uint64 = 1 << iota
+	ClientFlagPubSub
+	// ClientFlagCloseAfterReply closes the connection once the pending
+	// replies are written
+	ClientFlagCloseAfterReply
+	// ClientFlagBlocked stops the execution of the client commands until
+	// the reply of the current one is available
+	ClientFlagBlocked
+	// ClientFlagMonitor is set on the clients that ran MONITOR
+	ClientFlagMonitor
+	// ClientFlagCloseASAP closes the connection without running the
+	// pending commands, CLIENT KILL sets it
+	ClientFlagCloseASAP
+	// ClientFlagPaused stops the execution of the client commands until
+	// CLIENT PAUSE ends
+	ClientFlagPaused
+	// ClientFlagReplyOff drops the replies, ClientFlagReplySkip the reply of
+	// the current command and ClientFlagReplySkipNext the one of the next
+	// command, as set by CLIENT REPLY
+	ClientFlagReplyOff
+	ClientFlagReplySkip
+	ClientFlagReplySkipNext
+	// ClientFlagNoEvict and ClientFlagNoTouch are set by CLIENT NO-EVICT
+	// and CLIENT NO-TOUCH
+	ClientFlagNoEvict
+	ClientFlagNoTouch
+	// ClientFlagUnixSocket is set on the clients connected through the Unix
+	// socket
+	ClientFlagUnixSocket
+	// ClientFlagOutputLimitReached is set once a reply was dropped for
+	// taking the output buffer over its hard limit, the client is then
+	// disconnected
+	ClientFlagOutputLimitReached
+)
+
+// DefaultUser is the user clients are authenticated as
+const DefaultUser = "default"
+
+// nextClientID numbers the clients from 1 in the order they are created
+var nextClientID atomic.Int64
+
+// Client is the state the server keeps for every connected client
+type Client struct {
+	// ID is unique for the lifetime of the process
+	ID    int64
+	Fd    int
+	Flags uint64
+	// Addr is the address of the peer, LAddr the local address the client
+	// connected to and Name the name the client gave itself
+	Addr  string
+	LAddr string
+	Name  string
+	User  string
+	// Authenticated is set once the client gave the password, or when none
+	// was required as it connected
+	Authenticated bool
+	// DB is the selected database, the server only has db 0
+	DB int
+	// CreatedAt is when the client connected, LastInteraction when it last
+	// sent data or ran a command, LastCmd the upper case name of the last
+	// command
+	CreatedAt       time.Time
+	LastInteraction time.Time
+	LastCmd         string
+	// QueryBuf holds the bytes read from the client that are not parsed yet,
+	// Parser the arguments already parsed of the command they continue
+	QueryBuf []byte
+	Parser   CommandParser
+	// Commands holds the parsed commands waiting to be executed, QueryErr
+	// the protocol error that stopped parsing, replied once they are done
+	Commands []*Command
+	QueryErr error
+	Out      OutputBuffer
+	// OutputHardLimit is the hard client-output-buffer-limit, 0 when there
+	// is none. The server refreshes it before running the client commands.
+	OutputHardLimit int64
+	// Conn encodes the bytes exchanged over the socket, nil when they are
+	// the protocol itself
+	Conn Conn
+	// WriteMonitored is set while the client fd is registered for write events
+	WriteMonitored bool
+	// LastError is the last error reply queued for the client, the server
+	// clears it before running a command to tell whether the command failed
+	LastError []byte
+	// softLimitReachedAt is when the output buffer first went over the soft
+	// limit, zero while it is below it
+	softLimitReachedAt time.Time
+}
+
+func NewClient(fd int) *Client {
+	now := time.Now()
+	return &Client{
+		ID:              nextClientID.Add(1),
+		Fd:              fd,
+		User:            DefaultUser,
+		CreatedAt:       now,
+		LastInteraction: now,
+	}
+}
+
+// AddReply queues a reply to be sent on the next flush, unless CLIENT
+// REPLY turned the replies off. A reply that would take the output buffer
+// over its hard limit is dropped, and so are the ones that follow, rather
+// than buffered until the next flush.
+func (c *Client) AddReply(data []byte) {
+	if len(data) > 0 && data[0] == '-' {
+		c.LastError = data
+	}
+	if c.Flags&(ClientFlagReplyOff|ClientFlagReplySkip|ClientFlagOutputLimitReached) != 0 {
+		return
+	}
+	if c.OutputHardLimit > 0 && int64(c.Out.Size()+len(data)) >= c.OutputHardLimit {
+		c.Flags |= ClientFlagOutputLimitReached
+		return
+	}
+	c.Out.AddReply(data)
+}
+
+// ReplyDone is called once the reply of a command is queued, the command
+// following CLIENT REPLY SKIP is the only one to get no reply
+func (c *Client) ReplyDone() {
+	c.Flags &^= ClientFlagReplySkip
+	if c.Flags&ClientFlagReplySkipNext != 0 {
+		c.Flags &^= ClientFlagReplySkipNext
+		c.Flags |= ClientFlagReplySkip
+	}
+}
+
+// Class returns the class of the client, it selects the output buffer limits
+// of the client and CLIENT LIST and CLIENT KILL filter the clients by class
+func (c *Client) Class() string {
+	if c.Flags&ClientFlagReplica != 0 {
+		return config.ClientClassReplica
+	}
+	if c.Flags&ClientFlagPubSub != 0 {
+		return config.ClientClassPubSub
+	}
+	return config.ClientClassNormal
+}
+
+// OutputBufferLimitReached reports whether the pending output of the client
+// is over the hard limit, or a reply was dropped for going over it, or has
+// been over the soft limit for longer than the allowed duration
+func (c *Client) OutputBufferLimitReached(limit config.ClientOutputBufferLimit, now time.Time) bool {
+	used := int64(c.Out.Size())
+	if c.Flags&ClientFlagOutputLimitReached != 0 || limit.HardLimitBytes > 0 && used >= limit.HardLimitBytes {
+		return true
+	}
+	if limit.SoftLimitBytes == 0 || used < limit.SoftLimitBytes {
+		c.softLimitReachedAt = time.Time{}
+		return false
+	}
+	if c.softLimitReachedAt.IsZero() {
+		c.softLimitReachedAt = now
+		return false
+	}
+	return now.Sub(c.softLimitReachedAt) > time.Duration(limit.SoftLimitSeconds)*time.Second
+}
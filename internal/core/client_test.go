@@ -0,0 +1,45 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputBufferLimitReached(t *testing.T) {
+	limit := config.ClientOutputBufferLimit{HardLimitBytes: 4096, SoftLimitBytes: 1024, SoftLimitSeconds: 10}
+	now := time.Now()
+
+	c := NewClient(-1)
+	c.AddReply(bytes.Repeat([]byte("x"), 512))
+	assert.False(t, c.OutputBufferLimitReached(limit, now))
+
+	// over the soft limit, but not for long enough
+	c.AddReply(bytes.Repeat([]byte("x"), 1024))
+	assert.False(t, c.OutputBufferLimitReached(limit, now))
+	assert.False(t, c.OutputBufferLimitReached(limit, now.Add(10*time.Second)))
+	assert.True(t, c.OutputBufferLimitReached(limit, now.Add(11*time.Second)))
+
+	c = NewClient(-1)
+	c.AddReply(bytes.Repeat([]byte("x"), 4096))
+	assert.True(t, c.OutputBufferLimitReached(limit, now))
+
+	// the reply going over the hard limit is not buffered
+	c = NewClient(-1)
+	c.OutputHardLimit = limit.HardLimitBytes
+	c.AddReply(bytes.Repeat([]byte("x"), 1024))
+	c.AddReply(bytes.Repeat([]byte("x"), 1<<20))
+	c.AddReply([]byte("+OK\r\n"))
+	assert.Equal(t, 1024, c.Out.Size())
+	assert.True(t, c.OutputBufferLimitReached(limit, now))
+}
+
+func TestClientClass(t *testing.T) {
+	c := NewClient(-1)
+	assert.Equal(t, config.ClientClassNormal, c.Class())
+	c.Flags |= ClientFlagPubSub
+	assert.Equal(t, config.ClientClassPubSub, c.Class())
+}
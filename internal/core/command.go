@@ -14,4 +14,15 @@ const (
 	CmdExpireAt = "EXPIREAT"
 	CmdDel = "DEL"
 	CmdExists = "EXISTS"
+	CmdShutdown = "SHUTDOWN"
+	CmdConfig = "CONFIG"
+	CmdInfo = "INFO"
+	CmdSlowlog = "SLOWLOG"
+	CmdLatency = "LATENCY"
+	CmdMonitor = "MONITOR"
+	CmdClient = "CLIENT"
+	CmdAuth = "AUTH"
+	CmdHello = "HELLO"
+	CmdQuit = "QUIT"
+	CmdAcl = "ACL"
 )
\ No newline at end of file
@@ -0,0 +1,133 @@
+package core
+
+import "sort"
+
+// CommandSpec describes the arguments of a command. Arity counts the
+// command name, a negative value means at least -Arity arguments. FirstKey,
+// LastKey and KeyStep locate the keys in the full argument list (the command
+// name being at 0); a negative LastKey counts from the end, zero FirstKey
+// means the command takes no key. Write is set on the commands that modify
+// the keyspace, DenyOOM on the ones refused while the used memory is over
+// maxmemory and NoAuth on the ones clients may run before they
+// authenticated. Categories are the ACL categories of the command and
+// Subcommands tells that its first argument names a subcommand, which ACL
+// rules can allow on its own.
+type CommandSpec struct {
+	Name        string
+	Arity       int
+	FirstKey    int
+	LastKey     int
+	KeyStep     int
+	Write       bool
+	DenyOOM     bool
+	NoAuth      bool
+	Categories  []string
+	Subcommands bool
+}
+
+// The ACL categories the commands belong to
+const (
+	CategoryKeyspace    = "keyspace"
+	CategoryRead        = "read"
+	CategoryWrite       = "write"
+	CategorySet         = "set"
+	CategorySortedSet   = "sortedset"
+	CategoryList        = "list"
+	CategoryHash        = "hash"
+	CategoryString      = "string"
+	CategoryBitmap      = "bitmap"
+	CategoryHyperLogLog = "hyperloglog"
+	CategoryGeo         = "geo"
+	CategoryStream      = "stream"
+	CategoryPubSub      = "pubsub"
+	CategoryAdmin       = "admin"
+	CategoryFast        = "fast"
+	CategorySlow        = "slow"
+	CategoryBlocking    = "blocking"
+	CategoryDangerous   = "dangerous"
+	CategoryConnection  = "connection"
+	CategoryTransaction = "transaction"
+	CategoryScripting   = "scripting"
+)
+
+// Categories lists the ACL categories, the ones no command belongs to yet
+// included so that the rules written for Redis are accepted
+var Categories = []string{
+	CategoryKeyspace, CategoryRead, CategoryWrite, CategorySet, CategorySortedSet, CategoryList, CategoryHash,
+	CategoryString, CategoryBitmap, CategoryHyperLogLog, CategoryGeo, CategoryStream, CategoryPubSub, CategoryAdmin,
+	CategoryFast, CategorySlow, CategoryBlocking, CategoryDangerous, CategoryConnection, CategoryTransaction,
+	CategoryScripting,
+}
+
+var (
+	readKeyspaceCategories  = []string{CategoryKeyspace, CategoryRead, CategoryFast}
+	writeKeyspaceCategories = []string{CategoryKeyspace, CategoryWrite, CategoryFast}
+	adminCategories         = []string{CategoryAdmin, CategorySlow, CategoryDangerous}
+	connectionCategories    = []string{CategoryFast, CategoryConnection}
+)
+
+var commandTable = map[string]*CommandSpec{
+	CmdPing:     {Name: CmdPing, Arity: -1, Categories: connectionCategories},
+	CmdSet:      {Name: CmdSet, Arity: -3, FirstKey: 1, LastKey: 1, KeyStep: 1, Write: true, DenyOOM: true, Categories: []string{CategoryWrite, CategoryString, CategorySlow}},
+	CmdGet:      {Name: CmdGet, Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Categories: []string{CategoryRead, CategoryString, CategoryFast}},
+	CmdTtl:      {Name: CmdTtl, Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Categories: readKeyspaceCategories},
+	CmdExpire:   {Name: CmdExpire, Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Write: true, Categories: writeKeyspaceCategories},
+	CmdExpireAt: {Name: CmdExpireAt, Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Write: true, Categories: writeKeyspaceCategories},
+	CmdDel:      {Name: CmdDel, Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Write: true, Categories: []string{CategoryKeyspace, CategoryWrite, CategorySlow}},
+	CmdExists:   {Name: CmdExists, Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Categories: readKeyspaceCategories},
+	CmdShutdown: {Name: CmdShutdown, Arity: -1, Categories: adminCategories},
+	CmdConfig:   {Name: CmdConfig, Arity: -2, Categories: adminCategories, Subcommands: true},
+	CmdInfo:     {Name: CmdInfo, Arity: -1, Categories: []string{CategorySlow, CategoryDangerous}},
+	CmdSlowlog:  {Name: CmdSlowlog, Arity: -2, Categories: adminCategories, Subcommands: true},
+	CmdLatency:  {Name: CmdLatency, Arity: -2, Categories: adminCategories, Subcommands: true},
+	CmdMonitor:  {Name: CmdMonitor, Arity: 1, Categories: adminCategories},
+	CmdClient:   {Name: CmdClient, Arity: -2, Categories: adminCategories, Subcommands: true},
+	CmdAuth:     {Name: CmdAuth, Arity: -2, NoAuth: true, Categories: connectionCategories},
+	CmdHello:    {Name: CmdHello, Arity: -1, NoAuth: true, Categories: connectionCategories},
+	CmdQuit:     {Name: CmdQuit, Arity: -1, NoAuth: true, Categories: connectionCategories},
+	CmdAcl:      {Name: CmdAcl, Arity: -2, Categories: adminCategories, Subcommands: true},
+}
+
+// Commands returns the specs of all the commands sorted by name
+func Commands() []*CommandSpec {
+	specs := make([]*CommandSpec, 0, len(commandTable))
+	for _, spec := range commandTable {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// LookupCommand returns the spec of a command, name being upper case
+func LookupCommand(name string) (*CommandSpec, bool) {
+	spec, ok := commandTable[name]
+	return spec, ok
+}
+
+// KeyIndexes returns the positions of the keys of the command in Args
+func (c *Command) KeyIndexes() []int {
+	spec, ok := LookupCommand(c.Cmd)
+	if !ok || spec.FirstKey == 0 {
+		return nil
+	}
+	argc := len(c.Args) + 1
+	last := spec.LastKey
+	if last < 0 {
+		last = argc + last
+	}
+	var indexes []int
+	for i := spec.FirstKey; i <= last && i < argc; i += spec.KeyStep {
+		indexes = append(indexes, i-1)
+	}
+	return indexes
+}
+
+// Keys returns the keys the command operates on
+func (c *Command) Keys() []string {
+	indexes := c.KeyIndexes()
+	keys := make([]string, len(indexes))
+	for i, index := range indexes {
+		keys[i] = c.Args[index]
+	}
+	return keys
+}
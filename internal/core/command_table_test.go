@@ -0,0 +1,19 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandKeys(t *testing.T) {
+	cases := map[*Command][]string{
+		{Cmd: CmdPing}: {},
+		{Cmd: CmdSet, Args: []string{"k", "v", "EX", "1"}}: {"k"},
+		{Cmd: CmdDel, Args: []string{"a", "b", "c"}}:       {"a", "b", "c"},
+		{Cmd: "UNKNOWN", Args: []string{"a"}}:              {},
+	}
+	for cmd, keys := range cases {
+		assert.EqualValues(t, keys, cmd.Keys())
+	}
+}
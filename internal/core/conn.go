@@ -0,0 +1,95 @@
+package core
+
+import "syscall"
+
+// Conn sits between a client and its socket when the bytes on the wire are
+// not the protocol itself, a TLS session for instance. The server still
+// reads and writes the socket: what it reads goes through Decode before
+// being parsed and the replies go through Encode before being written.
+type Conn interface {
+	// Decode consumes data read from the socket and appends the plaintext
+	// it carries to dst. It is also called with no data to pick up
+	// plaintext buffered by the connection.
+	Decode(dst, data []byte) ([]byte, error)
+	// Encode consumes replies, the bytes to write are returned by Peek
+	Encode(replies []byte) error
+	// Peek returns the next chunk of encoded data without consuming it, it
+	// is empty when there is nothing to write. The chunk stays valid until
+	// it is consumed with Advance.
+	Peek() []byte
+	// Advance marks n bytes of the chunk returned by Peek as written
+	Advance(n int)
+}
+
+// AppendInput adds data read from the socket of the client to its query
+// buffer
+func (c *Client) AppendInput(data []byte) (err error) {
+	if c.Conn == nil {
+		c.QueryBuf = append(c.QueryBuf, data...)
+		return nil
+	}
+	c.QueryBuf, err = c.Conn.Decode(c.QueryBuf, data)
+	return err
+}
+
+// HasPendingOutput reports whether there is data left to be written to the
+// socket of the client
+func (c *Client) HasPendingOutput() bool {
+	return c.Out.HasPendingReplies() || c.Conn != nil && len(c.Conn.Peek()) > 0
+}
+
+// PeekOutput returns the next chunk to write to the socket of the client
+// without consuming it, the queued replies are encoded once the chunks
+// encoded before are written. The chunk stays valid until it is consumed
+// with AdvanceOutput.
+func (c *Client) PeekOutput() ([]byte, error) {
+	if c.Conn == nil {
+		return c.Out.Peek(), nil
+	}
+	if chunk := c.Conn.Peek(); len(chunk) > 0 {
+		return chunk, nil
+	}
+	for chunk := c.Out.Peek(); len(chunk) > 0; chunk = c.Out.Peek() {
+		if err := c.Conn.Encode(chunk); err != nil {
+			return nil, err
+		}
+		c.Out.Advance(len(chunk))
+	}
+	return c.Conn.Peek(), nil
+}
+
+// AdvanceOutput marks n bytes of the chunk returned by PeekOutput as written
+func (c *Client) AdvanceOutput(n int) {
+	if c.Conn == nil {
+		c.Out.Advance(n)
+		return
+	}
+	c.Conn.Advance(n)
+}
+
+// Flush writes as much of the pending output as the socket accepts, like
+// OutputBuffer.Flush
+func (c *Client) Flush() (int, error) {
+	if c.Conn == nil {
+		return c.Out.Flush(c.Fd)
+	}
+	total := 0
+	for {
+		chunk, err := c.PeekOutput()
+		if err != nil || len(chunk) == 0 {
+			return total, err
+		}
+		n, err := send(c.Fd, chunk)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				return total, nil
+			}
+			return total, err
+		}
+		total += n
+		c.AdvanceOutput(n)
+		if n < len(chunk) {
+			return total, nil
+		}
+	}
+}
@@ -3,7 +3,6 @@ package core
 import (
 	"errors"
 	"strconv"
-	"syscall"
 	"time"
 
 	"github.com/lyxuansang91/redis-crash-course/internal/constant"
@@ -18,7 +17,7 @@ type CommandExecutor interface {
 	Expire(args []string) []byte
 	Del(args []string) []byte
 	Exists(args []string) []byte
-	ExecuteAndResponse(command *Command, connFd int) error
+	ExecuteAndResponse(command *Command, client *Client) error
 }
 
 type CommandExecutorImpl struct {
@@ -154,8 +153,9 @@ func (cmd *CommandExecutorImpl) ExpireAt(args []string) []byte {
 	return []byte("-CMD NOT FOUND\r\n")
 }
 
-// ExecuteAndResponse given a Command, executes it and responses
-func (cmd *CommandExecutorImpl) ExecuteAndResponse(command *Command, connFd int) error {
+// ExecuteAndResponse given a Command, executes it and queues the response
+// in the client output buffer. The server is responsible for flushing it.
+func (cmd *CommandExecutorImpl) ExecuteAndResponse(command *Command, client *Client) error {
 	var res []byte
 
 	switch command.Cmd {
@@ -178,8 +178,8 @@ func (cmd *CommandExecutorImpl) ExecuteAndResponse(command *Command, connFd int)
 	default:
 		res = []byte("-CMD NOT FOUND\r\n")
 	}
-	_, err := syscall.Write(connFd, res)
-	return err
+	client.AddReply(res)
+	return nil
 }
 
 func (cmd *CommandExecutorImpl) Exists(args []string) []byte {
@@ -0,0 +1,98 @@
+package core
+
+// MatchGlob reports whether s matches the glob-style pattern the way Redis
+// matches keys and parameter names: * matches any sequence, ? any single
+// byte, [abc], [^abc] and [a-z] a byte of a set, and \ escapes the next
+// byte. nocase makes the match case insensitive.
+func MatchGlob(pattern, s string, nocase bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if MatchGlob(pattern[1:], s[i:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var matched bool
+			matched, pattern = matchGlobSet(pattern[1:], s[0], nocase)
+			if !matched {
+				return false
+			}
+			s = s[1:]
+			// pattern is past the closing bracket
+			continue
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || !globByteEqual(pattern[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+// matchGlobSet matches ch against the set starting at pattern, right after
+// the opening bracket. It returns the rest of the pattern after the set.
+func matchGlobSet(pattern string, ch byte, nocase bool) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			matched = matched || globByteEqual(pattern[1], ch, nocase)
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-':
+			start, end := lowerByte(pattern[0], nocase), lowerByte(pattern[2], nocase)
+			if start > end {
+				start, end = end, start
+			}
+			c := lowerByte(ch, nocase)
+			matched = matched || (c >= start && c <= end)
+			pattern = pattern[3:]
+		default:
+			matched = matched || globByteEqual(pattern[0], ch, nocase)
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// skip the closing bracket
+		pattern = pattern[1:]
+	}
+	return matched != not, pattern
+}
+
+func globByteEqual(a, b byte, nocase bool) bool {
+	return lowerByte(a, nocase) == lowerByte(b, nocase)
+}
+
+func lowerByte(ch byte, nocase bool) byte {
+	if nocase && ch >= 'A' && ch <= 'Z' {
+		return ch + 'a' - 'A'
+	}
+	return ch
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		nocase     bool
+		want       bool
+	}{
+		{"*", "", false, true},
+		{"max*", "maxmemory", false, true},
+		{"max*", "MAXMEMORY", true, true},
+		{"max*", "MAXMEMORY", false, false},
+		{"*-timeout", "shutdown-timeout", false, true},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-c]llo", "hbllo", false, true},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"a*b*c", "axxbyyc", false, true},
+		{"a*b*c", "axxbyy", false, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, MatchGlob(c.pattern, c.s, c.nocase), "%s %s", c.pattern, c.s)
+	}
+}
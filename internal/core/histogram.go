@@ -0,0 +1,61 @@
+package core
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets is the number of bounded buckets of a LatencyHistogram,
+// bucket i counts the durations of up to 2^i microseconds that did not fit
+// in the previous one
+const LatencyBuckets = 25
+
+// LatencyHistogram counts durations in power of two microsecond buckets, it
+// is safe for concurrent use
+type LatencyHistogram struct {
+	// buckets has an extra bucket for the durations over the last bound
+	buckets [LatencyBuckets + 1]atomic.Int64
+	count   atomic.Int64
+	sumUsec atomic.Int64
+}
+
+// LatencyBucketBound returns the upper bound of bucket i in microseconds
+func LatencyBucketBound(i int) int64 {
+	return 1 << i
+}
+
+// Record counts a duration
+func (h *LatencyHistogram) Record(d time.Duration) {
+	usec := d.Microseconds()
+	i := 0
+	if usec > 1 {
+		i = bits.Len64(uint64(usec - 1))
+	}
+	if i > LatencyBuckets {
+		i = LatencyBuckets
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sumUsec.Add(usec)
+}
+
+// Snapshot returns the count of every bucket, the last one holding the
+// durations over the last bound, along with the total count and the sum of
+// the durations in microseconds
+func (h *LatencyHistogram) Snapshot() (buckets []int64, count int64, sumUsec int64) {
+	buckets = make([]int64, len(h.buckets))
+	for i := range h.buckets {
+		buckets[i] = h.buckets[i].Load()
+	}
+	return buckets, h.count.Load(), h.sumUsec.Load()
+}
+
+// Reset zeroes the histogram
+func (h *LatencyHistogram) Reset() {
+	for i := range h.buckets {
+		h.buckets[i].Store(0)
+	}
+	h.count.Store(0)
+	h.sumUsec.Store(0)
+}
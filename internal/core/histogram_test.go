@@ -0,0 +1,21 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogramBuckets(t *testing.T) {
+	var h LatencyHistogram
+	h.Record(500 * time.Nanosecond)
+	h.Record(3 * time.Microsecond)
+	h.Record(4 * time.Microsecond)
+	h.Record(time.Hour)
+	buckets, count, _ := h.Snapshot()
+	assert.Equal(t, int64(4), count)
+	assert.Equal(t, int64(1), buckets[0])
+	assert.Equal(t, int64(2), buckets[2])
+	assert.Equal(t, int64(1), buckets[LatencyBuckets])
+}
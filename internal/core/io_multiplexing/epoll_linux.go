@@ -13,6 +13,8 @@ type Epoll struct {
 	fd            int
 	epollEvents   []syscall.EpollEvent
 	genericEvents []Event
+	// flags are added to the events of every monitored fd
+	flags uint32
 }
 
 func CreateIOMultiplexer(config *config.Config) (*Epoll, error) {
@@ -22,8 +24,15 @@ func CreateIOMultiplexer(config *config.Config) (*Epoll, error) {
 		return nil, err
 	}
 
+	var flags uint32
+	if config.EdgeTriggered {
+		// syscall.EPOLLET is declared as a negative int constant
+		flags = syscall.EPOLLET & 0xffffffff
+	}
+
 	return &Epoll{
 		fd:            epollFD,
+		flags:         flags,
 		epollEvents:   make([]syscall.EpollEvent, config.MaxConnections),
 		genericEvents: make([]Event, config.MaxConnections),
 	}, nil
@@ -31,10 +40,21 @@ func CreateIOMultiplexer(config *config.Config) (*Epoll, error) {
 
 func (ep *Epoll) Monitor(event Event) error {
 	epollEvent := event.toNative()
+	epollEvent.Events |= ep.flags
 	// Add event.Fd to the monitoring list of ep.fd
 	return syscall.EpollCtl(ep.fd, syscall.EPOLL_CTL_ADD, event.Fd, &epollEvent)
 }
 
+func (ep *Epoll) Modify(event Event) error {
+	epollEvent := event.toNative()
+	epollEvent.Events |= ep.flags
+	return syscall.EpollCtl(ep.fd, syscall.EPOLL_CTL_MOD, event.Fd, &epollEvent)
+}
+
+func (ep *Epoll) Remove(fd int) error {
+	return syscall.EpollCtl(ep.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
 func (ep *Epoll) Wait() ([]Event, error) {
 	n, err := syscall.EpollWait(ep.fd, ep.epollEvents, -1)
 	if err != nil {
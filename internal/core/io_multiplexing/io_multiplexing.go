@@ -1,17 +1,63 @@
 package io_multiplexing
 
-const OpRead = 0
-const OpWrite = 1
-
 type Operation uint32
 
+// Operations are bit flags: OpRead and OpWrite can be combined to describe
+// the interest of a fd, while OpHangup and OpError are only reported by Wait
+const (
+	OpRead Operation = 1 << iota
+	OpWrite
+	OpHangup
+	OpError
+)
+
+// Has reports whether every flag of o is set in op
+func (op Operation) Has(o Operation) bool {
+	return op&o == o
+}
+
 type Event struct {
 	Fd int
 	Op Operation
 }
 
 type IOMultiplexer interface {
+	// Monitor adds event.Fd to the monitoring list with the interest of event.Op
 	Monitor(event Event) error
+	// Modify replaces the interest of an already monitored fd with event.Op
+	Modify(event Event) error
+	// Remove stops monitoring fd. It must be called before the fd is closed.
+	Remove(fd int) error
 	Wait() ([]Event, error)
 	Close() error
-}
\ No newline at end of file
+}
+
+// CompletionOp is the kind of operation a Completion reports
+type CompletionOp uint8
+
+const (
+	CompletionAccept CompletionOp = iota
+	CompletionRecv
+	CompletionSend
+)
+
+// Completion is the result of an operation performed by the kernel. Res is
+// the accepted fd for CompletionAccept, the number of bytes transferred for
+// CompletionRecv and CompletionSend, or a negated errno on failure.
+type Completion struct {
+	Fd  int
+	Op  CompletionOp
+	Res int
+}
+
+// CompletionIOMultiplexer is implemented by the backends where the kernel
+// performs the I/O itself (io_uring) instead of reporting readiness. The
+// buffers passed to Recv and Send must not be touched until their
+// completion is returned by WaitCompletions.
+type CompletionIOMultiplexer interface {
+	Accept(fd int) error
+	Recv(fd int, buf []byte) error
+	Send(fd int, buf []byte) error
+	WaitCompletions() ([]Completion, error)
+	Close() error
+}
@@ -0,0 +1,439 @@
+//go:build linux
+
+package io_multiplexing
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+)
+
+// The io_uring ABI, see include/uapi/linux/io_uring.h. The syscall numbers
+// are the same on every architecture.
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	ioringOffSqRing = 0
+	ioringOffCqRing = 0x8000000
+	ioringOffSqes   = 0x10000000
+
+	ioringEnterGetEvents = 1 << 0
+
+	ioringFeatNoDrop   = 1 << 1
+	ioringFeatFastPoll = 1 << 5
+
+	ioringOpPollAdd    = 6
+	ioringOpPollRemove = 7
+	ioringOpAccept     = 13
+	ioringOpSend       = 26
+	ioringOpRecv       = 27
+
+	pollIn    = 0x1
+	pollOut   = 0x4
+	pollErr   = 0x8
+	pollHup   = 0x10
+	pollRdHup = 0x2000
+
+	msgNoSignal = 0x4000
+
+	// ioUringEntries is the size of the submission queue, the kernel makes
+	// the completion queue twice as large
+	ioUringEntries = 4096
+)
+
+// ioUringRequiredFeatures are needed to run sockets on the ring: FAST_POLL
+// (Linux 5.7) retries recv/send on readiness instead of blocking a kernel
+// worker, NODROP never loses completions when the queue overflows
+const ioUringRequiredFeatures = ioringFeatFastPoll | ioringFeatNoDrop
+
+type ioUringSqringOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioUringCqringOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioUringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  ioUringSqringOffsets
+	cqOff                                                                  ioUringCqringOffsets
+}
+
+type ioUringSqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type ioUringCqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// Kinds of submissions, stored in the user data next to the fd. The first
+// ones match CompletionOp.
+const (
+	ioKindAccept            = uint64(CompletionAccept)
+	ioKindRecv              = uint64(CompletionRecv)
+	ioKindSend              = uint64(CompletionSend)
+	ioKindPoll       uint64 = 8
+	ioKindPollRemove uint64 = 9
+)
+
+// userData packs the fd in the low 32 bits, the kind in the next 8 bits and,
+// for polls, a generation in the upper 24 bits so that completions of a
+// poll that was modified or removed since are recognised as stale
+func userData(fd int, kind uint64, gen uint32) uint64 {
+	return uint64(uint32(fd)) | kind<<32 | uint64(gen&0xffffff)<<40
+}
+
+func parseUserData(data uint64) (int, uint64, uint32) {
+	return int(int32(uint32(data))), (data >> 32) & 0xff, uint32(data >> 40)
+}
+
+// ioUringPoll is the readiness interest of a fd monitored through Monitor
+type ioUringPoll struct {
+	mask  uint32
+	gen   uint32
+	armed bool
+}
+
+// IOUring is the io_uring backend. It can be driven as a readiness based
+// IOMultiplexer, using one-shot polls that are re-armed after every event,
+// or as a CompletionIOMultiplexer where the kernel accepts, receives and
+// sends by itself. A ring is driven either through Wait or through
+// WaitCompletions, not both.
+type IOUring struct {
+	fd int
+
+	sqRing  []byte
+	cqRing  []byte
+	sqesMem []byte
+
+	sqHead    *uint32
+	sqTail    *uint32
+	sqMask    uint32
+	sqEntries uint32
+	sqArray   []uint32
+	sqes      []ioUringSqe
+	// toSubmit counts the queued submissions the kernel has not taken yet
+	toSubmit uint32
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []ioUringCqe
+
+	acceptFlags uint32
+	polls       map[int]*ioUringPoll
+	pollGen     uint32
+
+	genericEvents []Event
+	completions   []Completion
+}
+
+// CreateIOUring sets up an io_uring. It fails when the kernel does not
+// support io_uring or lacks the features needed to drive sockets with it.
+func CreateIOUring(cfg *config.Config) (*IOUring, error) {
+	var params ioUringParams
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, ioUringEntries, uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+
+	ring := &IOUring{
+		fd:    int(fd),
+		polls: make(map[int]*ioUringPoll),
+	}
+	if params.features&ioUringRequiredFeatures != ioUringRequiredFeatures {
+		_ = ring.Close()
+		return nil, errors.New("io_uring lacks IORING_FEAT_FAST_POLL or IORING_FEAT_NODROP, Linux 5.7 or later is required")
+	}
+	if err := ring.mmap(&params); err != nil {
+		_ = ring.Close()
+		return nil, err
+	}
+
+	ring.acceptFlags = syscall.SOCK_CLOEXEC
+	if cfg.NonBlockingClients {
+		ring.acceptFlags |= syscall.SOCK_NONBLOCK
+	}
+	return ring, nil
+}
+
+func (r *IOUring) mmap(params *ioUringParams) error {
+	var err error
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	flags := syscall.MAP_SHARED | syscall.MAP_POPULATE
+
+	sqRingSize := int(params.sqOff.array + params.sqEntries*4)
+	if r.sqRing, err = syscall.Mmap(r.fd, ioringOffSqRing, sqRingSize, prot, flags); err != nil {
+		return fmt.Errorf("failed to map the submission queue: %w", err)
+	}
+	cqRingSize := int(params.cqOff.cqes) + int(params.cqEntries)*int(unsafe.Sizeof(ioUringCqe{}))
+	if r.cqRing, err = syscall.Mmap(r.fd, ioringOffCqRing, cqRingSize, prot, flags); err != nil {
+		return fmt.Errorf("failed to map the completion queue: %w", err)
+	}
+	sqesSize := int(params.sqEntries) * int(unsafe.Sizeof(ioUringSqe{}))
+	if r.sqesMem, err = syscall.Mmap(r.fd, ioringOffSqes, sqesSize, prot, flags); err != nil {
+		return fmt.Errorf("failed to map the submission entries: %w", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.ringMask]))
+	r.sqEntries = params.sqEntries
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.array])), params.sqEntries)
+	r.sqes = unsafe.Slice((*ioUringSqe)(unsafe.Pointer(&r.sqesMem[0])), params.sqEntries)
+
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*ioUringCqe)(unsafe.Pointer(&r.cqRing[params.cqOff.cqes])), params.cqEntries)
+	return nil
+}
+
+// enter submits the queued entries and, with ioringEnterGetEvents, waits
+// for at least minComplete completions
+func (r *IOUring) enter(minComplete uint32, flags uint32) error {
+	for {
+		n, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(r.fd), uintptr(r.toSubmit),
+			uintptr(minComplete), uintptr(flags), 0, 0)
+		if errno == syscall.EINTR {
+			// nothing was submitted, the wait was interrupted by a signal
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		r.toSubmit -= uint32(n)
+		return nil
+	}
+}
+
+// submit queues an entry. Nothing reaches the kernel before the next call
+// to enter, so many submissions are batched into a single syscall.
+func (r *IOUring) submit(sqe ioUringSqe) error {
+	tail := atomic.LoadUint32(r.sqTail)
+	if tail-atomic.LoadUint32(r.sqHead) == r.sqEntries {
+		// the submission queue is full, hand it over to the kernel
+		if err := r.enter(0, 0); err != nil {
+			return err
+		}
+	}
+	index := tail & r.sqMask
+	r.sqes[index] = sqe
+	r.sqArray[index] = index
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.toSubmit++
+	return nil
+}
+
+// reap consumes the available completions, sorting them into readiness
+// events and I/O completions
+func (r *IOUring) reap() {
+	head := atomic.LoadUint32(r.cqHead)
+	tail := atomic.LoadUint32(r.cqTail)
+	for ; head != tail; head++ {
+		cqe := r.cqes[head&r.cqMask]
+		fd, kind, gen := parseUserData(cqe.userData)
+		switch kind {
+		case ioKindAccept, ioKindRecv, ioKindSend:
+			r.completions = append(r.completions, Completion{
+				Fd:  fd,
+				Op:  CompletionOp(kind),
+				Res: int(cqe.res),
+			})
+		case ioKindPoll:
+			r.pollDone(fd, gen, cqe.res)
+		}
+	}
+	atomic.StoreUint32(r.cqHead, head)
+}
+
+func (r *IOUring) pollDone(fd int, gen uint32, res int32) {
+	poll, ok := r.polls[fd]
+	if !ok || poll.gen != gen || res == -int32(syscall.ECANCELED) {
+		// the poll was modified or removed after it was submitted
+		return
+	}
+	poll.armed = false
+	var op Operation
+	if res < 0 {
+		op = OpError
+	} else {
+		if res&pollIn != 0 {
+			op |= OpRead
+		}
+		if res&pollOut != 0 {
+			op |= OpWrite
+		}
+		if res&(pollHup|pollRdHup) != 0 {
+			op |= OpHangup
+		}
+		if res&pollErr != 0 {
+			op |= OpError
+		}
+	}
+	r.genericEvents = append(r.genericEvents, Event{Fd: fd, Op: op})
+	// polls are one-shot, re-arm it to get level-triggered semantics
+	_ = r.armPoll(fd, poll)
+}
+
+func (r *IOUring) armPoll(fd int, poll *ioUringPoll) error {
+	if err := r.submit(ioUringSqe{
+		opcode:   ioringOpPollAdd,
+		fd:       int32(fd),
+		opFlags:  poll.mask,
+		userData: userData(fd, ioKindPoll, poll.gen),
+	}); err != nil {
+		return err
+	}
+	poll.armed = true
+	return nil
+}
+
+func (r *IOUring) disarmPoll(fd int, poll *ioUringPoll) error {
+	if !poll.armed {
+		return nil
+	}
+	poll.armed = false
+	return r.submit(ioUringSqe{
+		opcode:   ioringOpPollRemove,
+		fd:       -1,
+		addr:     userData(fd, ioKindPoll, poll.gen),
+		userData: userData(fd, ioKindPollRemove, 0),
+	})
+}
+
+func pollMask(op Operation) uint32 {
+	var mask uint32
+	if op.Has(OpRead) {
+		mask |= pollIn | pollRdHup
+	}
+	if op.Has(OpWrite) {
+		mask |= pollOut
+	}
+	return mask
+}
+
+func (r *IOUring) Monitor(event Event) error {
+	if _, ok := r.polls[event.Fd]; ok {
+		return syscall.EEXIST
+	}
+	r.pollGen++
+	poll := &ioUringPoll{mask: pollMask(event.Op), gen: r.pollGen}
+	r.polls[event.Fd] = poll
+	return r.armPoll(event.Fd, poll)
+}
+
+func (r *IOUring) Modify(event Event) error {
+	poll, ok := r.polls[event.Fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if err := r.disarmPoll(event.Fd, poll); err != nil {
+		return err
+	}
+	r.pollGen++
+	poll.gen = r.pollGen
+	poll.mask = pollMask(event.Op)
+	return r.armPoll(event.Fd, poll)
+}
+
+func (r *IOUring) Remove(fd int) error {
+	poll, ok := r.polls[fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+	delete(r.polls, fd)
+	return r.disarmPoll(fd, poll)
+}
+
+func (r *IOUring) Wait() ([]Event, error) {
+	r.genericEvents = r.genericEvents[:0]
+	for len(r.genericEvents) == 0 {
+		if err := r.enter(1, ioringEnterGetEvents); err != nil {
+			return nil, err
+		}
+		r.reap()
+	}
+	return r.genericEvents, nil
+}
+
+// Accept asks the kernel to accept a connection on the listening fd
+func (r *IOUring) Accept(fd int) error {
+	return r.submit(ioUringSqe{
+		opcode:   ioringOpAccept,
+		fd:       int32(fd),
+		opFlags:  r.acceptFlags,
+		userData: userData(fd, ioKindAccept, 0),
+	})
+}
+
+// Recv asks the kernel to receive data from fd into buf
+func (r *IOUring) Recv(fd int, buf []byte) error {
+	return r.submit(ioUringSqe{
+		opcode:   ioringOpRecv,
+		fd:       int32(fd),
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:      uint32(len(buf)),
+		userData: userData(fd, ioKindRecv, 0),
+	})
+}
+
+// Send asks the kernel to send buf on fd
+func (r *IOUring) Send(fd int, buf []byte) error {
+	return r.submit(ioUringSqe{
+		opcode:   ioringOpSend,
+		fd:       int32(fd),
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:      uint32(len(buf)),
+		opFlags:  msgNoSignal,
+		userData: userData(fd, ioKindSend, 0),
+	})
+}
+
+// WaitCompletions submits every queued operation with a single syscall and
+// waits until at least one of them completes
+func (r *IOUring) WaitCompletions() ([]Completion, error) {
+	r.completions = r.completions[:0]
+	for len(r.completions) == 0 {
+		if err := r.enter(1, ioringEnterGetEvents); err != nil {
+			return nil, err
+		}
+		r.reap()
+	}
+	return r.completions, nil
+}
+
+func (r *IOUring) Close() error {
+	for _, mem := range [][]byte{r.sqesMem, r.cqRing, r.sqRing} {
+		if mem != nil {
+			_ = syscall.Munmap(mem)
+		}
+	}
+	r.sqesMem, r.cqRing, r.sqRing = nil, nil, nil
+	return syscall.Close(r.fd)
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package io_multiplexing
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestIOUring(t *testing.T) *IOUring {
+	ring, err := CreateIOUring(&config.Config{MaxConnections: 16})
+	if err != nil {
+		t.Skipf("io_uring is not supported: %v", err)
+	}
+	return ring
+}
+
+func TestIOUringSendRecv(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	ring := createTestIOUring(t)
+	defer ring.Close()
+
+	buf := make([]byte, 64)
+	assert.Nil(t, ring.Recv(fds[1], buf))
+	assert.Nil(t, ring.Send(fds[0], []byte("+PONG\r\n")))
+
+	var completions []Completion
+	for len(completions) < 2 {
+		batch, err := ring.WaitCompletions()
+		assert.Nil(t, err)
+		completions = append(completions, batch...)
+	}
+	for _, completion := range completions {
+		assert.Equal(t, 7, completion.Res)
+		if completion.Op == CompletionRecv {
+			assert.Equal(t, fds[1], completion.Fd)
+		} else {
+			assert.Equal(t, CompletionSend, completion.Op)
+			assert.Equal(t, fds[0], completion.Fd)
+		}
+	}
+	assert.Equal(t, "+PONG\r\n", string(buf[:7]))
+}
+
+func TestIOUringPollModifyAndRemove(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	ring := createTestIOUring(t)
+	defer ring.Close()
+
+	assert.Nil(t, ring.Monitor(Event{Fd: fds[0], Op: OpRead}))
+	assert.Nil(t, ring.Modify(Event{Fd: fds[0], Op: OpRead | OpWrite}))
+	events, err := ring.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.True(t, events[0].Op.Has(OpWrite))
+	assert.False(t, events[0].Op.Has(OpRead))
+
+	assert.Nil(t, ring.Remove(fds[0]))
+	assert.Equal(t, syscall.ENOENT, ring.Modify(Event{Fd: fds[0], Op: OpRead}))
+}
@@ -13,6 +13,8 @@ type KQueue struct {
 	fd            int
 	kqEvents      []syscall.Kevent_t
 	genericEvents []Event
+	// flags are added to the filters of every monitored fd
+	flags uint16
 }
 
 func CreateIOMultiplexer(config *config.Config) (*KQueue, error) {
@@ -22,20 +24,46 @@ func CreateIOMultiplexer(config *config.Config) (*KQueue, error) {
 		return nil, err
 	}
 
+	var flags uint16
+	if config.EdgeTriggered {
+		flags = syscall.EV_CLEAR
+	}
+
 	return &KQueue{
 		fd:            epollFD,
+		flags:         flags,
 		kqEvents:      make([]syscall.Kevent_t, config.MaxConnections),
 		genericEvents: make([]Event, config.MaxConnections),
 	}, nil
 }
 
 func (kq *KQueue) Monitor(event Event) error {
-	kqEvent := event.toNative(syscall.EV_ADD)
+	kqEvents := event.toNative(syscall.EV_ADD|kq.flags, 0)
 	// Add event.Fd to the monitoring list of kq.fd
-	_, err := syscall.Kevent(kq.fd, []syscall.Kevent_t{kqEvent}, nil, nil)
+	_, err := syscall.Kevent(kq.fd, kqEvents, nil, nil)
+	return err
+}
+
+func (kq *KQueue) Modify(event Event) error {
+	// filters outside of the interest stay registered but disabled, so
+	// switching between read and write interest never fails with ENOENT
+	kqEvents := event.toNative(syscall.EV_ADD|syscall.EV_ENABLE|kq.flags, syscall.EV_ADD|syscall.EV_DISABLE|kq.flags)
+	_, err := syscall.Kevent(kq.fd, kqEvents, nil, nil)
 	return err
 }
 
+func (kq *KQueue) Remove(fd int) error {
+	// the filters are deleted one by one as the fd may not have both of them
+	event := Event{Fd: fd, Op: OpRead | OpWrite}
+	for _, kqEvent := range event.toNative(syscall.EV_DELETE, 0) {
+		_, err := syscall.Kevent(kq.fd, []syscall.Kevent_t{kqEvent}, nil, nil)
+		if err != nil && err != syscall.ENOENT {
+			return err
+		}
+	}
+	return nil
+}
+
 func (kq *KQueue) Wait() ([]Event, error) {
 	n, err := syscall.Kevent(kq.fd, nil, kq.kqEvents, nil)
 	if err != nil {
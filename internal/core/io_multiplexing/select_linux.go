@@ -0,0 +1,26 @@
+//go:build linux
+
+package io_multiplexing
+
+import (
+	"log"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+)
+
+// NewIOMultiplexer creates the backend selected by config.IOBackend. When
+// io_uring is selected but the kernel does not support it, epoll is used.
+func NewIOMultiplexer(cfg *config.Config) (IOMultiplexer, error) {
+	if cfg.IOBackend == config.IOBackendIOUring {
+		ring, err := CreateIOUring(cfg)
+		if err == nil {
+			return ring, nil
+		}
+		log.Printf("io_uring is not available, falling back to epoll: %v\n", err)
+	}
+	ep, err := CreateIOMultiplexer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
@@ -0,0 +1,22 @@
+//go:build darwin
+
+package io_multiplexing
+
+import (
+	"log"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+)
+
+// NewIOMultiplexer creates the backend selected by config.IOBackend.
+// io_uring only exists on Linux, kqueue is always used on macOS.
+func NewIOMultiplexer(cfg *config.Config) (IOMultiplexer, error) {
+	if cfg.IOBackend == config.IOBackendIOUring {
+		log.Println("io_uring is not available on macOS, falling back to kqueue")
+	}
+	kq, err := CreateIOMultiplexer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return kq, nil
+}
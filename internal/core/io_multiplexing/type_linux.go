@@ -5,9 +5,12 @@ package io_multiplexing
 import "syscall"
 
 func (e Event) toNative() syscall.EpollEvent {
-	var event uint32 = syscall.EPOLLIN
-	if e.Op == OpWrite {
-		event = syscall.EPOLLOUT
+	var event uint32
+	if e.Op.Has(OpRead) {
+		event |= syscall.EPOLLIN | syscall.EPOLLRDHUP
+	}
+	if e.Op.Has(OpWrite) {
+		event |= syscall.EPOLLOUT
 	}
 	return syscall.EpollEvent{
 		Fd:     int32(e.Fd),
@@ -16,12 +19,21 @@ func (e Event) toNative() syscall.EpollEvent {
 }
 
 func createEvent(ep syscall.EpollEvent) Event {
-	var op Operation = OpRead
-	if ep.Events == syscall.EPOLLOUT {
-		op = OpWrite
+	var op Operation
+	if ep.Events&(syscall.EPOLLIN|syscall.EPOLLPRI) != 0 {
+		op |= OpRead
+	}
+	if ep.Events&syscall.EPOLLOUT != 0 {
+		op |= OpWrite
+	}
+	if ep.Events&(syscall.EPOLLHUP|syscall.EPOLLRDHUP) != 0 {
+		op |= OpHangup
+	}
+	if ep.Events&syscall.EPOLLERR != 0 {
+		op |= OpError
 	}
 	return Event{
 		Fd: int(ep.Fd),
 		Op: op,
 	}
-}
\ No newline at end of file
+}
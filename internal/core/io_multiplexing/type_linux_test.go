@@ -0,0 +1,49 @@
+//go:build linux
+
+package io_multiplexing
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateEventReportsAllFlags(t *testing.T) {
+	cases := map[uint32]Operation{
+		syscall.EPOLLIN:                      OpRead,
+		syscall.EPOLLOUT:                     OpWrite,
+		syscall.EPOLLIN | syscall.EPOLLOUT:   OpRead | OpWrite,
+		syscall.EPOLLIN | syscall.EPOLLRDHUP: OpRead | OpHangup,
+		syscall.EPOLLERR | syscall.EPOLLHUP:  OpError | OpHangup,
+		syscall.EPOLLOUT | syscall.EPOLLERR:  OpWrite | OpError,
+	}
+	for events, op := range cases {
+		event := createEvent(syscall.EpollEvent{Fd: 7, Events: events})
+		assert.Equal(t, 7, event.Fd)
+		assert.Equal(t, op, event.Op)
+	}
+}
+
+func TestEpollModifyAndRemove(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	ep, err := CreateIOMultiplexer(&config.Config{MaxConnections: 16})
+	assert.Nil(t, err)
+	defer ep.Close()
+
+	assert.Nil(t, ep.Monitor(Event{Fd: fds[0], Op: OpRead}))
+	assert.Nil(t, ep.Modify(Event{Fd: fds[0], Op: OpRead | OpWrite}))
+	events, err := ep.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.True(t, events[0].Op.Has(OpWrite))
+	assert.False(t, events[0].Op.Has(OpRead))
+
+	assert.Nil(t, ep.Remove(fds[0]))
+	assert.Equal(t, syscall.ENOENT, ep.Modify(Event{Fd: fds[0], Op: OpRead}))
+}
@@ -4,16 +4,28 @@ package io_multiplexing
 
 import "syscall"
 
-func (e Event) toNative(flags uint16) syscall.Kevent_t {
-	var filter int16 = syscall.EVFILT_WRITE
-	if e.Op == OpRead {
-		filter = syscall.EVFILT_READ
-	}
-	return syscall.Kevent_t{
-		Ident:  uint64(e.Fd),
-		Filter: filter,
-		Flags:  flags,
+// toNative returns one kevent per filter. Filters that are part of the
+// interest of e get flags, the others get otherFlags (0 skips them).
+func (e Event) toNative(flags uint16, otherFlags uint16) []syscall.Kevent_t {
+	var kqEvents []syscall.Kevent_t
+	for _, f := range []struct {
+		op     Operation
+		filter int16
+	}{{OpRead, syscall.EVFILT_READ}, {OpWrite, syscall.EVFILT_WRITE}} {
+		eventFlags := otherFlags
+		if e.Op.Has(f.op) {
+			eventFlags = flags
+		}
+		if eventFlags == 0 {
+			continue
+		}
+		kqEvents = append(kqEvents, syscall.Kevent_t{
+			Ident:  uint64(e.Fd),
+			Filter: f.filter,
+			Flags:  eventFlags,
+		})
 	}
+	return kqEvents
 }
 
 func createEvent(kq syscall.Kevent_t) Event {
@@ -21,8 +33,14 @@ func createEvent(kq syscall.Kevent_t) Event {
 	if kq.Filter == syscall.EVFILT_READ {
 		op = OpRead
 	}
+	if kq.Flags&syscall.EV_EOF != 0 {
+		op |= OpHangup
+	}
+	if kq.Flags&syscall.EV_ERROR != 0 {
+		op |= OpError
+	}
 	return Event{
 		Fd: int(kq.Ident),
 		Op: op,
 	}
-}
\ No newline at end of file
+}
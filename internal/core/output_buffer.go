@@ -0,0 +1,109 @@
+package core
+
+import "syscall"
+
+// OutputBufferStaticSize is the size of the fixed reply buffer. Small replies
+// are packed into it; anything that does not fit spills into the reply list.
+const OutputBufferStaticSize = 16 * 1024
+
+// OutputBuffer holds the replies that have not been written to a client yet.
+// Replies are appended to the static buffer while the reply list is empty, so
+// the static buffer always precedes the reply list on the wire.
+type OutputBuffer struct {
+	buf     [OutputBufferStaticSize]byte
+	bufPos  int
+	sentLen int
+	replies [][]byte
+	// repliesBytes is the number of bytes held in the reply list.
+	repliesBytes int
+}
+
+// AddReply queues data to be sent to the client
+func (b *OutputBuffer) AddReply(data []byte) {
+	if len(b.replies) == 0 && len(data) <= len(b.buf)-b.bufPos {
+		b.bufPos += copy(b.buf[b.bufPos:], data)
+		return
+	}
+	reply := make([]byte, len(data))
+	copy(reply, data)
+	b.replies = append(b.replies, reply)
+	b.repliesBytes += len(reply)
+}
+
+// HasPendingReplies reports whether there is data left to be written
+func (b *OutputBuffer) HasPendingReplies() bool {
+	return b.bufPos > 0 || len(b.replies) > 0
+}
+
+// Size returns the number of bytes that are still waiting to be written
+func (b *OutputBuffer) Size() int {
+	return b.bufPos - b.sentLen + b.repliesBytes
+}
+
+// Usage returns the number of bytes in the static buffer, the number of
+// replies in the reply list and the bytes they hold
+func (b *OutputBuffer) Usage() (bufBytes, listLen, listBytes int) {
+	return b.bufPos - b.sentLen, len(b.replies), b.repliesBytes
+}
+
+// Peek returns the next chunk of pending data without consuming it, it is
+// empty when there is nothing to write. The chunk stays valid until it is
+// consumed with Advance.
+func (b *OutputBuffer) Peek() []byte {
+	if b.bufPos > 0 {
+		return b.buf[b.sentLen:b.bufPos]
+	}
+	if len(b.replies) > 0 {
+		return b.replies[0][b.sentLen:]
+	}
+	return nil
+}
+
+// Advance marks n bytes of the chunk returned by Peek as written
+func (b *OutputBuffer) Advance(n int) {
+	b.sentLen += n
+	if b.bufPos > 0 {
+		if b.sentLen == b.bufPos {
+			b.bufPos = 0
+			b.sentLen = 0
+		}
+		return
+	}
+	if len(b.replies) > 0 && b.sentLen == len(b.replies[0]) {
+		b.repliesBytes -= len(b.replies[0])
+		b.replies[0] = nil
+		b.replies = b.replies[1:]
+		b.sentLen = 0
+	}
+}
+
+// Flush writes as much of the pending data as the socket accepts. It returns
+// the number of bytes written; a full socket buffer (EAGAIN) is not an error,
+// the remaining data simply stays queued.
+func (b *OutputBuffer) Flush(fd int) (int, error) {
+	total := 0
+	for b.HasPendingReplies() {
+		chunk := b.Peek()
+		n, err := send(fd, chunk)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				return total, nil
+			}
+			return total, err
+		}
+		total += n
+		b.Advance(n)
+		if n < len(chunk) {
+			// short write, the socket buffer is full
+			return total, nil
+		}
+	}
+	return total, nil
+}
+
+// send writes data to the socket fd without ever blocking, even when the fd
+// itself is in blocking mode: a full socket buffer yields EAGAIN or a short
+// write instead of stalling the event loop.
+func send(fd int, data []byte) (int, error) {
+	return syscall.SendmsgN(fd, data, nil, nil, syscall.MSG_DONTWAIT)
+}
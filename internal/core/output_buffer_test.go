@@ -0,0 +1,70 @@
+package core
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputBufferSpillsIntoReplyList(t *testing.T) {
+	var out OutputBuffer
+	out.AddReply([]byte("+OK\r\n"))
+	assert.Equal(t, 0, len(out.replies))
+
+	big := bytes.Repeat([]byte("x"), OutputBufferStaticSize)
+	out.AddReply(big)
+	out.AddReply([]byte("+OK\r\n"))
+	// once the reply list is used, later replies must follow it
+	assert.Equal(t, 2, len(out.replies))
+	assert.Equal(t, 5+OutputBufferStaticSize+5, out.Size())
+}
+
+func TestOutputBufferFlushKeepsOrder(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	assert.Nil(t, syscall.SetNonblock(fds[0], true))
+
+	var out OutputBuffer
+	var expected []byte
+	for i := 0; i < 64; i++ {
+		reply := Encode(string(bytes.Repeat([]byte{byte('a' + i%26)}, 4096)), false)
+		out.AddReply(reply)
+		expected = append(expected, reply...)
+	}
+
+	var received []byte
+	buf := make([]byte, 64*1024)
+	for out.HasPendingReplies() {
+		_, err := out.Flush(fds[0])
+		assert.Nil(t, err)
+		n, err := syscall.Read(fds[1], buf)
+		assert.Nil(t, err)
+		received = append(received, buf[:n]...)
+	}
+	for len(received) < len(expected) {
+		n, err := syscall.Read(fds[1], buf)
+		assert.Nil(t, err)
+		received = append(received, buf[:n]...)
+	}
+	assert.Equal(t, 0, out.Size())
+	assert.Equal(t, expected, received)
+}
+
+func TestOutputBufferFlushDoesNotBlock(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	// the socket is left blocking and nobody reads the other end
+	var out OutputBuffer
+	out.AddReply(bytes.Repeat([]byte("x"), 16*1024*1024))
+	n, err := out.Flush(fds[0])
+	assert.Nil(t, err)
+	assert.Less(t, n, 16*1024*1024)
+	assert.True(t, out.HasPendingReplies())
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -12,12 +13,36 @@ const CRLF string = "\r\n"
 
 var RespNil = []byte("$-1\r\n")
 
+// ErrIncomplete is returned while decoding when data ends before the value
+// does, more bytes must be read from the client before retrying
+var ErrIncomplete = errors.New("incomplete RESP value")
+
+// maxMultiBulkLen bounds the number of elements of a decoded array so that a
+// bogus length cannot make the server allocate unbounded memory
+const maxMultiBulkLen = 1024 * 1024
+
+// minArgsCap is the number of elements allocated up front for an array,
+// more are allocated as they arrive
+const minArgsCap = 1024
+
+// maxHeaderLen bounds the length of the *<count> and $<length> lines, a
+// client sending a longer one is not speaking RESP
+const maxHeaderLen = 64 * 1024
+
+var (
+	errInvalidBulkLen      = errors.New("ERR Protocol error: invalid bulk length")
+	errInvalidMultiBulkLen = errors.New("ERR Protocol error: invalid multibulk length")
+)
+
 // +OK\r\n => OK, 5
 func readSimpleString(data []byte) (string, int, error) {
 	pos := 1
 	for pos < len(data) && data[pos] != '\r' {
 		pos++
 	}
+	if pos+1 >= len(data) {
+		return "", 0, ErrIncomplete
+	}
 	return string(data[1:pos]), pos + 2, nil
 }
 
@@ -27,7 +52,13 @@ func readInt64(data []byte) (int64, int, error) {
 	for pos < len(data) && data[pos] != '\r' {
 		pos++
 	}
-	value, _ := strconv.ParseInt(string(data[1:pos]), 10, 64)
+	if pos+1 >= len(data) {
+		return 0, 0, ErrIncomplete
+	}
+	value, err := strconv.ParseInt(string(data[1:pos]), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("ERR Protocol error: invalid integer")
+	}
 	return value, pos + 2, nil
 }
 
@@ -36,28 +67,52 @@ func readError(data []byte) (string, int, error) {
 }
 
 // $5\r\nhello\r\n => 5, 4
-func readLen(data []byte) (int, int) {
-	res, pos, _ := readInt64(data)
-	return int(res), pos
+func readLen(data []byte) (int, int, error) {
+	res, pos, err := readInt64(data)
+	return int(res), pos, err
 }
 
 // $5\r\nhello\r\n => "hello"
-func readBulkString(data []byte) (string, int, error) {
-	length, pos := readLen(data)
-	return string(data[pos: pos+length]), pos + length + 2, nil
+func readBulkString(data []byte) (any, int, error) {
+	length, pos, err := readLen(data)
+	if err == ErrIncomplete {
+		return nil, 0, err
+	}
+	if err != nil {
+		return nil, 0, errInvalidBulkLen
+	}
+	if length < 0 {
+		// $-1\r\n is the null bulk string
+		return nil, pos, nil
+	}
+	if pos+length+2 > len(data) {
+		return nil, 0, ErrIncomplete
+	}
+	return string(data[pos : pos+length]), pos + length + 2, nil
 }
 
 // *2\r\n$5\r\nhello\r\n$5\r\nworld\r\n => {"hello", "world"}
 func readArray(data []byte) (any, int, error) {
-	length, pos := readLen(data)
-	var res []any = make([]any, length)
+	length, pos, err := readLen(data)
+	if err == ErrIncomplete {
+		return nil, 0, err
+	}
+	if err != nil || length > maxMultiBulkLen {
+		return nil, 0, errInvalidMultiBulkLen
+	}
+	if length < 0 {
+		return nil, pos, nil
+	}
+	// the elements are only allocated as they are decoded, a bogus length
+	// costs nothing
+	res := make([]any, 0, min(length, minArgsCap))
 	// implement start
 	for i := 0; i < length; i++ {
 		val, delta, err := DecodeOne(data[pos:])
 		if err != nil {
 			return nil, 0, err
 		}
-		res[i] = val
+		res = append(res, val)
 		pos += delta
 	}
 	// implement end
@@ -66,7 +121,7 @@ func readArray(data []byte) (any, int, error) {
 
 func DecodeOne(data []byte) (any, int, error) {
 	if len(data) == 0 {
-		return nil, 0, errors.New("no data")
+		return nil, 0, ErrIncomplete
 	}
 	switch data[0] {
 	case '+':
@@ -80,7 +135,7 @@ func DecodeOne(data []byte) (any, int, error) {
 	case '*':
 		return readArray(data)
 	}
-	return nil, 0, nil
+	return nil, 0, fmt.Errorf("ERR Protocol error: unexpected type byte '%c'", data[0])
 }
 
 func Decode(data []byte) (any, error) {
@@ -134,17 +189,127 @@ func Encode(value any, isSimpleString bool) []byte {
 	}
 }
 
+// ParseCmd parses a command sent as an array of bulk strings
 func ParseCmd(data []byte) (*Command, error) {
-	value, err := Decode(data)
+	var p CommandParser
+	cmd, _, err := p.Parse(data, math.MaxInt64)
+	if err == nil && cmd == nil {
+		return nil, errors.New("ERR Protocol error: expected a non empty array of bulk strings")
+	}
+	return cmd, err
+}
+
+// CommandParser parses the commands a client sends, arrays of bulk strings,
+// as they are read. The arguments of a partially received command are kept
+// between reads along with the number still to come, so the bytes already
+// parsed are not parsed again and nothing is allocated before the data it
+// holds arrived.
+type CommandParser struct {
+	// multiBulkLen is the number of arguments of the current command still
+	// to be parsed, 0 between commands. bulkLen is the length of the next
+	// one, -1 until its $<length> line is parsed.
+	multiBulkLen int
+	bulkLen      int64
+	args         []string
+	// argsLen is the number of bytes held by args
+	argsLen int64
+}
+
+// Pending returns the number of bytes held for the command being received
+func (p *CommandParser) Pending() int64 {
+	return p.argsLen
+}
+
+// Parse parses data, the bytes that follow the ones consumed by the previous
+// calls. It returns the next command once it is complete and the number of
+// bytes it consumed, which are not to be passed again. The error is
+// ErrIncomplete when more bytes are needed, the bytes consumed so far are
+// still reported then. The command is nil for an empty array, which is
+// skipped. An argument longer than maxBulkLen is a protocol error.
+func (p *CommandParser) Parse(data []byte, maxBulkLen int64) (*Command, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrIncomplete
+	}
+	pos := 0
+	if p.multiBulkLen == 0 {
+		if data[0] != '*' {
+			return nil, 0, fmt.Errorf("ERR Protocol error: expected '*', got '%c'", data[0])
+		}
+		count, n, err := readHeader(data, "mbulk")
+		if err != nil {
+			return nil, 0, err
+		}
+		if count > maxMultiBulkLen {
+			return nil, 0, errInvalidMultiBulkLen
+		}
+		pos = n
+		if count <= 0 {
+			return nil, pos, nil
+		}
+		p.multiBulkLen = int(count)
+		p.bulkLen = -1
+		p.args = make([]string, 0, min(p.multiBulkLen, minArgsCap))
+	}
+
+	for p.multiBulkLen > 0 {
+		if p.bulkLen == -1 {
+			if pos == len(data) {
+				return nil, pos, ErrIncomplete
+			}
+			if data[pos] != '$' {
+				return nil, 0, fmt.Errorf("ERR Protocol error: expected '$', got '%c'", data[pos])
+			}
+			length, n, err := readHeader(data[pos:], "bulk")
+			if err == ErrIncomplete {
+				return nil, pos, err
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			if length < 0 || length > maxBulkLen {
+				return nil, 0, errInvalidBulkLen
+			}
+			p.bulkLen = length
+			pos += n
+		}
+		if int64(len(data)-pos) < p.bulkLen+2 {
+			return nil, pos, ErrIncomplete
+		}
+		p.args = append(p.args, string(data[pos:pos+int(p.bulkLen)]))
+		p.argsLen += p.bulkLen
+		pos += int(p.bulkLen) + 2
+		p.bulkLen = -1
+		p.multiBulkLen--
+	}
+
+	cmd := &Command{Cmd: strings.ToUpper(p.args[0]), Args: p.args[1:]}
+	p.args = nil
+	p.argsLen = 0
+	return cmd, pos, nil
+}
+
+// readHeader parses the *<count> or $<length> line at the start of data
+func readHeader(data []byte, kind string) (int64, int, error) {
+	end := bytes.IndexByte(data, '\n')
+	if end == -1 {
+		if len(data) > maxHeaderLen {
+			return 0, 0, fmt.Errorf("ERR Protocol error: too big %s count string", kind)
+		}
+		return 0, 0, ErrIncomplete
+	}
+	if end < 2 || data[end-1] != '\r' {
+		return 0, 0, invalidLen(kind)
+	}
+	value, err := strconv.ParseInt(string(data[1:end-1]), 10, 64)
 	if err != nil {
-		return nil, err
+		return 0, 0, invalidLen(kind)
 	}
+	return value, end + 1, nil
+}
 
-	array := value.([]any)
-	tokens := make([]string, len(array))
-	for i := range tokens {
-		tokens[i] = array[i].(string)
+func invalidLen(kind string) error {
+	if kind == "bulk" {
+		return errInvalidBulkLen
 	}
-	res := &Command{Cmd: strings.ToUpper(tokens[0]), Args: tokens[1:]}
-	return res, nil
-}
\ No newline at end of file
+	return errInvalidMultiBulkLen
+}
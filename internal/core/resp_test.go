@@ -93,4 +93,52 @@ func TestEncodeString2DArray(t *testing.T) {
 			assert.EqualValues(t, decode[i][j], decodeAgain.([]any)[i].([]any)[j])
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestCommandParser(t *testing.T) {
+	data := []byte("*1\r\n$4\r\nPING\r\n*0\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n")
+	var p CommandParser
+	cmd, n, err := p.Parse(data, 512)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "PING", cmd.Cmd)
+	assert.Equal(t, 14, n)
+	// empty arrays are skipped
+	cmd, n, err = p.Parse(data[14:], 512)
+	assert.Nil(t, err)
+	assert.Nil(t, cmd)
+	assert.Equal(t, 4, n)
+
+	// fed a byte at a time, the bytes consumed are never passed again
+	var commands []*Command
+	pending := []byte{}
+	for _, b := range data {
+		pending = append(pending, b)
+		cmd, n, err := p.Parse(pending, 512)
+		pending = pending[n:]
+		if err == ErrIncomplete {
+			continue
+		}
+		assert.Nil(t, err)
+		if cmd != nil {
+			commands = append(commands, cmd)
+		}
+	}
+	assert.Empty(t, pending)
+	if assert.Len(t, commands, 2) {
+		assert.Equal(t, &Command{Cmd: "GET", Args: []string{"a"}}, commands[1])
+	}
+
+	for in, expected := range map[string]string{
+		"*1\r\n$abc\r\n\r\n":       "ERR Protocol error: invalid bulk length",
+		"*x\r\n":                   "ERR Protocol error: invalid multibulk length",
+		"*2097152\r\n":             "ERR Protocol error: invalid multibulk length",
+		"*1\r\n$513\r\n":           "ERR Protocol error: invalid bulk length",
+		"*1\r\n$-1\r\n":            "ERR Protocol error: invalid bulk length",
+		"*1048576\r\n*1048576\r\n": "ERR Protocol error: expected '$', got '*'",
+		"PING\r\n":                 "ERR Protocol error: expected '*', got 'P'",
+	} {
+		var p CommandParser
+		_, _, err := p.Parse([]byte(in), 512)
+		assert.EqualError(t, err, expected, in)
+	}
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the server wide counters reported by INFO. In sharded mode
+// every loop updates the same Stats, all the counters are safe for
+// concurrent use.
+type Stats struct {
+	// StartTime is when the server was created, the uptime counts from it
+	StartTime time.Time
+	// ClientOutputBufferLimitDisconnections counts the clients closed for
+	// going over their client-output-buffer-limit
+	ClientOutputBufferLimitDisconnections atomic.Int64
+	// ClientQueryBufferLimitDisconnections counts the clients closed for
+	// going over client-query-buffer-limit
+	ClientQueryBufferLimitDisconnections atomic.Int64
+	// ConnectedClients and BlockedClients are the clients currently
+	// connected and waiting for the reply of another loop
+	ConnectedClients         atomic.Int64
+	BlockedClients           atomic.Int64
+	TotalConnectionsReceived atomic.Int64
+	// RejectedConnections counts the connections closed for going over
+	// maxclients
+	RejectedConnections    atomic.Int64
+	TotalCommandsProcessed atomic.Int64
+	TotalErrorReplies      atomic.Int64
+	// ACLAccessDeniedAuth counts the failed authentications, the others
+	// the commands the ACL refused for the command or a key
+	ACLAccessDeniedAuth atomic.Int64
+	ACLAccessDeniedCmd  atomic.Int64
+	ACLAccessDeniedKey  atomic.Int64
+	// NetInputBytes and NetOutputBytes count the bytes read from and
+	// written to the client sockets
+	NetInputBytes  atomic.Int64
+	NetOutputBytes atomic.Int64
+	// UsedMemory is the heap of the Go runtime as last sampled, maxmemory
+	// is checked against it. It is not a counter, Reset leaves it alone.
+	UsedMemory atomic.Int64
+	// EventLoopLatency is the time the event loops spend handling the
+	// events of an iteration, waiting for them excluded
+	EventLoopLatency LatencyHistogram
+
+	// commands holds an entry for every command of the command table, it
+	// is never modified after NewStats so it can be read without locking
+	commands map[string]*CommandStats
+
+	errorsMu sync.Mutex
+	// errors counts the error replies by error code
+	errors map[string]int64
+
+	metricsMu sync.Mutex
+	ops       instantaneousMetric
+	netInput  instantaneousMetric
+	netOutput instantaneousMetric
+}
+
+// CommandStats are the counters of a command shown by INFO commandstats
+type CommandStats struct {
+	Calls atomic.Int64
+	// Usec is the total execution time in microseconds
+	Usec atomic.Int64
+	// FailedCalls counts the calls that replied an error
+	FailedCalls atomic.Int64
+	// Latency is the distribution of the execution times
+	Latency LatencyHistogram
+}
+
+// maxErrorCodes bounds the number of distinct error codes tracked, new
+// codes are not counted once it is reached
+const maxErrorCodes = 128
+
+func NewStats() *Stats {
+	s := &Stats{
+		StartTime: time.Now(),
+		commands:  make(map[string]*CommandStats, len(commandTable)),
+		errors:    make(map[string]int64),
+	}
+	for name := range commandTable {
+		s.commands[name] = &CommandStats{}
+	}
+	return s
+}
+
+// Reset zeroes the counters, it implements CONFIG RESETSTAT. The gauges,
+// like the connected clients, are kept.
+func (s *Stats) Reset() {
+	s.ClientOutputBufferLimitDisconnections.Store(0)
+	s.ClientQueryBufferLimitDisconnections.Store(0)
+	s.TotalConnectionsReceived.Store(0)
+	s.RejectedConnections.Store(0)
+	s.TotalCommandsProcessed.Store(0)
+	s.TotalErrorReplies.Store(0)
+	s.ACLAccessDeniedAuth.Store(0)
+	s.ACLAccessDeniedCmd.Store(0)
+	s.ACLAccessDeniedKey.Store(0)
+	s.NetInputBytes.Store(0)
+	s.NetOutputBytes.Store(0)
+	for _, cs := range s.commands {
+		cs.Calls.Store(0)
+		cs.Usec.Store(0)
+		cs.FailedCalls.Store(0)
+		cs.Latency.Reset()
+	}
+	s.EventLoopLatency.Reset()
+	s.errorsMu.Lock()
+	s.errors = make(map[string]int64)
+	s.errorsMu.Unlock()
+}
+
+// Command returns the counters of a command, nil for an unknown command
+func (s *Stats) Command(name string) *CommandStats {
+	return s.commands[name]
+}
+
+// CommandNames returns the names of the commands that were called, in
+// alphabetical order
+func (s *Stats) CommandNames() []string {
+	var names []string
+	for name, cs := range s.commands {
+		if cs.Calls.Load() > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RecordCall counts a call of a command that took d to run. errReply is
+// the error it replied, nil when it succeeded. Unknown commands only have
+// their error counted.
+func (s *Stats) RecordCall(name string, d time.Duration, errReply []byte) {
+	if cs := s.commands[name]; cs != nil {
+		s.TotalCommandsProcessed.Add(1)
+		cs.Calls.Add(1)
+		cs.Usec.Add(d.Microseconds())
+		cs.Latency.Record(d)
+		if errReply != nil {
+			cs.FailedCalls.Add(1)
+		}
+	}
+	if errReply != nil {
+		s.RecordError(errReply)
+	}
+}
+
+// RecordError counts an error reply under its error code, the first word
+// of the message
+func (s *Stats) RecordError(reply []byte) {
+	s.TotalErrorReplies.Add(1)
+	msg := strings.TrimPrefix(string(reply), "-")
+	if len(msg) > 32 {
+		msg = msg[:32]
+	}
+	code, _, found := strings.Cut(msg, " ")
+	if !found {
+		code = "ERR"
+	}
+	s.errorsMu.Lock()
+	defer s.errorsMu.Unlock()
+	if _, ok := s.errors[code]; ok || len(s.errors) < maxErrorCodes {
+		s.errors[code]++
+	}
+}
+
+// ErrorCounts returns a copy of the error reply counts by error code
+func (s *Stats) ErrorCounts() map[string]int64 {
+	s.errorsMu.Lock()
+	defer s.errorsMu.Unlock()
+	counts := make(map[string]int64, len(s.errors))
+	for code, n := range s.errors {
+		counts[code] = n
+	}
+	return counts
+}
+
+// metricSamples is the number of samples the instantaneous metrics are
+// averaged over
+const metricSamples = 16
+
+// instantaneousMetric turns a counter into a rate, averaged over the last
+// samples
+type instantaneousMetric struct {
+	lastTime  time.Time
+	lastCount int64
+	samples   [metricSamples]float64
+	idx       int
+}
+
+func (m *instantaneousMetric) track(now time.Time, count int64) {
+	if !m.lastTime.IsZero() {
+		// the sample of a counter reset by CONFIG RESETSTAT is skipped
+		if elapsed := now.Sub(m.lastTime).Seconds(); elapsed > 0 && count >= m.lastCount {
+			m.samples[m.idx] = float64(count-m.lastCount) / elapsed
+			m.idx = (m.idx + 1) % metricSamples
+		}
+	}
+	m.lastTime = now
+	m.lastCount = count
+}
+
+func (m *instantaneousMetric) rate() float64 {
+	var sum float64
+	for _, v := range m.samples {
+		sum += v
+	}
+	return sum / metricSamples
+}
+
+// Sample records the counters behind the instantaneous metrics, it is
+// meant to be called periodically
+func (s *Stats) Sample(now time.Time) {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	s.ops.track(now, s.TotalCommandsProcessed.Load())
+	s.netInput.track(now, s.NetInputBytes.Load())
+	s.netOutput.track(now, s.NetOutputBytes.Load())
+}
+
+// InstantaneousOps returns the commands processed per second
+func (s *Stats) InstantaneousOps() float64 {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	return s.ops.rate()
+}
+
+// InstantaneousNetRates returns the bytes read and written per second
+func (s *Stats) InstantaneousNetRates() (input, output float64) {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	return s.netInput.rate(), s.netOutput.rate()
+}
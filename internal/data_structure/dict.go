@@ -1,6 +1,9 @@
 package data_structure
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type Obj struct {
 	Value any
@@ -9,6 +12,12 @@ type Obj struct {
 type Dict struct {
 	dictStore        map[string]*Obj
 	expiredDictStore map[string]int64
+	// keys and expires mirror the sizes of the stores, expired counts the
+	// keys deleted for having expired. They are atomic so that INFO can
+	// read them from another goroutine than the one owning the dict.
+	keys    atomic.Int64
+	expires atomic.Int64
+	expired atomic.Int64
 }
 
 func CreateDict() *Dict {
@@ -39,6 +48,9 @@ func (d *Dict) GetExpiry(key string) (int64, bool) {
 }
 
 func (d *Dict) SetExpiry(key string, ttlMs int64) {
+	if _, exist := d.expiredDictStore[key]; !exist {
+		d.expires.Add(1)
+	}
 	d.expiredDictStore[key] = time.Now().UnixMilli() + ttlMs
 }
 
@@ -55,6 +67,7 @@ func (d *Dict) Get(k string) *Obj {
 	if v != nil {
 		if d.HasExpired(k) {
 			d.Del(k)
+			d.expired.Add(1)
 			return nil
 		}
 	}
@@ -62,14 +75,36 @@ func (d *Dict) Get(k string) *Obj {
 }
 
 func (d *Dict) Set(k string, obj *Obj) {
+	if _, exist := d.dictStore[k]; !exist {
+		d.keys.Add(1)
+	}
 	d.dictStore[k] = obj
 }
 
 func (d *Dict) Del(k string) bool {
 	if _, exist := d.dictStore[k]; exist {
 		delete(d.dictStore, k)
-		delete(d.expiredDictStore, k)
+		d.keys.Add(-1)
+		if _, exist := d.expiredDictStore[k]; exist {
+			delete(d.expiredDictStore, k)
+			d.expires.Add(-1)
+		}
 		return true
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// Len returns the number of keys and the number of keys with an expiry
+func (d *Dict) Len() (keys int64, expires int64) {
+	return d.keys.Load(), d.expires.Load()
+}
+
+// ExpiredKeys returns the number of keys deleted for having expired
+func (d *Dict) ExpiredKeys() int64 {
+	return d.expired.Load()
+}
+
+// ResetExpiredKeys zeroes the count of expired keys
+func (d *Dict) ResetExpiredKeys() {
+	d.expired.Store(0)
+}
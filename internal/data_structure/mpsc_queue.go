@@ -0,0 +1,66 @@
+package data_structure
+
+import "sync/atomic"
+
+type mpscNode[T any] struct {
+	next  atomic.Pointer[mpscNode[T]]
+	value T
+}
+
+// MPSCQueue is an unbounded lock-free queue with many producers and a
+// single consumer (Vyukov's intrusive MPSC queue). Push may be called from
+// any goroutine, Pop only from the goroutine owning the queue.
+type MPSCQueue[T any] struct {
+	head atomic.Pointer[mpscNode[T]]
+	tail *mpscNode[T]
+	stub mpscNode[T]
+}
+
+func NewMPSCQueue[T any]() *MPSCQueue[T] {
+	q := &MPSCQueue[T]{}
+	q.head.Store(&q.stub)
+	q.tail = &q.stub
+	return q
+}
+
+func (q *MPSCQueue[T]) push(node *mpscNode[T]) {
+	node.next.Store(nil)
+	prev := q.head.Swap(node)
+	prev.next.Store(node)
+}
+
+// Push appends value to the queue
+func (q *MPSCQueue[T]) Push(value T) {
+	q.push(&mpscNode[T]{value: value})
+}
+
+// Pop removes the oldest value of the queue. It returns false when the
+// queue is empty, or when a producer is half way through a Push, in which
+// case the value shows up on a later Pop.
+func (q *MPSCQueue[T]) Pop() (T, bool) {
+	var zero T
+	tail := q.tail
+	next := tail.next.Load()
+	if tail == &q.stub {
+		if next == nil {
+			return zero, false
+		}
+		q.tail = next
+		tail = next
+		next = next.next.Load()
+	}
+	if next != nil {
+		q.tail = next
+		return tail.value, true
+	}
+	if tail != q.head.Load() {
+		return zero, false
+	}
+	q.push(&q.stub)
+	next = tail.next.Load()
+	if next != nil {
+		q.tail = next
+		return tail.value, true
+	}
+	return zero, false
+}
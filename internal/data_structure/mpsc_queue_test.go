@@ -0,0 +1,52 @@
+package data_structure
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMPSCQueueOrder(t *testing.T) {
+	q := NewMPSCQueue[int]()
+	_, ok := q.Pop()
+	assert.False(t, ok)
+	for i := 0; i < 10; i++ {
+		q.Push(i)
+	}
+	for i := 0; i < 10; i++ {
+		v, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+	_, ok = q.Pop()
+	assert.False(t, ok)
+}
+
+func TestMPSCQueueConcurrentProducers(t *testing.T) {
+	const producers, perProducer = 8, 10000
+	q := NewMPSCQueue[[2]int]()
+	wg := &sync.WaitGroup{}
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				q.Push([2]int{p, i})
+			}
+		}(p)
+	}
+
+	// values of a producer must come out in the order it pushed them
+	next := make([]int, producers)
+	for received := 0; received < producers*perProducer; {
+		v, ok := q.Pop()
+		if !ok {
+			continue
+		}
+		assert.Equal(t, next[v[0]], v[1])
+		next[v[0]]++
+		received++
+	}
+	wg.Wait()
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package server
+
+import "syscall"
+
+// accept takes a pending connection from the listening fd. Non-blocking
+// sockets are created with accept4 so no extra fcntl calls are needed.
+func accept(serverFd int, nonBlocking bool) (int, syscall.Sockaddr, error) {
+	if !nonBlocking {
+		return syscall.Accept(serverFd)
+	}
+	return syscall.Accept4(serverFd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+}
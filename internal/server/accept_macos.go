@@ -0,0 +1,20 @@
+//go:build darwin
+
+package server
+
+import "syscall"
+
+// accept takes a pending connection from the listening fd. There is no
+// accept4 on darwin, so the flags are set on the accepted fd afterwards.
+func accept(serverFd int, nonBlocking bool) (int, syscall.Sockaddr, error) {
+	connFd, sa, err := syscall.Accept(serverFd)
+	if err != nil || !nonBlocking {
+		return connFd, sa, err
+	}
+	syscall.CloseOnExec(connFd)
+	if err = syscall.SetNonblock(connFd, true); err != nil {
+		_ = syscall.Close(connFd)
+		return -1, nil, err
+	}
+	return connFd, sa, nil
+}
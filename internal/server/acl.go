@@ -0,0 +1,664 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// The users are shared by the loops. A user is never modified once stored,
+// ACL SETUSER stores a modified copy, so the permissions of a client are
+// checked against the current rules of its user without holding the lock
+// and the changes apply to the clients already authenticated.
+
+// aclLogGroupingTime is how long the denials repeating an ACL log entry are
+// counted in it rather than logged again
+const aclLogGroupingTime = 60 * time.Second
+
+var (
+	errACLSyntax          = errors.New("Syntax error")
+	errACLUnknownCommand  = errors.New("Unknown command or category name in ACL")
+	errACLPasswordMissing = errors.New("The password you are trying to remove from the user does not exist")
+	errACLPasswordHash    = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errACLKeysAfterAll    = errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. " +
+		"Try 'resetkeys' to start with an empty list of patterns")
+	errACLChannelsAfterAll = errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. " +
+		"Try 'resetchannels' to start with an empty list of channels")
+)
+
+// aclKeyPattern is a glob-style pattern of the keys a user may read, write
+// or both
+type aclKeyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+func (p aclKeyPattern) String() string {
+	switch {
+	case p.read && p.write:
+		return "~" + p.pattern
+	case p.read:
+		return "%R~" + p.pattern
+	default:
+		return "%W~" + p.pattern
+	}
+}
+
+// aclUser is a user and its permissions
+type aclUser struct {
+	name    string
+	enabled bool
+	// nopass lets the user authenticate with any password, passwords are
+	// the hex encoded SHA-256 digests of its passwords otherwise
+	nopass    bool
+	passwords []string
+	// allowed tells which commands the user may run, subcommands overrides
+	// it for single subcommands. rules are the command rules given since
+	// they were last reset, they describe both.
+	allowed     map[string]bool
+	subcommands map[string]map[string]bool
+	rules       []string
+	keys        []aclKeyPattern
+	channels    []string
+}
+
+// newACLUser creates a user that is off and may run nothing
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:        name,
+		allowed:     make(map[string]bool),
+		subcommands: make(map[string]map[string]bool),
+		rules:       []string{"-@all"},
+	}
+}
+
+// newDefaultUser creates the user the clients are authenticated as when
+// they connect, it may do anything without a password
+func newDefaultUser() *aclUser {
+	u := newACLUser(core.DefaultUser)
+	for _, rule := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		_ = u.setRule(rule)
+	}
+	return u
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.allowed = make(map[string]bool, len(u.allowed))
+	for name, allow := range u.allowed {
+		c.allowed[name] = allow
+	}
+	c.subcommands = make(map[string]map[string]bool, len(u.subcommands))
+	for name, subs := range u.subcommands {
+		c.subcommands[name] = make(map[string]bool, len(subs))
+		for sub, allow := range subs {
+			c.subcommands[name][sub] = allow
+		}
+	}
+	c.rules = slices.Clone(u.rules)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+// setRules applies rules in order, they are either all applied or none
+func (u *aclUser) setRules(rules []string) (*aclUser, error) {
+	c := u.clone()
+	for _, rule := range rules {
+		if err := c.setRule(rule); err != nil {
+			return nil, fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	return c, nil
+}
+
+// setRule applies a single ACL rule to the user
+func (u *aclUser) setRule(rule string) error {
+	if rule == "" {
+		return errACLSyntax
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+		return nil
+	case "allkeys":
+		return u.setRule("~*")
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		return u.setRule("&*")
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return u.setRule("+@all")
+	case "nocommands":
+		return u.setRule("-@all")
+	case "reset":
+		for _, rule := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			_ = u.setRule(rule)
+		}
+		return nil
+	}
+
+	switch rule[0] {
+	case '>':
+		u.addPassword(hashPassword(rule[1:]))
+	case '#':
+		if !validPasswordHash(rule[1:]) {
+			return errACLPasswordHash
+		}
+		u.addPassword(rule[1:])
+	case '<', '!':
+		hash := rule[1:]
+		if rule[0] == '<' {
+			hash = hashPassword(hash)
+		} else if !validPasswordHash(hash) {
+			return errACLPasswordHash
+		}
+		i := slices.Index(u.passwords, hash)
+		if i < 0 {
+			return errACLPasswordMissing
+		}
+		u.passwords = slices.Delete(u.passwords, i, i+1)
+	case '~':
+		return u.addKeyPattern(aclKeyPattern{pattern: rule[1:], read: true, write: true})
+	case '%':
+		flags, pattern, ok := strings.Cut(rule[1:], "~")
+		if !ok || flags == "" {
+			return errACLSyntax
+		}
+		p := aclKeyPattern{pattern: pattern}
+		for _, flag := range strings.ToUpper(flags) {
+			switch flag {
+			case 'R':
+				p.read = true
+			case 'W':
+				p.write = true
+			default:
+				return errACLSyntax
+			}
+		}
+		return u.addKeyPattern(p)
+	case '&':
+		if slices.Contains(u.channels, "*") {
+			return errACLChannelsAfterAll
+		}
+		if rule[1:] == "*" {
+			u.channels = []string{"*"}
+		} else if !slices.Contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+	case '+', '-':
+		return u.setCommandRule(rule)
+	default:
+		return errACLSyntax
+	}
+	return nil
+}
+
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+	if !slices.Contains(u.passwords, hash) {
+		u.passwords = append(u.passwords, hash)
+	}
+}
+
+func (u *aclUser) addKeyPattern(p aclKeyPattern) error {
+	all := aclKeyPattern{pattern: "*", read: true, write: true}
+	if slices.Contains(u.keys, all) {
+		return errACLKeysAfterAll
+	}
+	if p == all {
+		u.keys = []aclKeyPattern{all}
+		return nil
+	}
+	for i, k := range u.keys {
+		if k.pattern == p.pattern {
+			u.keys[i].read = k.read || p.read
+			u.keys[i].write = k.write || p.write
+			return nil
+		}
+	}
+	u.keys = append(u.keys, p)
+	return nil
+}
+
+// setCommandRule applies +command, -command, +command|subcommand or
+// +@category and their - counterparts
+func (u *aclUser) setCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := strings.ToLower(rule[1:])
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		if category != "all" && !slices.Contains(core.Categories, category) {
+			return errACLUnknownCommand
+		}
+		for _, spec := range core.Commands() {
+			if category == "all" || slices.Contains(spec.Categories, category) {
+				u.allowed[spec.Name] = allow
+				delete(u.subcommands, spec.Name)
+			}
+		}
+		if category == "all" {
+			u.rules = nil
+		}
+	} else {
+		command, sub, hasSub := strings.Cut(name, "|")
+		spec, ok := core.LookupCommand(strings.ToUpper(command))
+		if !ok || hasSub && (!spec.Subcommands || sub == "") {
+			return errACLUnknownCommand
+		}
+		if hasSub {
+			if u.subcommands[spec.Name] == nil {
+				u.subcommands[spec.Name] = make(map[string]bool)
+			}
+			u.subcommands[spec.Name][strings.ToUpper(sub)] = allow
+		} else {
+			u.allowed[spec.Name] = allow
+			delete(u.subcommands, spec.Name)
+		}
+	}
+	u.rules = append(u.rules, rule[:1]+name)
+	return nil
+}
+
+// hashPassword returns the digest a password is stored as
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func validPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if !(hash[i] >= '0' && hash[i] <= '9' || hash[i] >= 'a' && hash[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPassword reports whether password is one of the user. The digests
+// are compared in constant time so that the time taken tells nothing about
+// the passwords.
+func (u *aclUser) checkPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+	given := []byte(hashPassword(password))
+	ok := false
+	for _, hash := range u.passwords {
+		if subtle.ConstantTimeCompare(given, []byte(hash)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// keyAllowed reports whether the user may read or write key
+func (u *aclUser) keyAllowed(key string, write bool) bool {
+	for _, p := range u.keys {
+		if (write && p.write || !write && p.read) && core.MatchGlob(p.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns why the user may not run cmd, the reason ACL LOG records,
+// "command" or "key", and the command or key refused. The reason is empty
+// when the user may run it. The write commands need the write permission on
+// their keys, the others the read one.
+func (u *aclUser) check(cmd *core.Command, spec *core.CommandSpec) (reason, object string) {
+	object = strings.ToLower(spec.Name)
+	allowed := u.allowed[spec.Name]
+	if spec.Subcommands && len(cmd.Args) > 0 {
+		sub := strings.ToUpper(cmd.Args[0])
+		object += "|" + strings.ToLower(sub)
+		if allow, ok := u.subcommands[spec.Name][sub]; ok {
+			allowed = allow
+		}
+	}
+	if !allowed && !spec.NoAuth {
+		return "command", object
+	}
+	for _, key := range cmd.Keys() {
+		if !u.keyAllowed(key, spec.Write) {
+			return "key", key
+		}
+	}
+	return "", ""
+}
+
+// describe returns the rules that recreate the user, the way ACL LIST and
+// the ACL file show it
+func (u *aclUser) describe() string {
+	var rules []string
+	if u.enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if u.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, hash := range u.passwords {
+		rules = append(rules, "#"+hash)
+	}
+	for _, p := range u.keys {
+		rules = append(rules, p.String())
+	}
+	if !slices.Contains(u.channels, "*") {
+		rules = append(rules, "resetchannels")
+	}
+	for _, channel := range u.channels {
+		rules = append(rules, "&"+channel)
+	}
+	rules = append(rules, u.rules...)
+	return strings.Join(rules, " ")
+}
+
+// flags returns the flags ACL GETUSER shows
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// aclLogEntry is a denied command or authentication. The ones repeating
+// within aclLogGroupingTime are counted in the same entry.
+type aclLogEntry struct {
+	id       int64
+	count    int64
+	reason   string
+	object   string
+	username string
+	// clientInfo describes the client of the last denial
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+// acl holds the users and the ACL log. In sharded mode the loops share it.
+type acl struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+	// log holds the latest denials, newest first
+	log       []*aclLogEntry
+	nextLogID int64
+}
+
+// newACL creates the users with the default one only, requirePass being its
+// password
+func newACL(requirePass string) *acl {
+	a := &acl{users: map[string]*aclUser{core.DefaultUser: newDefaultUser()}}
+	a.setDefaultPassword(requirePass)
+	return a
+}
+
+// user returns the user called name, nil when there is none
+func (a *acl) user(name string) *aclUser {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[name]
+}
+
+// defaultUserOpen reports whether the clients are authenticated as the
+// default user without giving a password
+func (a *acl) defaultUserOpen() bool {
+	u := a.user(core.DefaultUser)
+	return u.enabled && u.nopass
+}
+
+// setDefaultPassword makes password the only one of the default user, an
+// empty one lets the user in without a password. It implements
+// requirepass.
+func (a *acl) setDefaultPassword(password string) {
+	rules := []string{"resetpass", "nopass"}
+	if password != "" {
+		rules[1] = ">" + password
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, _ := a.users[core.DefaultUser].setRules(rules)
+	a.users[core.DefaultUser] = u
+}
+
+// authenticate returns the user called username when it is enabled and
+// password is one of its
+func (a *acl) authenticate(username, password string) (*aclUser, bool) {
+	u := a.user(username)
+	if u == nil || !u.enabled || !u.checkPassword(password) {
+		return nil, false
+	}
+	return u, true
+}
+
+// setUser applies rules to the user called name, created when there is
+// none
+func (a *acl) setUser(name string, rules []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.users[name]
+	if !ok {
+		u = newACLUser(name)
+	}
+	u, err := u.setRules(rules)
+	if err != nil {
+		return err
+	}
+	a.users[name] = u
+	return nil
+}
+
+// deleteUsers removes the users among names and returns the ones that
+// existed. The default user cannot be removed.
+func (a *acl) deleteUsers(names []string) ([]string, error) {
+	if slices.Contains(names, core.DefaultUser) {
+		return nil, errors.New("ERR The 'default' user cannot be removed")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var deleted []string
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			deleted = append(deleted, name)
+		}
+	}
+	return deleted, nil
+}
+
+// sortedUsers returns the users sorted by name
+func (a *acl) sortedUsers() []*aclUser {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	users := make([]*aclUser, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+	return users
+}
+
+// addLog records a denial, counted in the newest entry of the same kind
+// when it was created within aclLogGroupingTime. The oldest entries are
+// dropped to keep maxLen of them.
+func (a *acl) addLog(reason, object, username, clientInfo string, maxLen int) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, entry := range a.log {
+		if entry.reason == reason && entry.object == object && entry.username == username &&
+			now.Sub(entry.created) < aclLogGroupingTime {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = clientInfo
+			return
+		}
+	}
+	entry := &aclLogEntry{
+		id:         a.nextLogID,
+		count:      1,
+		reason:     reason,
+		object:     object,
+		username:   username,
+		clientInfo: clientInfo,
+		created:    now,
+		updated:    now,
+	}
+	a.nextLogID++
+	a.log = append([]*aclLogEntry{entry}, a.log...)
+	if len(a.log) > maxLen {
+		clear(a.log[maxLen:])
+		a.log = a.log[:maxLen]
+	}
+}
+
+// logDenial records a command or authentication refused to client in the
+// ACL log and the stats
+func (s *Server) logDenial(client *core.Client, reason, object, username string) {
+	switch reason {
+	case "auth":
+		s.stats.ACLAccessDeniedAuth.Add(1)
+	case "command":
+		s.stats.ACLAccessDeniedCmd.Add(1)
+	case "key":
+		s.stats.ACLAccessDeniedKey.Add(1)
+	}
+	s.acl.addLog(reason, object, username, clientInfo(client, time.Now()), s.cfg().ACLLogMaxLen)
+}
+
+// checkPermissions returns the error replied instead of running cmd when
+// the user of the client may not run it, the unknown commands are left to
+// fail as such
+func (s *Server) checkPermissions(client *core.Client, cmd *core.Command) error {
+	spec, ok := core.LookupCommand(cmd.Cmd)
+	if !ok {
+		return nil
+	}
+	reason, object := "command", strings.ToLower(spec.Name)
+	// the clients of a deleted user are being closed
+	if u := s.acl.user(client.User); u != nil {
+		reason, object = u.check(cmd, spec)
+	}
+	switch reason {
+	case "":
+		return nil
+	case "key":
+		s.logDenial(client, reason, object, client.User)
+		return errors.New("NOPERM No permissions to access a key")
+	default:
+		s.logDenial(client, reason, object, client.User)
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", client.User, object)
+	}
+}
+
+// loadFile replaces the users with the ones of the aclfile. Nothing
+// changes when a line is not valid. The default user is kept as it is when
+// the file does not declare it.
+func (a *acl) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	users := make(map[string]*aclUser)
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		fail := func(err error) error {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fail(errors.New("should start with user keyword followed by the username"))
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fail(fmt.Errorf("duplicate user '%s' found", name))
+		}
+		u, err := newACLUser(name).setRules(fields[2:])
+		if err != nil {
+			return fail(errors.New(strings.TrimPrefix(err.Error(), "ERR ")))
+		}
+		users[name] = u
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := users[core.DefaultUser]; !ok {
+		users[core.DefaultUser] = a.users[core.DefaultUser]
+	}
+	a.users = users
+	return nil
+}
+
+// saveFile writes the users to the aclfile, through a temporary file
+// renamed over it so that it is never left half written
+func (a *acl) saveFile(path string) error {
+	var b strings.Builder
+	for _, u := range a.sortedUsers() {
+		fmt.Fprintf(&b, "user %s %s\n", u.name, u.describe())
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.acl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadACL loads the users of the aclfile when one is set
+func (s *Server) loadACL() error {
+	path := s.cfg().ACLFile
+	if path == "" {
+		return nil
+	}
+	if err := s.acl.loadFile(path); err != nil {
+		return fmt.Errorf("failed to load the ACL file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// errNoACLFile is replied to ACL SAVE and LOAD when no aclfile is set
+var errNoACLFile = errors.New("ERR This Redis instance is not configured to use an ACL file. " +
+	"You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE " +
+	"(assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+
+// aclCommand implements the ACL subcommands
+func (s *Server) aclCommand(cmd *core.Command, client *core.Client) {
+	if len(cmd.Args) == 0 {
+		client.AddReply(core.Encode(errors.New("ERR wrong number of arguments for 'acl' command"), false))
+		return
+	}
+	sub := strings.ToUpper(cmd.Args[0])
+	args := cmd.Args[1:]
+	wrongArgs := func() {
+		client.AddReply(core.Encode(fmt.Errorf("ERR wrong number of arguments for 'acl|%s' command", strings.ToLower(sub)), false))
+	}
+
+	switch sub {
+	case "SETUSER":
+		if len(args) == 0 {
+			wrongArgs()
+			return
+		}
+		if strings.ContainsAny(args[0], " \x00") {
+			client.AddReply(core.Encode(errors.New("ERR Usernames can't contain spaces or null characters"), false))
+			return
+		}
+		if err := s.acl.setUser(args[0], args[1:]); err != nil {
+			client.AddReply(core.Encode(err, false))
+			return
+		}
+		client.AddReply(core.Encode("OK", true))
+	case "GETUSER":
+		if len(args) != 1 {
+			wrongArgs()
+			return
+		}
+		u := s.acl.user(args[0])
+		if u == nil {
+			client.AddReply(core.RespNil)
+			return
+		}
+		keys := make([]string, len(u.keys))
+		for i, p := range u.keys {
+			keys[i] = p.String()
+		}
+		channels := make([]string, len(u.channels))
+		for i, channel := range u.channels {
+			channels[i] = "&" + channel
+		}
+		passwords := u.passwords
+		if passwords == nil {
+			passwords = []string{}
+		}
+		client.AddReply(core.Encode([]any{
+			"flags", u.flags(),
+			"passwords", passwords,
+			"commands", strings.Join(u.rules, " "),
+			"keys", strings.Join(keys, " "),
+			"channels", strings.Join(channels, " "),
+			"selectors", []any{},
+		}, false))
+	case "DELUSER":
+		if len(args) == 0 {
+			wrongArgs()
+			return
+		}
+		deleted, err := s.acl.deleteUsers(args)
+		if err != nil {
+			client.AddReply(core.Encode(err, false))
+			return
+		}
+		s.killUserClients(cmd, client, deleted, core.Encode(len(deleted), false))
+	case "LIST":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		lines := []string{}
+		for _, u := range s.acl.sortedUsers() {
+			lines = append(lines, "user "+u.name+" "+u.describe())
+		}
+		client.AddReply(core.Encode(lines, false))
+	case "USERS":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		names := []string{}
+		for _, u := range s.acl.sortedUsers() {
+			names = append(names, u.name)
+		}
+		client.AddReply(core.Encode(names, false))
+	case "WHOAMI":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		client.AddReply(core.Encode(client.User, false))
+	case "CAT":
+		switch len(args) {
+		case 0:
+			client.AddReply(core.Encode(core.Categories, false))
+		case 1:
+			category := strings.ToLower(args[0])
+			if !slices.Contains(core.Categories, category) {
+				client.AddReply(core.Encode(fmt.Errorf("ERR Unknown category '%s'", args[0]), false))
+				return
+			}
+			names := []string{}
+			for _, spec := range core.Commands() {
+				if slices.Contains(spec.Categories, category) {
+					names = append(names, strings.ToLower(spec.Name))
+				}
+			}
+			client.AddReply(core.Encode(names, false))
+		default:
+			wrongArgs()
+		}
+	case "DRYRUN":
+		if len(args) < 2 {
+			wrongArgs()
+			return
+		}
+		client.AddReply(s.aclDryRun(args[0], &core.Command{Cmd: strings.ToUpper(args[1]), Args: args[2:]}))
+	case "LOG":
+		s.aclLog(args, client, wrongArgs)
+	case "GENPASS":
+		bits := 256
+		if len(args) > 1 {
+			wrongArgs()
+			return
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 || n > 4096 {
+				client.AddReply(core.Encode(errors.New("ERR ACL GENPASS argument must be the number of bits for the output password, "+
+					"a positive number up to 4096"), false))
+				return
+			}
+			bits = n
+		}
+		b := make([]byte, (bits+7)/8)
+		_, _ = rand.Read(b)
+		client.AddReply(core.Encode(hex.EncodeToString(b)[:(bits+3)/4], false))
+	case "SAVE":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		path := s.cfg().ACLFile
+		if path == "" {
+			client.AddReply(core.Encode(errNoACLFile, false))
+			return
+		}
+		if err := s.acl.saveFile(path); err != nil {
+			logf(logWarning, "failed to save the ACL file %s: %v", path, err)
+			client.AddReply(core.Encode(errors.New("ERR There was an error trying to save the ACLs. "+
+				"Please check the server logs for more information"), false))
+			return
+		}
+		client.AddReply(core.Encode("OK", true))
+	case "LOAD":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		path := s.cfg().ACLFile
+		if path == "" {
+			client.AddReply(core.Encode(errNoACLFile, false))
+			return
+		}
+		before := s.acl.sortedUsers()
+		if err := s.acl.loadFile(path); err != nil {
+			client.AddReply(core.Encode(fmt.Errorf("ERR %v. WARNING: ACL errors detected, no change to the previously active ACL rules was performed", err), false))
+			return
+		}
+		var removed []string
+		for _, u := range before {
+			if s.acl.user(u.name) == nil {
+				removed = append(removed, u.name)
+			}
+		}
+		s.killUserClients(cmd, client, removed, core.Encode("OK", true))
+	default:
+		client.AddReply(core.Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try ACL HELP.", cmd.Args[0]), false))
+	}
+}
+
+// killUserClients closes the clients of the users that were removed, on
+// every loop, then replies reply
+func (s *Server) killUserClients(cmd *core.Command, client *core.Client, users []string, reply []byte) {
+	s.onEveryLoop(cmd, client, func(s *Server) []byte {
+		for _, user := range users {
+			s.killClients(&clientFilter{user: user}, client)
+		}
+		return nil
+	}, func([][]byte) []byte {
+		return reply
+	})
+}
+
+// aclDryRun replies whether the user called username may run cmd
+func (s *Server) aclDryRun(username string, cmd *core.Command) []byte {
+	u := s.acl.user(username)
+	if u == nil {
+		return core.Encode(fmt.Errorf("ERR User '%s' not found", username), false)
+	}
+	spec, ok := core.LookupCommand(cmd.Cmd)
+	if !ok {
+		return core.Encode(fmt.Errorf("ERR Command '%s' not found", strings.ToLower(cmd.Cmd)), false)
+	}
+	argc := len(cmd.Args) + 1
+	if spec.Arity > 0 && argc != spec.Arity || spec.Arity < 0 && argc < -spec.Arity {
+		return core.Encode(fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Cmd)), false)
+	}
+	switch reason, object := u.check(cmd, spec); reason {
+	case "":
+		return core.Encode("OK", true)
+	case "key":
+		return core.Encode(fmt.Sprintf("User %s has no permissions to access the '%s' key", username, object), false)
+	default:
+		return core.Encode(fmt.Sprintf("User %s has no permissions to run the '%s' command", username, object), false)
+	}
+}
+
+// aclLog implements ACL LOG [count | RESET]
+func (s *Server) aclLog(args []string, client *core.Client, wrongArgs func()) {
+	count := 10
+	switch {
+	case len(args) > 1:
+		wrongArgs()
+		return
+	case len(args) == 1 && strings.EqualFold(args[0], "RESET"):
+		s.acl.mu.Lock()
+		s.acl.log = nil
+		s.acl.mu.Unlock()
+		client.AddReply(core.Encode("OK", true))
+		return
+	case len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			client.AddReply(core.Encode(errors.New("ERR value is out of range, must be positive"), false))
+			return
+		}
+		count = n
+	}
+
+	now := time.Now()
+	s.acl.mu.RLock()
+	defer s.acl.mu.RUnlock()
+	count = min(count, len(s.acl.log))
+	reply := make([]any, count)
+	for i, entry := range s.acl.log[:count] {
+		reply[i] = []any{
+			"count", entry.count,
+			"reason", entry.reason,
+			"context", "toplevel",
+			"object", entry.object,
+			"username", entry.username,
+			"age-seconds", strconv.FormatFloat(now.Sub(entry.created).Seconds(), 'f', 3, 64),
+			"client-info", entry.clientInfo,
+			"entry-id", entry.id,
+			"timestamp-created", entry.created.UnixMilli(),
+			"timestamp-last-updated", entry.updated.UnixMilli(),
+		}
+	}
+	client.AddReply(core.Encode(reply, false))
+}
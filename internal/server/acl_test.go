@@ -0,0 +1,134 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL(t *testing.T) {
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, setup)
+			admin, adminReader := server.dial(t)
+			assert.Equal(t, "+OK", roundTrip(t, admin, adminReader, "ACL", "SETUSER", "analytics", "on", ">report", "~*", "+@read", "-@dangerous", "+acl|whoami"))
+			assert.Equal(t, "+OK", roundTrip(t, admin, adminReader, "ACL", "SETUSER", "billing", "on", ">pay", "~billing:*", "+@all", "-@admin", "+acl|whoami"))
+			assert.Equal(t, "+OK", roundTrip(t, admin, adminReader, "SET", "billing:1", "10"))
+			assert.Equal(t, "+OK", roundTrip(t, admin, adminReader, "SET", "orders:1", "20"))
+
+			// a read-only user
+			conn, reader := server.dial(t)
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "AUTH", "analytics", "report"))
+			assert.Equal(t, "analytics", roundTrip(t, conn, reader, "ACL", "WHOAMI"))
+			assert.Equal(t, "20", roundTrip(t, conn, reader, "GET", "orders:1"))
+			assert.Equal(t, "-NOPERM User analytics has no permissions to run the 'set' command", roundTrip(t, conn, reader, "SET", "orders:1", "0"))
+			assert.Equal(t, "-NOPERM User analytics has no permissions to run the 'config|get' command", roundTrip(t, conn, reader, "CONFIG", "GET", "port"))
+
+			// a user restricted to its key prefix
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "AUTH", "billing", "pay"))
+			assert.Equal(t, "10", roundTrip(t, conn, reader, "GET", "billing:1"))
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "SET", "billing:2", "5"))
+			assert.Equal(t, "-NOPERM No permissions to access a key", roundTrip(t, conn, reader, "GET", "orders:1"))
+			assert.Equal(t, "-NOPERM No permissions to access a key", roundTrip(t, conn, reader, "DEL", "billing:1", "orders:1"))
+			assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.", roundTrip(t, conn, reader, "AUTH", "billing", "nope"))
+			assert.Equal(t, "billing", roundTrip(t, conn, reader, "ACL", "WHOAMI"))
+
+			// the changes apply to the clients already authenticated
+			assert.Equal(t, "+OK", roundTrip(t, admin, adminReader, "ACL", "SETUSER", "billing", "-get"))
+			assert.Equal(t, "-NOPERM User billing has no permissions to run the 'get' command", roundTrip(t, conn, reader, "GET", "billing:1"))
+
+			// the clients of a deleted user are disconnected
+			assert.Equal(t, ":1", roundTrip(t, admin, adminReader, "ACL", "DELUSER", "billing", "nobody"))
+			_, err := reader.ReadString('\n')
+			assert.NotNil(t, err)
+			assert.Equal(t, "+PONG", roundTrip(t, admin, adminReader, "PING"))
+		})
+	}
+}
+
+func TestACLCommand(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ACLFile = filepath.Join(t.TempDir(), "users.acl")
+	s := NewServer(cfg)
+	client := s.newClient(-1)
+	run := func(args ...string) string {
+		client.Commands = append(client.Commands, &core.Command{Cmd: args[0], Args: args[1:]})
+		s.executeCommands(client)
+		return string(takeReplies(client))
+	}
+
+	assert.Equal(t, "+OK\r\n", run("ACL", "SETUSER", "app", "on", ">secret", "%R~cache:*", "%W~queue:*", "&jobs", "+@keyspace", "+get", "+client|setname"))
+	value, err := core.Decode([]byte(run("ACL", "GETUSER", "app")))
+	assert.Nil(t, err)
+	assert.Equal(t, []any{
+		"flags", []any{"on"},
+		"passwords", []any{hashPassword("secret")},
+		"commands", "-@all +@keyspace +get +client|setname",
+		"keys", "%R~cache:* %W~queue:*",
+		"channels", "&jobs",
+		"selectors", []any{},
+	}, value)
+	assert.Equal(t, "$-1\r\n", run("ACL", "GETUSER", "nobody"))
+	assert.Equal(t, "-ERR Error in ACL SETUSER modifier '+nope': Unknown command or category name in ACL\r\n",
+		run("ACL", "SETUSER", "app", "off", "+nope"))
+	assert.Equal(t, "-ERR Error in ACL SETUSER modifier '#abc': The password hash must be exactly 64 characters and "+
+		"contain only lowercase hexadecimal characters\r\n", run("ACL", "SETUSER", "app", "#abc"))
+	// a failed SETUSER changes nothing
+	assert.Contains(t, run("ACL", "LIST"), "user app on #"+hashPassword("secret")+" %R~cache:* %W~queue:* resetchannels &jobs -@all +@keyspace +get +client|setname\r\n")
+	assert.Equal(t, "*2\r\n$3\r\napp\r\n$7\r\ndefault\r\n", run("ACL", "USERS"))
+
+	assert.Equal(t, "+OK\r\n", run("ACL", "DRYRUN", "app", "GET", "cache:1"))
+	assert.Equal(t, "+OK\r\n", run("ACL", "DRYRUN", "app", "DEL", "queue:1"))
+	assert.Equal(t, string(core.Encode("User app has no permissions to access the 'queue:1' key", false)), run("ACL", "DRYRUN", "app", "get", "queue:1"))
+	assert.Equal(t, string(core.Encode("User app has no permissions to run the 'set' command", false)), run("ACL", "DRYRUN", "app", "SET", "cache:1", "v"))
+	assert.Equal(t, "+OK\r\n", run("ACL", "DRYRUN", "app", "CLIENT", "SETNAME", "worker"))
+	assert.Equal(t, string(core.Encode("User app has no permissions to run the 'client|kill' command", false)), run("ACL", "DRYRUN", "app", "CLIENT", "KILL", "ID", "1"))
+	assert.Equal(t, "-ERR User 'nobody' not found\r\n", run("ACL", "DRYRUN", "nobody", "GET", "k"))
+
+	// denials are logged, the repeated ones in the same entry
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", run("AUTH", "app", "wrong"))
+	assert.Equal(t, "+OK\r\n", run("AUTH", "app", "secret"))
+	assert.Equal(t, "-NOPERM No permissions to access a key\r\n", run("GET", "queue:1"))
+	assert.Equal(t, "-NOPERM No permissions to access a key\r\n", run("GET", "queue:1"))
+	assert.Equal(t, "+OK\r\n", run("AUTH", "default", "any"))
+	value, err = core.Decode([]byte(run("ACL", "LOG")))
+	assert.Nil(t, err)
+	entries := value.([]any)
+	if assert.Len(t, entries, 2) {
+		newest := entries[0].([]any)
+		assert.Equal(t, []any{"count", int64(2), "reason", "key", "context", "toplevel", "object", "queue:1", "username", "app"}, newest[:10])
+		assert.Equal(t, []any{"reason", "auth", "context", "toplevel", "object", "AUTH", "username", "app"}, entries[1].([]any)[2:10])
+	}
+	assert.Equal(t, "+OK\r\n", run("ACL", "LOG", "RESET"))
+	assert.Equal(t, "*0\r\n", run("ACL", "LOG"))
+	assert.Contains(t, s.info(map[string]bool{"stats": true}), "acl_access_denied_auth:1\r\nacl_access_denied_cmd:0\r\nacl_access_denied_key:2\r\n")
+
+	assert.Equal(t, "$64\r\n", run("ACL", "GENPASS")[:5])
+	assert.Equal(t, "$2\r\n", run("ACL", "GENPASS", "5")[:4])
+	assert.Contains(t, run("ACL", "CAT", "keyspace"), "$6\r\nexpire\r\n")
+	assert.Equal(t, "-ERR Unknown category 'nope'\r\n", run("ACL", "CAT", "nope"))
+
+	// the users are saved and loaded back, a file with errors changes nothing
+	assert.Equal(t, "+OK\r\n", run("ACL", "SAVE"))
+	assert.Equal(t, ":1\r\n", run("ACL", "DELUSER", "app"))
+	assert.Equal(t, "-ERR The 'default' user cannot be removed\r\n", run("ACL", "DELUSER", "default"))
+	assert.Equal(t, "+OK\r\n", run("ACL", "LOAD"))
+	assert.Equal(t, "+OK\r\n", run("ACL", "DRYRUN", "app", "GET", "cache:1"))
+	assert.Nil(t, os.WriteFile(cfg.ACLFile, []byte("user ops on nopass +@all ~*\nuser broken +nope\n"), 0600))
+	assert.Equal(t, "-ERR "+cfg.ACLFile+":2: Error in ACL SETUSER modifier '+nope': Unknown command or category name in ACL. "+
+		"WARNING: ACL errors detected, no change to the previously active ACL rules was performed\r\n", run("ACL", "LOAD"))
+	assert.True(t, strings.HasPrefix(run("ACL", "USERS"), "*2\r\n$3\r\napp\r\n"))
+
+	// requirepass is the password of the default user
+	assert.Equal(t, "+OK\r\n", run("CONFIG", "SET", "requirepass", "secret"))
+	assert.False(t, s.newClient(-1).Authenticated)
+	_, ok := s.acl.authenticate(core.DefaultUser, "secret")
+	assert.True(t, ok)
+	assert.Equal(t, "+OK\r\n", run("CONFIG", "SET", "requirepass", ""))
+	assert.True(t, s.newClient(-1).Authenticated)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// peerAddr returns the address of the peer of a connected socket, empty
+// when it can't be read
+func peerAddr(fd int) string {
+	sa, err := syscall.Getpeername(fd)
+	if err != nil {
+		return ""
+	}
+	return sockaddrString(sa)
+}
+
+// setClientAddrs fills the addresses of a client accepted on fd. The
+// clients of the Unix socket are flagged, both their addresses are the
+// path of the socket.
+func setClientAddrs(client *core.Client, fd int) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		client.Addr = peerAddr(fd)
+		return
+	}
+	client.LAddr = sockaddrString(sa)
+	if _, ok := sa.(*syscall.SockaddrUnix); ok {
+		client.Flags |= core.ClientFlagUnixSocket
+		client.Addr = client.LAddr
+		return
+	}
+	client.Addr = peerAddr(fd)
+}
+
+// setConnAddrs is setClientAddrs for a connection of the thread pool mode
+func setConnAddrs(client *core.Client, conn net.Conn) {
+	if _, ok := conn.(*net.UnixConn); ok {
+		client.Flags |= core.ClientFlagUnixSocket
+		client.LAddr = conn.LocalAddr().String() + ":0"
+		client.Addr = client.LAddr
+		return
+	}
+	client.Addr = conn.RemoteAddr().String()
+	client.LAddr = conn.LocalAddr().String()
+}
+
+// sockaddrString formats a socket address as host:port, IPv6 hosts in
+// brackets
+func sockaddrString(sa syscall.Sockaddr) string {
+	switch a := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.JoinHostPort(net.IP(a.Addr[:]).String(), strconv.Itoa(a.Port))
+	case *syscall.SockaddrInet6:
+		return net.JoinHostPort(net.IP(a.Addr[:]).String(), strconv.Itoa(a.Port))
+	case *syscall.SockaddrUnix:
+		return a.Name + ":0"
+	}
+	return ""
+}
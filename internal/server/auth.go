@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+var (
+	// errNoAuth is replied to the commands of the clients that have to
+	// authenticate first
+	errNoAuth = errors.New("NOAUTH Authentication required.")
+	// errWrongPass is replied to AUTH and HELLO when the credentials are
+	// not valid
+	errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+// newClient creates the client of an accepted connection, authenticated
+// when the default user needs no password
+func (s *Server) newClient(fd int) *core.Client {
+	client := core.NewClient(fd)
+	client.Authenticated = s.acl.defaultUserOpen()
+	return client
+}
+
+// authRequired reports whether cmd is refused because the client has not
+// authenticated. The unknown commands are left to fail as such.
+func (s *Server) authRequired(client *core.Client, cmd *core.Command) bool {
+	if client.Authenticated || s.acl.defaultUserOpen() {
+		return false
+	}
+	spec, ok := core.LookupCommand(cmd.Cmd)
+	return ok && !spec.NoAuth
+}
+
+// checkAccess returns the error replied instead of running cmd when the
+// client has to authenticate first or its user may not run it
+func (s *Server) checkAccess(client *core.Client, cmd *core.Command) error {
+	if s.authRequired(client, cmd) {
+		return errNoAuth
+	}
+	return s.checkPermissions(client, cmd)
+}
+
+// authenticate authenticates the client as username when password is one
+// of the user, the failures are logged in the ACL log
+func (s *Server) authenticate(client *core.Client, username, password string) bool {
+	if _, ok := s.acl.authenticate(username, password); !ok {
+		s.logDenial(client, "auth", "AUTH", username)
+		return false
+	}
+	client.Authenticated = true
+	client.User = username
+	return true
+}
+
+// authCommand implements AUTH [username] password
+func (s *Server) authCommand(cmd *core.Command, client *core.Client) {
+	username, password := core.DefaultUser, ""
+	switch len(cmd.Args) {
+	case 1:
+		if s.acl.user(core.DefaultUser).nopass {
+			client.AddReply(core.Encode(errors.New("ERR AUTH <password> called without any password configured for the default user. "+
+				"Are you sure your configuration is correct?"), false))
+			return
+		}
+		password = cmd.Args[0]
+	case 2:
+		username, password = cmd.Args[0], cmd.Args[1]
+	default:
+		client.AddReply(core.Encode(errors.New("ERR wrong number of arguments for 'auth' command"), false))
+		return
+	}
+	if !s.authenticate(client, username, password) {
+		client.AddReply(core.Encode(errWrongPass, false))
+		return
+	}
+	client.AddReply(core.Encode("OK", true))
+}
+
+// helloCommand implements HELLO [protover [AUTH username password]
+// [SETNAME name]], only RESP2 is spoken
+func (s *Server) helloCommand(cmd *core.Command, client *core.Client) {
+	args := cmd.Args
+	if len(args) > 0 {
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			client.AddReply(core.Encode(errors.New("ERR Protocol version is not an integer or out of range"), false))
+			return
+		}
+		if version != 2 {
+			client.AddReply(core.Encode(errors.New("NOPROTO unsupported protocol version"), false))
+			return
+		}
+	}
+	var username, password, name string
+	auth, setName := false, false
+	for i := 1; i < len(args); i++ {
+		more := len(args) - i - 1
+		switch {
+		case strings.EqualFold(args[i], "AUTH") && more >= 2:
+			auth = true
+			username, password = args[i+1], args[i+2]
+			i += 2
+		case strings.EqualFold(args[i], "SETNAME") && more >= 1:
+			setName = true
+			name = args[i+1]
+			i++
+		default:
+			client.AddReply(core.Encode(fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i]), false))
+			return
+		}
+	}
+	if auth && !s.authenticate(client, username, password) {
+		client.AddReply(core.Encode(errWrongPass, false))
+		return
+	}
+	if !client.Authenticated && !s.acl.defaultUserOpen() {
+		client.AddReply(core.Encode(errors.New("NOAUTH HELLO must be called with the client already authenticated, "+
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and "+
+			"select the RESP protocol version at the same time"), false))
+		return
+	}
+	if setName {
+		if !validClientName(name) {
+			client.AddReply(core.Encode(errClientName, false))
+			return
+		}
+		client.Name = name
+	}
+	client.AddReply(core.Encode([]any{
+		"server", "redis",
+		"version", redisVersion,
+		"proto", 2,
+		"id", client.ID,
+		"mode", "standalone",
+		"role", "master",
+		"modules", []any{},
+	}, false))
+}
+
+// quitCommand implements QUIT, the connection is closed once the reply is
+// sent
+func (s *Server) quitCommand(client *core.Client) {
+	client.AddReply(core.Encode("OK", true))
+	client.Flags |= core.ClientFlagCloseAfterReply
+}
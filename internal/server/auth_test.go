@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuth(t *testing.T) {
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, func(cfg *config.Config) {
+				cfg.RequirePass = "s3cret"
+				setup(cfg)
+			})
+			conn, reader := server.dial(t)
+
+			assert.Equal(t, "-NOAUTH Authentication required.", roundTrip(t, conn, reader, "SET", "k", "v"))
+			assert.Equal(t, "-NOAUTH Authentication required.", roundTrip(t, conn, reader, "CLIENT", "LIST"))
+			assert.Equal(t, "-CMD NOT FOUND", roundTrip(t, conn, reader, "NOPE"))
+			assert.Contains(t, roundTrip(t, conn, reader, "HELLO", "2"), "-NOAUTH HELLO must be called with the client already authenticated")
+			assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.", roundTrip(t, conn, reader, "AUTH", "wrong"))
+			assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.", roundTrip(t, conn, reader, "AUTH", "bob", "s3cret"))
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "AUTH", "s3cret"))
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "SET", "k", "v"))
+
+			// HELLO authenticates a client as well
+			other, otherReader := server.dial(t)
+			assert.Equal(t, "*14", roundTrip(t, other, otherReader, "HELLO", "2", "AUTH", "default", "s3cret", "SETNAME", "app"))
+			// the reply ends with the empty list of modules
+			var err error
+			for line := ""; line != "*0\r\n" && err == nil; {
+				line, err = otherReader.ReadString('\n')
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, "app", roundTrip(t, other, otherReader, "CLIENT", "GETNAME"))
+			assert.Equal(t, "v", roundTrip(t, other, otherReader, "GET", "k"))
+
+			assert.Equal(t, "+OK", roundTrip(t, other, otherReader, "QUIT"))
+			_, err = otherReader.ReadString('\n')
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestHello(t *testing.T) {
+	s := NewServer(config.NewConfig())
+	client := s.newClient(-1)
+	run := func(args ...string) string {
+		client.Commands = append(client.Commands, &core.Command{Cmd: args[0], Args: args[1:]})
+		s.executeCommands(client)
+		return string(takeReplies(client))
+	}
+
+	value, err := core.Decode([]byte(run("HELLO")))
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"server", "redis", "version", redisVersion, "proto", int64(2), "id", client.ID,
+		"mode", "standalone", "role", "master", "modules", []any{}}, value)
+	assert.Equal(t, "-NOPROTO unsupported protocol version\r\n", run("HELLO", "3"))
+	assert.Equal(t, "-ERR Protocol version is not an integer or out of range\r\n", run("HELLO", "two"))
+	assert.Equal(t, "-ERR Syntax error in HELLO option 'AUTH'\r\n", run("HELLO", "2", "AUTH", "default"))
+	assert.Equal(t, "-ERR AUTH <password> called without any password configured for the default user. "+
+		"Are you sure your configuration is correct?\r\n", run("AUTH", "secret"))
+	assert.Equal(t, "+OK\r\n", run("AUTH", "default", "anything"))
+
+	// the clients connected before the password was set stay authenticated
+	assert.Equal(t, "+OK\r\n", run("CONFIG", "SET", "requirepass", "secret"))
+	assert.Equal(t, "+PONG\r\n", run("PING"))
+	assert.False(t, s.newClient(-1).Authenticated)
+	assert.False(t, s.protectedModeDenies("10.0.0.1:5000", false))
+	assert.Equal(t, fmt.Sprintf(":%d\r\n", client.ID), run("CLIENT", "ID"))
+}
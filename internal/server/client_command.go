@@ -0,0 +1,591 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// CLIENT LIST and CLIENT KILL act on the clients of every loop, each loop
+// lists and kills its own clients. A killed client is closed by its loop
+// once the hang up of its socket is reported, the client running CLIENT
+// KILL on itself is closed after the reply.
+
+// pauseMode is what CLIENT PAUSE holds
+type pauseMode int
+
+const (
+	pauseOff pauseMode = iota
+	pauseWrite
+	pauseAll
+)
+
+// clientPause is the state of CLIENT PAUSE, shared by the loops of a server
+type clientPause struct {
+	// paused is set while mode is not pauseOff, the loops check it before
+	// taking mu for every command
+	paused atomic.Bool
+
+	mu    sync.Mutex
+	mode  pauseMode
+	end   time.Time
+	timer *time.Timer
+	// resumed is closed when the pause ends
+	resumed chan struct{}
+}
+
+func newClientPause() *clientPause {
+	p := &clientPause{resumed: make(chan struct{})}
+	close(p.resumed)
+	return p
+}
+
+// blocks reports whether cmd has to wait for the pause to end
+func (p *clientPause) blocks(cmd *core.Command) bool {
+	if !p.paused.Load() {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mode == pauseOff || !time.Now().Before(p.end) {
+		return false
+	}
+	if p.mode == pauseAll {
+		return true
+	}
+	spec, ok := core.LookupCommand(cmd.Cmd)
+	return ok && spec.Write
+}
+
+// active reports whether a pause is in progress
+func (p *clientPause) active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mode != pauseOff && time.Now().Before(p.end)
+}
+
+// done returns a channel closed once the pause in progress ends
+func (p *clientPause) done() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed
+}
+
+// stop ends the pause, p.mu must be held
+func (p *clientPause) stop() {
+	if p.mode == pauseOff {
+		return
+	}
+	p.mode = pauseOff
+	p.paused.Store(false)
+	p.timer.Stop()
+	close(p.resumed)
+}
+
+// pauseClients holds the commands of mode for d. A pause in progress is
+// only extended: the most restrictive mode and the latest end are kept.
+func (s *Server) pauseClients(mode pauseMode, d time.Duration) {
+	p := s.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	end := time.Now().Add(d)
+	if p.mode != pauseOff && !time.Now().Before(p.end) {
+		p.stop()
+	}
+	if p.mode == pauseOff {
+		p.resumed = make(chan struct{})
+		p.mode = mode
+		p.end = end
+		p.paused.Store(true)
+	} else {
+		p.timer.Stop()
+		if mode > p.mode {
+			p.mode = mode
+		}
+		if end.After(p.end) {
+			p.end = end
+		}
+	}
+	p.timer = time.AfterFunc(time.Until(p.end), s.endPause)
+}
+
+// endPause ends the pause once its time elapsed, the loops are woken up
+// to resume their paused clients
+func (s *Server) endPause() {
+	p := s.pause
+	p.mu.Lock()
+	if p.mode != pauseOff && !time.Now().Before(p.end) {
+		p.stop()
+	}
+	p.mu.Unlock()
+	s.wakeLoops()
+}
+
+// unpauseClients ends the pause right away
+func (s *Server) unpauseClients() {
+	p := s.pause
+	p.mu.Lock()
+	p.stop()
+	p.mu.Unlock()
+	s.wakeLoops()
+}
+
+// resumePausedClients runs the commands of the clients of the loop that
+// were held by a pause that ended, it returns the clients to write to
+func (s *Server) resumePausedClients() []*core.Client {
+	if len(s.pausedClients) == 0 || s.pause.active() {
+		return nil
+	}
+	paused := s.pausedClients
+	s.pausedClients = nil
+	resumed := paused[:0]
+	for _, client := range paused {
+		if s.clients[client.Fd] != client {
+			continue
+		}
+		client.Flags &^= core.ClientFlagPaused
+		s.executeCommands(client)
+		resumed = append(resumed, client)
+	}
+	return resumed
+}
+
+// loopClients returns the clients served by the loop of s ordered by id
+func (s *Server) loopClients() []*core.Client {
+	clients := make([]*core.Client, 0, len(s.clients)+len(s.poolConns))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	for client := range s.poolConns {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// clientFilter selects clients for CLIENT LIST and CLIENT KILL, the zero
+// value matches every client
+type clientFilter struct {
+	ids    []int64
+	class  string
+	addr   string
+	laddr  string
+	user   string
+	maxAge int64
+	// skipMe leaves out the client running the command
+	skipMe bool
+}
+
+func (f *clientFilter) matches(client, self *core.Client, now time.Time) bool {
+	if len(f.ids) > 0 {
+		found := false
+		for _, id := range f.ids {
+			found = found || id == client.ID
+		}
+		if !found {
+			return false
+		}
+	}
+	switch {
+	case f.class != "" && f.class != client.Class(),
+		f.addr != "" && f.addr != client.Addr,
+		f.laddr != "" && f.laddr != client.LAddr,
+		f.user != "" && f.user != client.User,
+		f.maxAge > 0 && int64(now.Sub(client.CreatedAt).Seconds()) < f.maxAge,
+		f.skipMe && client == self:
+		return false
+	}
+	return true
+}
+
+// parseClientType returns the class of a client type name, replicas being
+// also called slaves. The server has no master client.
+func parseClientType(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case config.ClientClassNormal:
+		return config.ClientClassNormal, nil
+	case config.ClientClassReplica, "slave":
+		return config.ClientClassReplica, nil
+	case config.ClientClassPubSub:
+		return config.ClientClassPubSub, nil
+	case "master":
+		return "master", nil
+	}
+	return "", fmt.Errorf("ERR Unknown client type '%s'", name)
+}
+
+// clientInfo formats a client the way CLIENT LIST shows it
+func clientInfo(client *core.Client, now time.Time) string {
+	flags := ""
+	for _, f := range []struct {
+		flag   uint64
+		letter byte
+	}{
+		{core.ClientFlagMonitor, 'O'},
+		{core.ClientFlagReplica, 'S'},
+		{core.ClientFlagPubSub, 'P'},
+		{core.ClientFlagBlocked | core.ClientFlagPaused, 'b'},
+		{core.ClientFlagCloseAfterReply, 'c'},
+		{core.ClientFlagCloseASAP, 'A'},
+		{core.ClientFlagNoEvict, 'e'},
+		{core.ClientFlagNoTouch, 'T'},
+		{core.ClientFlagUnixSocket, 'U'},
+	} {
+		if client.Flags&f.flag != 0 {
+			flags += string(f.letter)
+		}
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	events := "r"
+	if client.WriteMonitored {
+		events = "rw"
+	}
+	cmd := strings.ToLower(client.LastCmd)
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	obl, oll, omem := client.Out.Usage()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s fd=%d name=%s age=%d idle=%d flags=%s db=%d sub=0 psub=0 ssub=0 multi=-1 "+
+		"qbuf=%d qbuf-free=%d obl=%d oll=%d omem=%d events=%s cmd=%s user=%s redir=-1 resp=2",
+		client.ID, client.Addr, client.LAddr, client.Fd, client.Name,
+		int64(now.Sub(client.CreatedAt).Seconds()), int64(now.Sub(client.LastInteraction).Seconds()),
+		flags, client.DB, len(client.QueryBuf), cap(client.QueryBuf)-len(client.QueryBuf),
+		obl, oll, omem, events, cmd, client.User)
+}
+
+// clientList returns the CLIENT LIST lines of the clients of the loop
+// matching f
+func (s *Server) clientList(f *clientFilter, self *core.Client) string {
+	var b strings.Builder
+	now := time.Now()
+	for _, client := range s.loopClients() {
+		if f.matches(client, self, now) {
+			b.WriteString(clientInfo(client, now))
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// killClients disconnects the clients of the loop matching f and returns
+// their number
+func (s *Server) killClients(f *clientFilter, self *core.Client) int {
+	killed := 0
+	now := time.Now()
+	for _, client := range s.loopClients() {
+		if !f.matches(client, self, now) || client.Flags&core.ClientFlagCloseASAP != 0 {
+			continue
+		}
+		killed++
+		if client == self {
+			client.Flags |= core.ClientFlagCloseAfterReply
+			continue
+		}
+		s.closeClientASAP(client)
+	}
+	return killed
+}
+
+// closeClientASAP closes a client of the loop other than the one running
+// the current command. Its socket is shut down so that the goroutine
+// serving it notices right away.
+func (s *Server) closeClientASAP(client *core.Client) {
+	client.Flags |= core.ClientFlagCloseASAP
+	if conn, ok := s.poolConns[client]; ok {
+		_ = conn.Close()
+		return
+	}
+	_ = syscall.Shutdown(client.Fd, syscall.SHUT_RDWR)
+}
+
+// onEveryLoop runs call on every loop of the server and replies the merge
+// of the results. In sharded mode the client is blocked until all the
+// loops ran it.
+func (s *Server) onEveryLoop(cmd *core.Command, client *core.Client, call func(s *Server) []byte, merge func(results [][]byte) []byte) {
+	if s.shard == nil {
+		client.AddReply(merge([][]byte{call(s)}))
+		return
+	}
+	s.shard.broadcast(cmd, client, call, merge)
+}
+
+// sumResults adds up the decimal counts returned by the loops
+func sumResults(results [][]byte) int {
+	sum := 0
+	for _, result := range results {
+		n, _ := strconv.Atoi(string(result))
+		sum += n
+	}
+	return sum
+}
+
+// errClientName is replied to a name CLIENT SETNAME or HELLO refuses
+var errClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+
+// validClientName reports whether name only holds printable characters
+// other than space
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientCommand implements the CLIENT subcommands
+func (s *Server) clientCommand(cmd *core.Command, client *core.Client) {
+	if len(cmd.Args) == 0 {
+		client.AddReply(core.Encode(errors.New("ERR wrong number of arguments for 'client' command"), false))
+		return
+	}
+	sub := strings.ToUpper(cmd.Args[0])
+	args := cmd.Args[1:]
+	wrongArgs := func() {
+		client.AddReply(core.Encode(fmt.Errorf("ERR wrong number of arguments for 'client|%s' command", strings.ToLower(sub)), false))
+	}
+	onOff := func(flag uint64) {
+		if len(args) != 1 {
+			wrongArgs()
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "ON":
+			client.Flags |= flag
+		case "OFF":
+			client.Flags &^= flag
+		default:
+			client.AddReply(core.Encode(errSyntax, false))
+			return
+		}
+		client.AddReply(core.Encode("OK", true))
+	}
+
+	switch sub {
+	case "ID":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		client.AddReply(core.Encode(client.ID, false))
+	case "INFO":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		client.AddReply(core.Encode(clientInfo(client, time.Now())+"\n", false))
+	case "LIST":
+		f, err := parseClientListFilter(args)
+		if err != nil {
+			client.AddReply(core.Encode(err, false))
+			return
+		}
+		s.onEveryLoop(cmd, client, func(s *Server) []byte {
+			return []byte(s.clientList(f, client))
+		}, func(results [][]byte) []byte {
+			var b strings.Builder
+			for _, result := range results {
+				b.Write(result)
+			}
+			return core.Encode(b.String(), false)
+		})
+	case "KILL":
+		if len(args) == 0 {
+			wrongArgs()
+			return
+		}
+		if len(args) == 1 {
+			// the old form kills the client with that address, itself
+			// included
+			f := &clientFilter{addr: args[0]}
+			s.onEveryLoop(cmd, client, func(s *Server) []byte {
+				return []byte(strconv.Itoa(s.killClients(f, client)))
+			}, func(results [][]byte) []byte {
+				if sumResults(results) == 0 {
+					return core.Encode(errors.New("ERR No such client"), false)
+				}
+				return core.Encode("OK", true)
+			})
+			return
+		}
+		f, err := s.parseClientKillFilter(args)
+		if err != nil {
+			client.AddReply(core.Encode(err, false))
+			return
+		}
+		s.onEveryLoop(cmd, client, func(s *Server) []byte {
+			return []byte(strconv.Itoa(s.killClients(f, client)))
+		}, func(results [][]byte) []byte {
+			return core.Encode(sumResults(results), false)
+		})
+	case "SETNAME":
+		if len(args) != 1 {
+			wrongArgs()
+			return
+		}
+		if !validClientName(args[0]) {
+			client.AddReply(core.Encode(errClientName, false))
+			return
+		}
+		client.Name = args[0]
+		client.AddReply(core.Encode("OK", true))
+	case "GETNAME":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		if client.Name == "" {
+			client.AddReply(core.RespNil)
+			return
+		}
+		client.AddReply(core.Encode(client.Name, false))
+	case "PAUSE":
+		if len(args) != 1 && len(args) != 2 {
+			wrongArgs()
+			return
+		}
+		timeout, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			client.AddReply(core.Encode(errors.New("ERR timeout is not an integer or out of range"), false))
+			return
+		}
+		if timeout < 0 {
+			client.AddReply(core.Encode(errors.New("ERR timeout is negative"), false))
+			return
+		}
+		mode := pauseAll
+		if len(args) == 2 {
+			switch strings.ToUpper(args[1]) {
+			case "WRITE":
+				mode = pauseWrite
+			case "ALL":
+			default:
+				client.AddReply(core.Encode(errSyntax, false))
+				return
+			}
+		}
+		s.pauseClients(mode, time.Duration(timeout)*time.Millisecond)
+		client.AddReply(core.Encode("OK", true))
+	case "UNPAUSE":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		s.unpauseClients()
+		client.AddReply(core.Encode("OK", true))
+	case "NO-EVICT":
+		onOff(core.ClientFlagNoEvict)
+	case "NO-TOUCH":
+		onOff(core.ClientFlagNoTouch)
+	case "REPLY":
+		if len(args) != 1 {
+			wrongArgs()
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "ON":
+			client.Flags &^= core.ClientFlagReplyOff | core.ClientFlagReplySkipNext
+			client.AddReply(core.Encode("OK", true))
+		case "OFF":
+			client.Flags |= core.ClientFlagReplyOff
+		case "SKIP":
+			if client.Flags&core.ClientFlagReplyOff == 0 {
+				client.Flags |= core.ClientFlagReplySkipNext
+			}
+		default:
+			client.AddReply(core.Encode(errSyntax, false))
+		}
+	default:
+		client.AddReply(core.Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try CLIENT HELP.", cmd.Args[0]), false))
+	}
+}
+
+// parseClientListFilter parses the [TYPE type] [ID id ...] arguments of
+// CLIENT LIST
+func parseClientListFilter(args []string) (*clientFilter, error) {
+	f := &clientFilter{}
+	switch {
+	case len(args) == 0:
+	case len(args) == 2 && strings.EqualFold(args[0], "TYPE"):
+		class, err := parseClientType(args[1])
+		if err != nil {
+			return nil, err
+		}
+		f.class = class
+	case len(args) > 1 && strings.EqualFold(args[0], "ID"):
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, errors.New("ERR Invalid client ID")
+			}
+			f.ids = append(f.ids, id)
+		}
+	default:
+		return nil, errSyntax
+	}
+	return f, nil
+}
+
+// parseClientKillFilter parses the filter/value pairs of CLIENT KILL, the
+// client running it is skipped unless SKIPME no is given
+func (s *Server) parseClientKillFilter(args []string) (*clientFilter, error) {
+	f := &clientFilter{skipMe: true}
+	if len(args)%2 != 0 {
+		return nil, errSyntax
+	}
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, errors.New("ERR client-id should be greater than 0")
+			}
+			f.ids = []int64{id}
+		case "TYPE":
+			class, err := parseClientType(value)
+			if err != nil {
+				return nil, err
+			}
+			f.class = class
+		case "ADDR":
+			f.addr = value
+		case "LADDR":
+			f.laddr = value
+		case "USER":
+			if s.acl.user(value) == nil {
+				return nil, fmt.Errorf("ERR No such user '%s'", value)
+			}
+			f.user = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				f.skipMe = true
+			case "no":
+				f.skipMe = false
+			default:
+				return nil, errSyntax
+			}
+		case "MAXAGE":
+			age, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || age <= 0 {
+				return nil, errSyntax
+			}
+			f.maxAge = age
+		default:
+			return nil, errSyntax
+		}
+	}
+	return f, nil
+}
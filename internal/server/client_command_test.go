@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCommand(t *testing.T) {
+	s := NewServer(config.NewConfig())
+	client := core.NewClient(-1)
+	run := func(args ...string) string {
+		client.Commands = append(client.Commands, &core.Command{Cmd: args[0], Args: args[1:]})
+		s.executeCommands(client)
+		return string(takeReplies(client))
+	}
+
+	assert.Equal(t, fmt.Sprintf(":%d\r\n", client.ID), run("CLIENT", "ID"))
+	assert.Equal(t, "$-1\r\n", run("CLIENT", "GETNAME"))
+	assert.Equal(t, "+OK\r\n", run("CLIENT", "SETNAME", "loader"))
+	assert.Equal(t, "$6\r\nloader\r\n", run("CLIENT", "GETNAME"))
+	assert.Equal(t, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n",
+		run("CLIENT", "SETNAME", "bulk loader"))
+	assert.Equal(t, "+OK\r\n", run("CLIENT", "NO-EVICT", "on"))
+	assert.Equal(t, "+OK\r\n", run("CLIENT", "NO-TOUCH", "ON"))
+	assert.Equal(t, "-ERR syntax error\r\n", run("CLIENT", "NO-TOUCH", "maybe"))
+
+	info := run("CLIENT", "INFO")
+	assert.Contains(t, info, fmt.Sprintf("id=%d addr= laddr= fd=-1 name=loader age=0 idle=0 flags=eT db=0 ", client.ID))
+	assert.Contains(t, info, " cmd=client user=default ")
+
+	// SKIP drops the reply of the next command only, OFF every reply
+	assert.Equal(t, "", run("CLIENT", "REPLY", "SKIP"))
+	assert.Equal(t, "", run("SET", "k", "v"))
+	assert.Equal(t, "$1\r\nv\r\n", run("GET", "k"))
+	assert.Equal(t, "", run("CLIENT", "REPLY", "OFF"))
+	assert.Equal(t, "", run("GET", "k"))
+	assert.Equal(t, "+OK\r\n", run("CLIENT", "REPLY", "ON"))
+
+	assert.Equal(t, "-ERR No such client\r\n", run("CLIENT", "KILL", "127.0.0.1:1"))
+	assert.Equal(t, ":0\r\n", run("CLIENT", "KILL", "ID", strconv.FormatInt(client.ID, 10)))
+	assert.Equal(t, "-ERR No such user 'bob'\r\n", run("CLIENT", "KILL", "USER", "bob"))
+	assert.Equal(t, "-ERR Unknown client type 'robot'\r\n", run("CLIENT", "LIST", "TYPE", "robot"))
+	assert.Equal(t, "-ERR timeout is negative\r\n", run("CLIENT", "PAUSE", "-1"))
+	assert.Equal(t, "-ERR unknown subcommand 'NOPE'. Try CLIENT HELP.\r\n", run("CLIENT", "NOPE"))
+
+	assert.Equal(t, "+OK\r\n", run("CLIENT", "PAUSE", "10000", "WRITE"))
+	assert.Equal(t, "$1\r\nv\r\n", run("GET", "k"))
+	assert.Equal(t, "", run("SET", "k", "w"))
+	assert.NotZero(t, client.Flags&core.ClientFlagPaused)
+	// the held SET runs before the GET once the pause ended
+	s.unpauseClients()
+	client.Flags &^= core.ClientFlagPaused
+	assert.Equal(t, "+OK\r\n$1\r\nw\r\n", run("GET", "k"))
+}
+
+func TestClientListAndKill(t *testing.T) {
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, setup)
+			admin, adminReader := server.dial(t)
+			other, otherReader := server.dial(t)
+
+			assert.Equal(t, "+OK", roundTrip(t, other, otherReader, "CLIENT", "SETNAME", "other"))
+			id := roundTrip(t, other, otherReader, "CLIENT", "ID")
+
+			list := roundTrip(t, admin, adminReader, "CLIENT", "LIST")
+			lines := strings.Split(strings.TrimSuffix(list, "\n"), "\n")
+			assert.Len(t, lines, 2)
+			assert.Contains(t, list, "id="+id[1:]+" addr="+other.LocalAddr().String()+" laddr="+server.addr+" ")
+			assert.Contains(t, list, " name=other ")
+			assert.Contains(t, list, " cmd=client user=default ")
+
+			// writes wait for the pause to end, reads go through
+			assert.Equal(t, "+OK", roundTrip(t, admin, adminReader, "CLIENT", "PAUSE", "10000", "WRITE"))
+			_, err := other.Write(core.Encode([]string{"SET", "k", "v"}, false))
+			assert.Nil(t, err)
+			assert.Equal(t, "$-1", roundTrip(t, admin, adminReader, "GET", "k"))
+			assert.Equal(t, "+OK", roundTrip(t, admin, adminReader, "CLIENT", "UNPAUSE"))
+			line, err := otherReader.ReadString('\n')
+			assert.Nil(t, err)
+			assert.Equal(t, "+OK\r\n", line)
+
+			assert.Equal(t, ":0", roundTrip(t, admin, adminReader, "CLIENT", "KILL", "ID", "999999"))
+			assert.Equal(t, ":1", roundTrip(t, admin, adminReader, "CLIENT", "KILL", "ID", id[1:]))
+			_, err = otherReader.ReadString('\n')
+			assert.NotNil(t, err)
+			assert.Equal(t, ":1", roundTrip(t, admin, adminReader, "CLIENT", "KILL", "SKIPME", "no"))
+			_, err = adminReader.ReadString('\n')
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/lyxuansang91/redis-crash-course/internal/core/io_multiplexing"
+)
+
+// completionConn is the state the completion loop keeps for a client while
+// the kernel works on its socket
+type completionConn struct {
+	client *core.Client
+	// recvBuf is owned by the kernel while receiving is set
+	recvBuf   []byte
+	receiving bool
+	// sending is the number of bytes of the output buffer handed to the
+	// kernel, zero when no send is in flight
+	sending int
+	closing bool
+}
+
+// runCompletionLoop serves clients on a completion based multiplexer: the
+// kernel accepts, receives and sends on behalf of the server, which only
+// reacts to the results. Operations queued while handling a batch of
+// completions are all submitted with the next wait. The waker is received
+// from like a client. On shutdown the listeners are shut down to complete
+// the pending accepts and the clients are closed once their replies are
+// sent or at the deadline; the loop returns when the kernel is done with all
+// of them.
+func (s *Server) runCompletionLoop(serverFds []int, mux io_multiplexing.CompletionIOMultiplexer) error {
+	logf(logNotice, "serving clients through completion based I/O")
+	defer s.closeHandshakes()
+	conns := make(map[int]*completionConn)
+	for _, serverFd := range serverFds {
+		if err := mux.Accept(serverFd); err != nil {
+			return fmt.Errorf("failed to accept on server fd: %v", err)
+		}
+	}
+	// accepting is the number of accepts in flight
+	accepting := len(serverFds)
+	wakeBuf := make([]byte, 64)
+	if err := mux.Recv(s.waker.fd, wakeBuf); err != nil {
+		return fmt.Errorf("failed to receive on the wake up socket: %v", err)
+	}
+	waking := true
+
+	// there are no replicas to wait for, clients are closed as soon as the
+	// shutdown starts
+	closing, finishing := false, false
+	for accepting > 0 || waking || len(conns) > 0 {
+		if !closing && s.life.stopping.Load() && s.enterClosing() {
+			closing = true
+			for _, serverFd := range serverFds {
+				_ = syscall.Shutdown(serverFd, syscall.SHUT_RDWR)
+			}
+			for _, conn := range conns {
+				conn.client.Flags |= core.ClientFlagCloseAfterReply
+				s.send(mux, conns, conn)
+			}
+		}
+		if closing && !finishing && (len(conns) == 0 || s.pastDeadline()) {
+			// the remaining clients are closed and the pending receive on
+			// the waker is completed
+			finishing = true
+			for _, conn := range conns {
+				s.closeConn(conns, conn)
+			}
+			_ = syscall.Shutdown(s.waker.fd, syscall.SHUT_RD)
+		}
+
+		completions, err := mux.WaitCompletions()
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return fmt.Errorf("failed to wait for completions: %v", err)
+		}
+		start := time.Now()
+
+		for _, completion := range completions {
+			switch {
+			case completion.Fd == s.waker.fd:
+				waking = false
+				s.waker.drain()
+				s.runCron()
+				for _, client := range s.resumePausedClients() {
+					if conn, ok := conns[client.Fd]; ok {
+						s.send(mux, conns, conn)
+					}
+				}
+				s.sendMonitors(mux, conns)
+				s.startTLSConns(mux, conns)
+				if !finishing {
+					if err = mux.Recv(s.waker.fd, wakeBuf); err != nil {
+						return fmt.Errorf("failed to receive on the wake up socket: %v", err)
+					}
+					waking = true
+				}
+			case completion.Op == io_multiplexing.CompletionAccept:
+				accepting--
+				if closing {
+					if completion.Res >= 0 {
+						_ = syscall.Close(completion.Res)
+					}
+					continue
+				}
+				s.acceptCompleted(mux, conns, completion.Res, slices.Contains(s.tlsFds, completion.Fd))
+				if err = mux.Accept(completion.Fd); err != nil {
+					return fmt.Errorf("failed to accept on server fd: %v", err)
+				}
+				accepting++
+			case completion.Op == io_multiplexing.CompletionRecv:
+				if conn, ok := conns[completion.Fd]; ok {
+					s.recvCompleted(mux, conns, conn, completion.Res)
+				}
+			case completion.Op == io_multiplexing.CompletionSend:
+				if conn, ok := conns[completion.Fd]; ok {
+					s.sendCompleted(mux, conns, conn, completion.Res)
+				}
+			}
+		}
+		s.stats.EventLoopLatency.Record(time.Since(start))
+	}
+	return nil
+}
+
+// acceptCompleted starts serving an accepted connection, the ones of the
+// TLS port once their handshake succeeded
+func (s *Server) acceptCompleted(mux io_multiplexing.CompletionIOMultiplexer, conns map[int]*completionConn, res int, secure bool) {
+	if res < 0 {
+		logf(logWarning, "err accept: %v", syscall.Errno(-res))
+		return
+	}
+	if s.maxClientsReached() {
+		rejectClient(res, errMaxClients)
+		return
+	}
+	logf(logVerbose, "set up a new connection")
+	client := s.newClient(res)
+	setClientAddrs(client, res)
+	if s.protectedModeDenies(client.Addr, client.Flags&core.ClientFlagUnixSocket != 0) {
+		rejectClient(res, errProtectedMode)
+		return
+	}
+	s.keepAlive(client)
+	if secure {
+		s.handshakeTLS(client)
+		return
+	}
+	s.recv(mux, conns, s.addConn(conns, client))
+}
+
+// addConn adds a client to the ones served by the loop
+func (s *Server) addConn(conns map[int]*completionConn, client *core.Client) *completionConn {
+	conn := &completionConn{
+		client:  client,
+		recvBuf: make([]byte, readBufSize),
+	}
+	s.clients[client.Fd] = client
+	conns[client.Fd] = conn
+	s.stats.ConnectedClients.Add(1)
+	s.stats.TotalConnectionsReceived.Add(1)
+	return conn
+}
+
+// startTLSConns starts serving the clients whose handshake succeeded, the
+// commands read along with the handshake are run right away
+func (s *Server) startTLSConns(mux io_multiplexing.CompletionIOMultiplexer, conns map[int]*completionConn) {
+	for _, client := range s.takeHandshaked() {
+		if s.life.closing.Load() {
+			_ = syscall.Close(client.Fd)
+			continue
+		}
+		conn := s.addConn(conns, client)
+		if err := client.AppendInput(nil); err != nil {
+			logf(logVerbose, "TLS error: %v", err)
+			s.closeConn(conns, conn)
+			continue
+		}
+		s.processQueryBuffer(client)
+		s.send(mux, conns, conn)
+		if !conn.closing && client.Flags&core.ClientFlagCloseAfterReply == 0 {
+			s.recv(mux, conns, conn)
+		}
+	}
+}
+
+func (s *Server) recv(mux io_multiplexing.CompletionIOMultiplexer, conns map[int]*completionConn, conn *completionConn) {
+	if err := mux.Recv(conn.client.Fd, conn.recvBuf); err != nil {
+		logf(logWarning, "err recv: %v", err)
+		s.closeConn(conns, conn)
+		return
+	}
+	conn.receiving = true
+}
+
+func (s *Server) recvCompleted(mux io_multiplexing.CompletionIOMultiplexer, conns map[int]*completionConn, conn *completionConn, res int) {
+	conn.receiving = false
+	if conn.closing {
+		s.closeConn(conns, conn)
+		return
+	}
+	if res == -int(syscall.EAGAIN) || res == -int(syscall.EINTR) {
+		s.recv(mux, conns, conn)
+		return
+	}
+	if res <= 0 {
+		if res < 0 && res != -int(syscall.ECONNRESET) {
+			logf(logWarning, "read error: %v", syscall.Errno(-res))
+		} else {
+			logf(logVerbose, "client disconnected")
+		}
+		s.closeConn(conns, conn)
+		return
+	}
+
+	client := conn.client
+	s.stats.NetInputBytes.Add(int64(res))
+	if err := client.AppendInput(conn.recvBuf[:res]); err != nil {
+		logf(logVerbose, "TLS error: %v", err)
+		s.closeConn(conns, conn)
+		return
+	}
+	client.LastInteraction = time.Now()
+	s.processQueryBuffer(client)
+	s.send(mux, conns, conn)
+	if !conn.closing && client.Flags&core.ClientFlagCloseAfterReply == 0 {
+		s.recv(mux, conns, conn)
+	}
+}
+
+// send hands the next chunk of the client output buffer to the kernel, only
+// one send is in flight per client so replies stay in order
+func (s *Server) send(mux io_multiplexing.CompletionIOMultiplexer, conns map[int]*completionConn, conn *completionConn) {
+	if conn.sending > 0 || conn.closing {
+		return
+	}
+	client := conn.client
+	if client.Flags&core.ClientFlagCloseASAP != 0 {
+		s.closeConn(conns, conn)
+		return
+	}
+	if err := s.checkOutputBufferLimit(client); err != nil {
+		logf(logWarning, "err write: %v", err)
+		s.closeConn(conns, conn)
+		return
+	}
+	chunk, err := client.PeekOutput()
+	if err != nil {
+		logf(logWarning, "err write: %v", err)
+		s.closeConn(conns, conn)
+		return
+	}
+	if len(chunk) == 0 {
+		if client.Flags&core.ClientFlagCloseAfterReply != 0 {
+			s.closeConn(conns, conn)
+		}
+		return
+	}
+	if err := mux.Send(client.Fd, chunk); err != nil {
+		logf(logWarning, "err write: %v", err)
+		s.closeConn(conns, conn)
+		return
+	}
+	conn.sending = len(chunk)
+}
+
+func (s *Server) sendCompleted(mux io_multiplexing.CompletionIOMultiplexer, conns map[int]*completionConn, conn *completionConn, res int) {
+	conn.sending = 0
+	if conn.closing {
+		s.closeConn(conns, conn)
+		return
+	}
+	if res < 0 && res != -int(syscall.EAGAIN) && res != -int(syscall.EINTR) {
+		logf(logWarning, "err write: %v", syscall.Errno(-res))
+		s.closeConn(conns, conn)
+		return
+	}
+	if res > 0 {
+		s.stats.NetOutputBytes.Add(int64(res))
+		conn.client.AdvanceOutput(res)
+	}
+	s.send(mux, conns, conn)
+}
+
+// sendMonitors sends the pending lines of the monitors served by the loop,
+// the ones that overflowed are closed
+func (s *Server) sendMonitors(mux io_multiplexing.CompletionIOMultiplexer, conns map[int]*completionConn) {
+	for _, m := range s.ownMonitors() {
+		conn, ok := conns[m.client.Fd]
+		if !ok {
+			continue
+		}
+		lines, overflowed := m.take()
+		if overflowed {
+			s.stats.ClientOutputBufferLimitDisconnections.Add(1)
+			logf(logWarning, "err write: %v", errOutputBufferLimit)
+			s.closeConn(conns, conn)
+			continue
+		}
+		if len(lines) > 0 {
+			m.client.AddReply(lines)
+			s.send(mux, conns, conn)
+		}
+	}
+}
+
+// closeConn closes the client once the kernel is done with its buffers. The
+// socket is shut down first so that a pending recv completes right away.
+func (s *Server) closeConn(conns map[int]*completionConn, conn *completionConn) {
+	fd := conn.client.Fd
+	if !conn.closing {
+		conn.closing = true
+		s.removeMonitor(conn.client)
+		_ = syscall.Shutdown(fd, syscall.SHUT_RDWR)
+	}
+	if conn.receiving || conn.sending > 0 {
+		return
+	}
+	delete(conns, fd)
+	delete(s.clients, fd)
+	s.stats.ConnectedClients.Add(-1)
+	_ = syscall.Close(fd)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// configSetError is the failure of one of the parameters of CONFIG SET
+type configSetError struct {
+	name string
+	err  error
+}
+
+func (e *configSetError) Error() string {
+	if errors.Is(e.err, config.ErrUnknownParameter) {
+		return fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", e.name)
+	}
+	return fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", e.name, e.err)
+}
+
+// configCommand implements CONFIG GET, SET, RESETSTAT and REWRITE
+func (s *Server) configCommand(cmd *core.Command, client *core.Client) {
+	if len(cmd.Args) == 0 {
+		client.AddReply(core.Encode(errors.New("ERR wrong number of arguments for 'config' command"), false))
+		return
+	}
+	sub := strings.ToUpper(cmd.Args[0])
+	args := cmd.Args[1:]
+	wrongArgs := func() {
+		client.AddReply(core.Encode(fmt.Errorf("ERR wrong number of arguments for 'config|%s' command", strings.ToLower(sub)), false))
+	}
+
+	switch sub {
+	case "GET":
+		if len(args) == 0 {
+			wrongArgs()
+			return
+		}
+		client.AddReply(core.Encode(s.configGet(args), false))
+	case "SET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			wrongArgs()
+			return
+		}
+		if err := s.configSet(client, args); err != nil {
+			client.AddReply(core.Encode(err, false))
+			return
+		}
+		client.AddReply(core.Encode("OK", true))
+	case "RESETSTAT":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		s.resetStats()
+		client.AddReply(core.Encode("OK", true))
+	case "REWRITE":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		if err := s.cfg().Rewrite(); err != nil {
+			logf(logWarning, "CONFIG REWRITE failed: %v", err)
+			client.AddReply(core.Encode(fmt.Errorf("ERR Rewriting config file: %v", err), false))
+			return
+		}
+		logf(logNotice, "CONFIG REWRITE executed with success.")
+		client.AddReply(core.Encode("OK", true))
+	default:
+		client.AddReply(core.Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try CONFIG HELP.", cmd.Args[0]), false))
+	}
+}
+
+// configGet returns the name and value of every parameter matching one of
+// the glob-style patterns
+func (s *Server) configGet(patterns []string) []string {
+	c := s.cfg()
+	var reply []string
+	for _, name := range config.ParameterNames() {
+		for _, pattern := range patterns {
+			if core.MatchGlob(pattern, name, true) {
+				value, _ := c.Get(name)
+				reply = append(reply, name, value)
+				break
+			}
+		}
+	}
+	if reply == nil {
+		reply = []string{}
+	}
+	return reply
+}
+
+// configSet applies name value pairs all at once: when one of them fails
+// none is applied. The side effects of the new values take place right
+// away.
+func (s *Server) configSet(client *core.Client, args []string) error {
+	seen := make(map[string]bool)
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		if seen[name] {
+			return &configSetError{name: args[i], err: errors.New("duplicate parameter")}
+		}
+		seen[name] = true
+		if config.IsProtected(name) && !s.protectedConfigsAllowed(client) {
+			return &configSetError{name: args[i], err: errors.New("can't set protected config")}
+		}
+	}
+
+	var tlsConf *tls.Config
+	old, err := s.configStore.Update(func(c *config.Config) error {
+		tlsName := ""
+		for i := 0; i < len(args); i += 2 {
+			if err := c.SetAtRuntime(args[i], args[i+1]); err != nil {
+				return &configSetError{name: args[i], err: err}
+			}
+			if tlsName == "" && strings.HasPrefix(strings.ToLower(args[i]), "tls-") {
+				tlsName = args[i]
+			}
+		}
+		// the TLS settings are only taken when they load, the certificate
+		// files are read again even when their paths did not change
+		if tlsName != "" && c.TLSPort > 0 {
+			var err error
+			if tlsConf, err = newTLSConfig(c); err != nil {
+				logf(logWarning, "failed to load the TLS configuration: %v", err)
+				return &configSetError{name: tlsName, err: errors.New("Unable to update TLS configuration. Check server logs.")}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if tlsConf != nil {
+		s.tlsConfig.Store(tlsConf)
+	}
+	s.applyConfig(old, s.cfg())
+	return nil
+}
+
+// protectedConfigsAllowed reports whether enable-protected-configs lets the
+// client change the protected parameters
+func (s *Server) protectedConfigsAllowed(client *core.Client) bool {
+	switch s.cfg().EnableProtectedConfigs {
+	case config.EnableYes:
+		return true
+	case config.EnableLocal:
+		return isLocalAddr(client.Addr, client.Flags&core.ClientFlagUnixSocket != 0)
+	}
+	return false
+}
+
+// applyConfig carries out what has to be done when parameters change. The
+// settings that are read from the config as they are used, like the client
+// output buffer limits, need nothing.
+func (s *Server) applyConfig(old, c *config.Config) {
+	if old.LogLevel != c.LogLevel {
+		setLogLevel(c.LogLevel)
+	}
+	if c.RequirePass != old.RequirePass {
+		s.acl.setDefaultPassword(c.RequirePass)
+	}
+	if c.MaxConnections > old.MaxConnections {
+		if err := s.adjustOpenFilesLimit(); err != nil {
+			logf(logWarning, "%v", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// runCommand executes a command for a client without connection and
+// returns its reply
+func runCommand(s *Server, args ...string) string {
+	client := core.NewClient(-1)
+	s.execute(&core.Command{Cmd: args[0], Args: args[1:]}, client)
+	var reply []byte
+	for chunk := client.Out.Peek(); len(chunk) > 0; chunk = client.Out.Peek() {
+		reply = append(reply, chunk...)
+		client.Out.Advance(len(chunk))
+	}
+	return string(reply)
+}
+
+func TestConfigCommand(t *testing.T) {
+	s := NewServer(config.NewConfig())
+	defer setLogLevel(config.LogLevelNotice)
+
+	assert.Equal(t, "*4\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n$16\r\nshutdown-timeout\r\n$2\r\n10\r\n",
+		runCommand(s, "CONFIG", "GET", "MAXMEM*", "*-timeout"))
+	assert.Equal(t, "*0\r\n", runCommand(s, "CONFIG", "GET", "nothing*"))
+
+	before := s.cfg()
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "SET", "maxmemory", "1gb", "loglevel", "warning",
+		"client-output-buffer-limit", "normal 1mb 512kb 10"))
+	assert.Equal(t, int64(1<<30), s.cfg().MaxMemory)
+	assert.Equal(t, config.ClientOutputBufferLimit{HardLimitBytes: 1 << 20, SoftLimitBytes: 512 << 10, SoftLimitSeconds: 10},
+		s.cfg().ClientOutputBufferLimits[config.ClientClassNormal])
+	assert.Equal(t, "*2\r\n$26\r\nclient-output-buffer-limit\r\n$81\r\nnormal 1048576 524288 10 replica 268435456 67108864 60 pubsub 33554432 8388608 60\r\n",
+		runCommand(s, "CONFIG", "GET", "client-output-buffer-limit"))
+	assert.Equal(t, logWarning, logLevel.Load())
+	// the config readers hold on to is never modified
+	assert.Equal(t, int64(0), before.MaxMemory)
+
+	// nothing is applied when a parameter fails
+	assert.Equal(t, "-ERR CONFIG SET failed (possibly related to argument 'shutdown-timeout') - argument couldn't be parsed into an integer\r\n",
+		runCommand(s, "CONFIG", "SET", "maxmemory", "2gb", "shutdown-timeout", "soon"))
+	assert.Equal(t, int64(1<<30), s.cfg().MaxMemory)
+	assert.Equal(t, "-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n",
+		runCommand(s, "CONFIG", "SET", "port", "6000"))
+	assert.Equal(t, "-ERR Unknown option or number of arguments for CONFIG SET - 'nope'\r\n",
+		runCommand(s, "CONFIG", "SET", "nope", "1"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'config|set' command\r\n",
+		runCommand(s, "CONFIG", "SET", "maxmemory"))
+
+	s.stats.ClientOutputBufferLimitDisconnections.Add(3)
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "RESETSTAT"))
+	assert.Equal(t, int64(0), s.stats.ClientOutputBufferLimitDisconnections.Load())
+
+	assert.Equal(t, "-ERR Rewriting config file: The server is running without a config file\r\n",
+		runCommand(s, "CONFIG", "REWRITE"))
+}
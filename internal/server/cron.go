@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// cronInterval is how often the periodic tasks of the loops run
+const cronInterval = time.Second
+
+// serverCron runs the periodic tasks of the server until done is closed.
+// The event loops own their clients, they are woken up to run the tasks
+// themselves; the thread pool mode runs them while holding execMu.
+func (s *Server) serverCron(done <-chan struct{}) {
+	ticker := time.NewTicker(cronInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if s.threadPool != nil {
+				s.execMu.Lock()
+				s.clientsCron(now)
+				s.execMu.Unlock()
+				continue
+			}
+			s.life.cronTicks.Add(1)
+			s.wakeLoops()
+		}
+	}
+}
+
+// runCron runs the periodic tasks of the loop when the cron ticked since
+// they last ran, the loop may be woken up for other reasons
+func (s *Server) runCron() {
+	ticks := s.life.cronTicks.Load()
+	if ticks == s.cronTicks {
+		return
+	}
+	s.cronTicks = ticks
+	s.clientsCron(time.Now())
+}
+
+// clientsCron closes the clients of the loop that have been idle for more
+// than the timeout setting. Replicas, monitors and the clients waiting for
+// a reply or for a pause to end are never idle.
+func (s *Server) clientsCron(now time.Time) {
+	timeout := s.cfg().Timeout
+	if timeout == 0 {
+		return
+	}
+	maxIdle := time.Duration(timeout) * time.Second
+	busy := core.ClientFlagReplica | core.ClientFlagMonitor | core.ClientFlagBlocked | core.ClientFlagPaused | core.ClientFlagCloseASAP
+	for _, client := range s.loopClients() {
+		if client.Flags&busy != 0 || now.Sub(client.LastInteraction) <= maxIdle {
+			continue
+		}
+		logf(logVerbose, "closing idle client")
+		s.closeClientASAP(client)
+	}
+}
+
+// keepAlive applies the tcp-keepalive setting to the connection of an
+// accepted client, unless it came through the Unix socket
+func (s *Server) keepAlive(client *core.Client) {
+	interval := s.cfg().TCPKeepAlive
+	if interval == 0 || client.Flags&core.ClientFlagUnixSocket != 0 {
+		return
+	}
+	if err := setKeepAlive(client.Fd, interval); err != nil {
+		logf(logWarning, "err set keepalive: %v", err)
+	}
+}
+
+// keepAliveConn applies the tcp-keepalive setting to a connection of the
+// thread pool mode, which net.Listener accepts with its own keepalive
+func (s *Server) keepAliveConn(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	interval := s.cfg().TCPKeepAlive
+	err := tcpConn.SetKeepAlive(interval > 0)
+	if err == nil && interval > 0 {
+		err = tcpConn.SetKeepAlivePeriod(time.Duration(interval) * time.Second)
+	}
+	if err != nil {
+		logf(logWarning, "err set keepalive: %v", err)
+	}
+}
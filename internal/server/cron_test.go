@@ -0,0 +1,59 @@
+package server
+
+import (
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientsCron(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Timeout = 10
+	s := NewServer(cfg)
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	now := time.Now()
+	idle, blocked, active := core.NewClient(fds[0]), core.NewClient(-1), core.NewClient(-1)
+	idle.LastInteraction = now.Add(-11 * time.Second)
+	blocked.LastInteraction = now.Add(-11 * time.Second)
+	blocked.Flags |= core.ClientFlagBlocked
+	active.LastInteraction = now.Add(-9 * time.Second)
+	s.clients[1], s.clients[2], s.clients[3] = idle, blocked, active
+
+	s.clientsCron(now)
+	assert.NotZero(t, idle.Flags&core.ClientFlagCloseASAP)
+	assert.Zero(t, blocked.Flags&core.ClientFlagCloseASAP)
+	assert.Zero(t, active.Flags&core.ClientFlagCloseASAP)
+	// the socket of the idle client was shut down
+	n, err := syscall.Read(fds[1], make([]byte, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestIdleClientsAreClosed(t *testing.T) {
+	for name, setup := range testModes {
+		setup := setup
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			server := startTestServer(t, func(cfg *config.Config) {
+				cfg.Timeout = 1
+				setup(cfg)
+			})
+			conn, reader := server.dial(t)
+			// the client is idle from the time the server read the PING on
+			start := time.Now()
+			assert.Equal(t, "+PONG", roundTrip(t, conn, reader, "PING"))
+			_, err := reader.ReadString('\n')
+			assert.Equal(t, io.EOF, err)
+			assert.Greater(t, time.Since(start), time.Second)
+		})
+	}
+}
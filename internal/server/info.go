@@ -0,0 +1,330 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/lyxuansang91/redis-crash-course/internal/core/io_multiplexing"
+	"github.com/lyxuansang91/redis-crash-course/internal/data_structure"
+)
+
+// redisVersion is the version of Redis whose commands and INFO fields the
+// server follows, monitoring integrations read it to know what to expect
+const redisVersion = "7.2.0"
+
+// statsHz is how many times per second the instantaneous metrics are
+// sampled
+const statsHz = 10
+
+// runID identifies the running process, it changes on every start
+var runID = newRunID()
+
+func newRunID() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// infoSection writes the fields of an INFO section
+type infoSection struct {
+	name  string
+	title string
+	// inDefault sections are shown when INFO is called without sections
+	inDefault bool
+	write     func(s *Server, w *infoWriter)
+}
+
+// infoSections are the sections of INFO in the order they are shown
+var infoSections = []infoSection{
+	{"server", "Server", true, (*Server).infoServer},
+	{"clients", "Clients", true, (*Server).infoClients},
+	{"memory", "Memory", true, (*Server).infoMemory},
+	{"persistence", "Persistence", true, (*Server).infoPersistence},
+	{"stats", "Stats", true, (*Server).infoStats},
+	{"replication", "Replication", true, (*Server).infoReplication},
+	{"cpu", "CPU", true, (*Server).infoCPU},
+	{"commandstats", "Commandstats", false, (*Server).infoCommandStats},
+	{"errorstats", "Errorstats", true, (*Server).infoErrorStats},
+	{"cluster", "Cluster", true, (*Server).infoCluster},
+	{"keyspace", "Keyspace", true, (*Server).infoKeyspace},
+}
+
+// infoWriter formats the sections in the # Section and key:value lines of
+// INFO
+type infoWriter struct {
+	b        strings.Builder
+	sections int
+}
+
+func (w *infoWriter) section(title string) {
+	if w.sections > 0 {
+		w.b.WriteString("\r\n")
+	}
+	w.sections++
+	fmt.Fprintf(&w.b, "# %s\r\n", title)
+}
+
+func (w *infoWriter) field(key string, value any) {
+	fmt.Fprintf(&w.b, "%s:%v\r\n", key, value)
+}
+
+// infoCommand implements INFO [section ...]. The sections are case
+// insensitive, default selects the default ones, all and everything select
+// all of them and unknown sections are ignored.
+func (s *Server) infoCommand(cmd *core.Command, client *core.Client) {
+	args := cmd.Args
+	if len(args) == 0 {
+		args = []string{"default"}
+	}
+	selected := make(map[string]bool)
+	for _, arg := range args {
+		switch name := strings.ToLower(arg); name {
+		case "default", "all", "everything":
+			for _, section := range infoSections {
+				if section.inDefault || name != "default" {
+					selected[section.name] = true
+				}
+			}
+		default:
+			selected[name] = true
+		}
+	}
+	client.AddReply(core.Encode(s.info(selected), false))
+}
+
+// info returns the text of the selected sections
+func (s *Server) info(selected map[string]bool) string {
+	w := &infoWriter{}
+	for _, section := range infoSections {
+		if selected[section.name] {
+			w.section(section.title)
+			section.write(s, w)
+		}
+	}
+	return w.b.String()
+}
+
+func (s *Server) infoServer(w *infoWriter) {
+	c := s.cfg()
+	uptime := time.Since(s.stats.StartTime)
+	_, port, _ := net.SplitHostPort(c.Port)
+	executable, _ := os.Executable()
+	ioThreads := 1
+	if s.ioThreads != nil {
+		ioThreads = c.IOThreads
+	}
+	w.field("redis_version", redisVersion)
+	w.field("redis_mode", "standalone")
+	w.field("os", runtime.GOOS+" "+runtime.GOARCH)
+	w.field("arch_bits", strconv.IntSize)
+	w.field("multiplexing_api", s.multiplexingAPI())
+	w.field("go_version", runtime.Version())
+	w.field("process_id", os.Getpid())
+	w.field("run_id", runID)
+	w.field("tcp_port", port)
+	w.field("server_time_usec", time.Now().UnixMicro())
+	w.field("uptime_in_seconds", int64(uptime.Seconds()))
+	w.field("uptime_in_days", int64(uptime.Hours()/24))
+	w.field("hz", statsHz)
+	w.field("executable", executable)
+	w.field("config_file", c.ConfigFile)
+	w.field("server_mode", c.ServerMode)
+	w.field("shards", c.Shards)
+	w.field("io_threads_active", ioThreads)
+}
+
+// multiplexingAPI names the mechanism the server waits for I/O with
+func (s *Server) multiplexingAPI() string {
+	if s.ioMultiplexer == nil {
+		return "goroutines"
+	}
+	if _, ok := s.ioMultiplexer.(io_multiplexing.CompletionIOMultiplexer); ok {
+		return "io_uring"
+	}
+	if runtime.GOOS == "darwin" {
+		return "kqueue"
+	}
+	return "epoll"
+}
+
+func (s *Server) infoClients(w *infoWriter) {
+	w.field("connected_clients", s.stats.ConnectedClients.Load())
+	w.field("blocked_clients", s.stats.BlockedClients.Load())
+	w.field("maxclients", s.cfg().MaxConnections)
+}
+
+// infoMemory reports the heap of the Go runtime as the used memory, it
+// includes the buffers of the server along with the dataset. Nothing is
+// evicted, the writes are refused while it is over maxmemory.
+func (s *Server) infoMemory(w *infoWriter) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	maxMemory := s.cfg().MaxMemory
+	w.field("used_memory", m.HeapAlloc)
+	w.field("used_memory_human", bytesToHuman(int64(m.HeapAlloc)))
+	w.field("used_memory_sys", m.Sys)
+	w.field("used_memory_sys_human", bytesToHuman(int64(m.Sys)))
+	w.field("maxmemory", maxMemory)
+	w.field("maxmemory_human", bytesToHuman(maxMemory))
+	w.field("maxmemory_policy", "noeviction")
+	w.field("mem_allocator", runtime.Version())
+}
+
+// infoPersistence reports an idle persistence, the dataset is never saved
+func (s *Server) infoPersistence(w *infoWriter) {
+	w.field("loading", 0)
+	w.field("async_loading", 0)
+	w.field("rdb_changes_since_last_save", 0)
+	w.field("rdb_bgsave_in_progress", 0)
+	w.field("rdb_last_save_time", s.stats.StartTime.Unix())
+	w.field("rdb_last_bgsave_status", "ok")
+	w.field("aof_enabled", 0)
+	w.field("aof_rewrite_in_progress", 0)
+}
+
+func (s *Server) infoStats(w *infoWriter) {
+	var expired int64
+	for _, dict := range s.dicts() {
+		expired += dict.ExpiredKeys()
+	}
+	input, output := s.stats.InstantaneousNetRates()
+	w.field("total_connections_received", s.stats.TotalConnectionsReceived.Load())
+	w.field("total_commands_processed", s.stats.TotalCommandsProcessed.Load())
+	w.field("instantaneous_ops_per_sec", int64(s.stats.InstantaneousOps()))
+	w.field("total_net_input_bytes", s.stats.NetInputBytes.Load())
+	w.field("total_net_output_bytes", s.stats.NetOutputBytes.Load())
+	w.field("instantaneous_input_kbps", fmt.Sprintf("%.2f", input/1024))
+	w.field("instantaneous_output_kbps", fmt.Sprintf("%.2f", output/1024))
+	w.field("rejected_connections", s.stats.RejectedConnections.Load())
+	w.field("expired_keys", expired)
+	w.field("evicted_keys", 0)
+	w.field("total_error_replies", s.stats.TotalErrorReplies.Load())
+	w.field("client_query_buffer_limit_disconnections", s.stats.ClientQueryBufferLimitDisconnections.Load())
+	w.field("client_output_buffer_limit_disconnections", s.stats.ClientOutputBufferLimitDisconnections.Load())
+	w.field("acl_access_denied_auth", s.stats.ACLAccessDeniedAuth.Load())
+	w.field("acl_access_denied_cmd", s.stats.ACLAccessDeniedCmd.Load())
+	w.field("acl_access_denied_key", s.stats.ACLAccessDeniedKey.Load())
+}
+
+func (s *Server) infoReplication(w *infoWriter) {
+	w.field("role", "master")
+	w.field("connected_slaves", 0)
+	w.field("master_replid", runID)
+	w.field("master_repl_offset", 0)
+}
+
+func (s *Server) infoCPU(w *infoWriter) {
+	var self, children syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &self)
+	_ = syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children)
+	w.field("used_cpu_sys", formatTimeval(self.Stime))
+	w.field("used_cpu_user", formatTimeval(self.Utime))
+	w.field("used_cpu_sys_children", formatTimeval(children.Stime))
+	w.field("used_cpu_user_children", formatTimeval(children.Utime))
+}
+
+func formatTimeval(tv syscall.Timeval) string {
+	return fmt.Sprintf("%d.%06d", int64(tv.Sec), int64(tv.Usec))
+}
+
+func (s *Server) infoCommandStats(w *infoWriter) {
+	for _, name := range s.stats.CommandNames() {
+		cs := s.stats.Command(name)
+		calls, usec := cs.Calls.Load(), cs.Usec.Load()
+		w.field("cmdstat_"+strings.ToLower(name), fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=0,failed_calls=%d",
+			calls, usec, float64(usec)/float64(calls), cs.FailedCalls.Load()))
+	}
+}
+
+func (s *Server) infoErrorStats(w *infoWriter) {
+	counts := s.stats.ErrorCounts()
+	for _, code := range sortedKeys(counts) {
+		w.field("errorstat_"+code, fmt.Sprintf("count=%d", counts[code]))
+	}
+}
+
+func (s *Server) infoCluster(w *infoWriter) {
+	w.field("cluster_enabled", 0)
+}
+
+// infoKeyspace reports the single database, summed over the shards
+func (s *Server) infoKeyspace(w *infoWriter) {
+	var keys, expires int64
+	for _, dict := range s.dicts() {
+		k, e := dict.Len()
+		keys += k
+		expires += e
+	}
+	if keys > 0 {
+		w.field("db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=0", keys, expires))
+	}
+}
+
+// dicts returns the keyspace of every loop of the server
+func (s *Server) dicts() []*data_structure.Dict {
+	if s.shard == nil {
+		return []*data_structure.Dict{s.dict}
+	}
+	dicts := make([]*data_structure.Dict, len(s.shard.set.shards))
+	for i, sh := range s.shard.set.shards {
+		dicts[i] = sh.server.dict
+	}
+	return dicts
+}
+
+// resetStats zeroes the counters reported by INFO
+func (s *Server) resetStats() {
+	s.stats.Reset()
+	for _, dict := range s.dicts() {
+		dict.ResetExpiredKeys()
+	}
+}
+
+// sampleStats samples the instantaneous metrics and the used memory until
+// done is closed
+func (s *Server) sampleStats(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second / statsHz)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.stats.Sample(now)
+			s.sampleUsedMemory()
+		}
+	}
+}
+
+// sampleUsedMemory records the heap of the Go runtime, reading it stops the
+// world so the commands do not read it themselves
+func (s *Server) sampleUsedMemory() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s.stats.UsedMemory.Store(int64(m.HeapAlloc))
+}
+
+// bytesToHuman formats a number of bytes the way the *_human fields of
+// INFO show them
+func bytesToHuman(n int64) string {
+	units := []string{"K", "M", "G", "T", "P"}
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n) / 1024
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%s", v, units[i])
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfoCommand(t *testing.T) {
+	s := NewServer(config.NewConfig())
+
+	assert.Equal(t, "+OK\r\n", runCommand(s, "SET", "k", "v"))
+	assert.Equal(t, "+OK\r\n", runCommand(s, "SET", "t", "v", "EX", "100"))
+	runCommand(s, "GET", "k")
+	runCommand(s, "CONFIG", "SET", "nope", "1")
+
+	info := runCommand(s, "INFO")
+	for _, section := range []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Replication", "CPU", "Errorstats", "Keyspace"} {
+		assert.Contains(t, info, "# "+section+"\r\n")
+	}
+	assert.NotContains(t, info, "# Commandstats")
+	assert.Contains(t, info, "\r\ntotal_commands_processed:4\r\n")
+	assert.Contains(t, info, "\r\ntotal_error_replies:1\r\n")
+	assert.Contains(t, info, "\r\nerrorstat_ERR:count=1\r\n")
+	assert.Contains(t, info, "\r\ndb0:keys=2,expires=1,avg_ttl=0\r\n")
+
+	info = runCommand(s, "INFO", "commandstats", "CLIENTS")
+	assert.True(t, strings.HasPrefix(info, "$"))
+	assert.Contains(t, info, "# Clients\r\nconnected_clients:0\r\n")
+	assert.Contains(t, info, "\r\n\r\n# Commandstats\r\n")
+	assert.Contains(t, info, "\r\ncmdstat_set:calls=2,")
+	assert.Regexp(t, `cmdstat_config:calls=1,usec=\d+,usec_per_call=\d+\.\d\d,rejected_calls=0,failed_calls=1\r\n`, info)
+	assert.NotContains(t, info, "# Server")
+
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "RESETSTAT"))
+	info = runCommand(s, "INFO", "everything")
+	assert.Contains(t, info, "\r\ntotal_commands_processed:1\r\n")
+	assert.Contains(t, info, "# Commandstats\r\ncmdstat_config:calls=1,")
+	assert.Contains(t, info, "# Errorstats\r\n\r\n")
+}
+
+func TestBytesToHuman(t *testing.T) {
+	assert.Equal(t, "512B", bytesToHuman(512))
+	assert.Equal(t, "1.50K", bytesToHuman(1536))
+	assert.Equal(t, "1.00G", bytesToHuman(1<<30))
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/lyxuansang91/redis-crash-course/internal/core/io_multiplexing"
+	"github.com/lyxuansang91/redis-crash-course/threadpool"
+)
+
+// ioThreads fans the socket reads, RESP parsing and socket writes of the
+// clients that are ready in an event loop iteration out to a thread pool,
+// while the commands themselves are executed by the main thread in order
+type ioThreads struct {
+	// threads counts the main thread, the pool has threads-1 workers
+	threads int
+	pool    *threadpool.Pool
+	// readBufs holds one scratch read buffer per thread
+	readBufs [][]byte
+}
+
+func newIOThreads(threads int) *ioThreads {
+	pool := threadpool.NewPool(threads - 1)
+	pool.Start()
+	readBufs := make([][]byte, threads)
+	for i := range readBufs {
+		readBufs[i] = make([]byte, readBufSize)
+	}
+	return &ioThreads{
+		threads:  threads,
+		pool:     pool,
+		readBufs: readBufs,
+	}
+}
+
+// worthIt reports whether enough clients are ready to pay for waking the
+// threads up, a few clients are served faster by the main thread alone
+func (t *ioThreads) worthIt(clients int) bool {
+	return clients >= 2*t.threads
+}
+
+// run calls job for every client, the clients being split between the
+// threads. The main thread takes the first share and then waits for the
+// others to be done.
+func (t *ioThreads) run(clients []*core.Client, job func(thread int, i int, client *core.Client)) {
+	var wg sync.WaitGroup
+	for thread := 1; thread < t.threads; thread++ {
+		thread := thread
+		wg.Add(1)
+		t.pool.AddJob(func() {
+			defer wg.Done()
+			for i := thread; i < len(clients); i += t.threads {
+				job(thread, i, clients[i])
+			}
+		})
+	}
+	for i := 0; i < len(clients); i += t.threads {
+		job(0, i, clients[i])
+	}
+	wg.Wait()
+}
+
+// handleClientEventsThreaded serves the events of a loop iteration with the
+// I/O threads: clients are read and parsed in parallel, their commands are
+// executed serially, then the replies are written in parallel
+func (s *Server) handleClientEventsThreaded(events []io_multiplexing.Event) {
+	var readers, writers []*core.Client
+	for _, event := range events {
+		client, ok := s.clients[event.Fd]
+		if !ok {
+			continue
+		}
+		if event.Op.Has(io_multiplexing.OpError) {
+			logf(logWarning, "client connection error")
+			s.closeClient(client)
+			continue
+		}
+		if event.Op.Has(io_multiplexing.OpRead) {
+			readers = append(readers, client)
+		} else if event.Op.Has(io_multiplexing.OpHangup) {
+			logf(logVerbose, "client disconnected")
+			s.closeClient(client)
+			continue
+		}
+		if event.Op.Has(io_multiplexing.OpWrite) || event.Op.Has(io_multiplexing.OpRead) {
+			writers = append(writers, client)
+		}
+	}
+
+	readErrs := make([]error, len(readers))
+	s.ioThreads.run(readers, func(thread int, i int, client *core.Client) {
+		readErrs[i] = s.readQuery(client, s.ioThreads.readBufs[thread])
+		s.parseQueryBuffer(client)
+	})
+
+	disconnected := make(map[*core.Client]bool)
+	for i, client := range readers {
+		if s.clients[client.Fd] != client {
+			// killed by a command of another client
+			continue
+		}
+		err := readErrs[i]
+		if err != nil && err != io.EOF && err != syscall.ECONNRESET {
+			logf(logWarning, "read error: %v", err)
+			continue
+		}
+		// commands that arrived before the peer closed its side are still served
+		s.executeCommands(client)
+		if err != nil {
+			disconnected[client] = true
+		}
+	}
+
+	writeErrs := make([]error, len(writers))
+	s.ioThreads.run(writers, func(thread int, i int, client *core.Client) {
+		var n int
+		n, writeErrs[i] = client.Flush()
+		s.stats.NetOutputBytes.Add(int64(n))
+	})
+
+	for i, client := range writers {
+		if s.clients[client.Fd] != client {
+			// closed while its commands were executed
+			continue
+		}
+		if client.Flags&core.ClientFlagCloseASAP != 0 {
+			s.closeClient(client)
+			continue
+		}
+		err := writeErrs[i]
+		if err == nil {
+			err = s.updateWriteInterest(client)
+		}
+		if err != nil {
+			logf(logWarning, "err write: %v", err)
+			s.closeClient(client)
+			continue
+		}
+		if disconnected[client] {
+			logf(logVerbose, "client disconnected")
+			s.closeClient(client)
+			continue
+		}
+		if client.Flags&(core.ClientFlagCloseAfterReply|core.ClientFlagBlocked) == core.ClientFlagCloseAfterReply && !client.HasPendingOutput() {
+			s.closeClient(client)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/lyxuansang91/redis-crash-course/internal/core/io_multiplexing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIOThreads(t *testing.T) {
+	log.SetOutput(io.Discard)
+	cfg := config.NewConfig()
+	cfg.IOThreads = 2
+	s := NewServer(cfg)
+	ioMultiplexer, err := io_multiplexing.NewIOMultiplexer(cfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer ioMultiplexer.Close()
+	s.ioMultiplexer = ioMultiplexer
+	s.ioThreads = newIOThreads(cfg.IOThreads)
+	defer s.ioThreads.pool.Stop()
+	defer s.closeClients()
+
+	// every client is connected through a socket pair, the test holds the
+	// peer end
+	var clients []*core.Client
+	var peers []net.Conn
+	var events []io_multiplexing.Event
+	for i := 0; i < 8; i++ {
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Nil(t, syscall.SetNonblock(fds[0], true))
+		client := s.newClient(fds[0])
+		assert.Nil(t, s.addClient(client))
+		file := os.NewFile(uintptr(fds[1]), "peer")
+		peer, err := net.FileConn(file)
+		_ = file.Close()
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer peer.Close()
+		_ = peer.SetDeadline(time.Now().Add(5 * time.Second))
+		clients = append(clients, client)
+		peers = append(peers, peer)
+		events = append(events, io_multiplexing.Event{Fd: fds[0], Op: io_multiplexing.OpRead})
+	}
+	assert.True(t, s.ioThreads.worthIt(len(events)))
+
+	// the first six clients pipeline commands on their own key, the first
+	// one kills the last client, which then runs none of its commands, and
+	// the seventh disconnects before its reply is written
+	expected := make([]string, len(peers))
+	for i, peer := range peers[:6] {
+		var query, replies strings.Builder
+		if i == 0 {
+			id := strconv.FormatInt(clients[7].ID, 10)
+			query.Write(core.Encode([]string{"CLIENT", "KILL", "ID", id}, false))
+			replies.WriteString(":1\r\n")
+		}
+		for j := 0; j < 50; j++ {
+			key, value := fmt.Sprintf("key:%d", i), strconv.Itoa(j)
+			query.Write(core.Encode([]string{"SET", key, value}, false))
+			query.Write(core.Encode([]string{"GET", key}, false))
+			replies.WriteString("+OK\r\n")
+			replies.Write(core.Encode(value, false))
+		}
+		_, err := peer.Write([]byte(query.String()))
+		assert.Nil(t, err)
+		expected[i] = replies.String()
+	}
+	_, err = peers[6].Write(core.Encode([]string{"PING"}, false))
+	assert.Nil(t, err)
+	_ = peers[6].Close()
+	_, err = peers[7].Write(core.Encode([]string{"SET", "killed", "1"}, false))
+	assert.Nil(t, err)
+
+	s.handleClientEventsThreaded(events)
+
+	for i, peer := range peers[:6] {
+		replies := make([]byte, len(expected[i]))
+		_, err := io.ReadFull(peer, replies)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[i], string(replies))
+	}
+	_, err = peers[7].Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "$-1\r\n", runCommand(s, "GET", "killed"))
+	assert.Len(t, s.clients, 6)
+}
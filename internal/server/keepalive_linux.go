@@ -0,0 +1,21 @@
+//go:build linux
+
+package server
+
+import "syscall"
+
+// setKeepAlive turns the TCP keepalive on for fd: the first probe is sent
+// after interval seconds of inactivity, then every third of it, and the
+// connection is reset after 3 unanswered probes.
+func setKeepAlive(fd int, interval int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, interval); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, max(interval/3, 1)); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, 3)
+}
@@ -0,0 +1,28 @@
+//go:build darwin
+
+package server
+
+import "syscall"
+
+// the syscall package of darwin lacks these, values from netinet/tcp.h
+const (
+	tcpKeepIntvl = 0x101
+	tcpKeepCnt   = 0x102
+)
+
+// setKeepAlive turns the TCP keepalive on for fd: the first probe is sent
+// after interval seconds of inactivity, then every third of it, and the
+// connection is reset after 3 unanswered probes. TCP_KEEPALIVE is the
+// darwin name of TCP_KEEPIDLE.
+func setKeepAlive(fd int, interval int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPALIVE, interval); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpKeepIntvl, max(interval/3, 1)); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpKeepCnt, 3)
+}
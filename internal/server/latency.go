@@ -0,0 +1,347 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// The latency monitor records the events that took at least
+// latency-monitor-threshold milliseconds in one time series per event. The
+// server reports the execution of the commands as the "command" event, it
+// has no expire cycle, persistence or eviction that could report theirs.
+
+// latencyEventCommand is the event of the commands execution
+const latencyEventCommand = "command"
+
+// latencySeriesLen is the number of samples kept per event, one per second
+// at most
+const latencySeriesLen = 160
+
+// latencyGraphCols is the width of LATENCY GRAPH
+const latencyGraphCols = 80
+
+type latencySample struct {
+	// time is the unix time of the sample in seconds, zero for an unused
+	// slot
+	time    int64
+	latency int64
+}
+
+// latencySeries is the ring of the latest samples of an event
+type latencySeries struct {
+	samples [latencySeriesLen]latencySample
+	idx     int
+	// max is the highest latency ever recorded for the event
+	max int64
+}
+
+// add records a latency in milliseconds, samples of the same second are
+// merged keeping the highest
+func (ts *latencySeries) add(now int64, latency int64) {
+	if latency > ts.max {
+		ts.max = latency
+	}
+	prev := &ts.samples[(ts.idx+latencySeriesLen-1)%latencySeriesLen]
+	if prev.time == now {
+		if latency > prev.latency {
+			prev.latency = latency
+		}
+		return
+	}
+	ts.samples[ts.idx] = latencySample{time: now, latency: latency}
+	ts.idx = (ts.idx + 1) % latencySeriesLen
+}
+
+// history returns the samples from the oldest to the newest
+func (ts *latencySeries) history() []latencySample {
+	var samples []latencySample
+	for j := 0; j < latencySeriesLen; j++ {
+		sample := ts.samples[(ts.idx+j)%latencySeriesLen]
+		if sample.time != 0 {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// latencyMonitor holds the time series of the events, in sharded mode the
+// loops share it
+type latencyMonitor struct {
+	mu     sync.Mutex
+	events map[string]*latencySeries
+}
+
+func newLatencyMonitor() *latencyMonitor {
+	return &latencyMonitor{events: make(map[string]*latencySeries)}
+}
+
+// addLatencySampleIfNeeded records an event that took d when the latency
+// monitor is enabled and d reaches its threshold
+func (s *Server) addLatencySampleIfNeeded(event string, d time.Duration) {
+	threshold := s.cfg().LatencyMonitorThreshold
+	latency := d.Milliseconds()
+	if threshold == 0 || latency < int64(threshold) {
+		return
+	}
+	m := s.latency
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts, ok := m.events[event]
+	if !ok {
+		ts = &latencySeries{}
+		m.events[event] = ts
+	}
+	ts.add(time.Now().Unix(), latency)
+}
+
+// latencyCommand implements LATENCY LATEST, HISTORY, RESET, GRAPH, DOCTOR
+// and HISTOGRAM
+func (s *Server) latencyCommand(cmd *core.Command, client *core.Client) {
+	if len(cmd.Args) == 0 {
+		client.AddReply(core.Encode(errors.New("ERR wrong number of arguments for 'latency' command"), false))
+		return
+	}
+	sub := strings.ToUpper(cmd.Args[0])
+	args := cmd.Args[1:]
+	wrongArgs := func() {
+		client.AddReply(core.Encode(fmt.Errorf("ERR wrong number of arguments for 'latency|%s' command", strings.ToLower(sub)), false))
+	}
+
+	m := s.latency
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch sub {
+	case "LATEST":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		reply := []any{}
+		for _, event := range sortedKeys(m.events) {
+			ts := m.events[event]
+			last := ts.samples[(ts.idx+latencySeriesLen-1)%latencySeriesLen]
+			reply = append(reply, []any{event, last.time, last.latency, ts.max})
+		}
+		client.AddReply(core.Encode(reply, false))
+	case "HISTORY":
+		if len(args) != 1 {
+			wrongArgs()
+			return
+		}
+		reply := []any{}
+		if ts, ok := m.events[args[0]]; ok {
+			for _, sample := range ts.history() {
+				reply = append(reply, []any{sample.time, sample.latency})
+			}
+		}
+		client.AddReply(core.Encode(reply, false))
+	case "RESET":
+		resets := 0
+		if len(args) == 0 {
+			resets = len(m.events)
+			m.events = make(map[string]*latencySeries)
+		}
+		for _, event := range args {
+			if _, ok := m.events[event]; ok {
+				delete(m.events, event)
+				resets++
+			}
+		}
+		client.AddReply(core.Encode(resets, false))
+	case "GRAPH":
+		if len(args) != 1 {
+			wrongArgs()
+			return
+		}
+		ts, ok := m.events[args[0]]
+		if !ok {
+			client.AddReply(core.Encode(fmt.Errorf("ERR No samples available for event '%s'", args[0]), false))
+			return
+		}
+		client.AddReply(core.Encode(latencyGraph(args[0], ts, time.Now().Unix()), false))
+	case "DOCTOR":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		client.AddReply(core.Encode(s.latencyDoctor(), false))
+	case "HISTOGRAM":
+		client.AddReply(core.Encode(s.latencyHistograms(args), false))
+	default:
+		client.AddReply(core.Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try LATENCY HELP.", cmd.Args[0]), false))
+	}
+}
+
+// latencyGraph draws the samples of an event as an ASCII graph, every
+// column labeled with how long ago the sample was taken
+func latencyGraph(event string, ts *latencySeries, now int64) string {
+	samples := ts.history()
+	var low, high int64
+	labels := make([]string, len(samples))
+	for i, sample := range samples {
+		if i == 0 || sample.latency < low {
+			low = sample.latency
+		}
+		if i == 0 || sample.latency > high {
+			high = sample.latency
+		}
+		labels[i] = elapsedLabel(now - sample.time)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - high %d ms, low %d ms (all time high %d ms)\n", event, high, low, ts.max)
+	b.WriteString(strings.Repeat("-", latencyGraphCols) + "\n")
+	for offset := 0; offset < len(samples); offset += latencyGraphCols {
+		end := offset + latencyGraphCols
+		if end > len(samples) {
+			end = len(samples)
+		}
+		renderSparkline(&b, samples[offset:end], labels[offset:end], low, high)
+	}
+	return b.String()
+}
+
+// elapsedLabel formats a number of seconds in the largest unit it holds
+func elapsedLabel(elapsed int64) string {
+	switch {
+	case elapsed < 60:
+		return fmt.Sprintf("%ds", elapsed)
+	case elapsed < 3600:
+		return fmt.Sprintf("%dm", elapsed/60)
+	case elapsed < 3600*24:
+		return fmt.Sprintf("%dh", elapsed/3600)
+	}
+	return fmt.Sprintf("%dd", elapsed/(3600*24))
+}
+
+// renderSparkline draws one column per sample on sparklineRows rows, each
+// row holding three levels, with the labels written vertically below
+func renderSparkline(b *strings.Builder, samples []latencySample, labels []string, low, high int64) {
+	const sparklineRows = 4
+	charset := "_o#"
+	steps := len(charset) * sparklineRows
+	span := float64(high - low)
+	if span == 0 {
+		span = 1
+	}
+	row := make([]byte, len(samples))
+	for r := 0; r < sparklineRows; r++ {
+		for j, sample := range samples {
+			step := int(float64(sample.latency-low) * float64(steps) / span)
+			if step >= steps {
+				step = steps - 1
+			}
+			row[j] = ' '
+			idx := step - (sparklineRows-r-1)*len(charset)
+			switch {
+			case idx >= 0 && idx < len(charset):
+				row[j] = charset[idx]
+			case idx >= len(charset):
+				row[j] = '|'
+			}
+		}
+		b.Write(row)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	for r := 0; ; r++ {
+		more := false
+		for j, label := range labels {
+			row[j] = ' '
+			if r < len(label) {
+				row[j] = label[r]
+				more = true
+			}
+		}
+		if !more {
+			return
+		}
+		b.Write(row)
+		b.WriteByte('\n')
+	}
+}
+
+// latencyDoctor analyzes the recorded events and returns a report with
+// advices, the monitor must be locked
+func (s *Server) latencyDoctor() string {
+	if s.cfg().LatencyMonitorThreshold == 0 {
+		return "Latency monitoring is disabled. Use CONFIG SET latency-monitor-threshold <milliseconds> to enable it.\n"
+	}
+	m := s.latency
+	if len(m.events) == 0 {
+		return "No latency spike was observed since the latency monitor was enabled or reset.\n"
+	}
+
+	var b strings.Builder
+	b.WriteString("Latency spikes were observed:\n\n")
+	for i, event := range sortedKeys(m.events) {
+		ts := m.events[event]
+		samples := ts.history()
+		var sum int64
+		for _, sample := range samples {
+			sum += sample.latency
+		}
+		avg := float64(sum) / float64(len(samples))
+		var deviation float64
+		for _, sample := range samples {
+			deviation += math.Abs(float64(sample.latency) - avg)
+		}
+		deviation /= float64(len(samples))
+		period := (samples[len(samples)-1].time - samples[0].time) / int64(len(samples))
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %.0fms, mean deviation %.0fms, period %d sec). Worst all time event %dms.\n",
+			i+1, event, len(samples), avg, deviation, period, ts.max)
+	}
+
+	b.WriteString("\nAdvices:\n\n")
+	if _, ok := m.events[latencyEventCommand]; ok {
+		b.WriteString("- Commands are slow to execute: look for them with SLOWLOG GET, slowlog-log-slower-than sets the time from which they are logged. " +
+			"Commands working on many elements, like DEL on many keys, should be split.\n")
+	}
+	if s.cfg().LatencyMonitorThreshold < 5 {
+		b.WriteString("- latency-monitor-threshold is low, spikes of a few milliseconds are expected when the system is loaded. " +
+			"Raise it to only record the latency that matters to the clients.\n")
+	}
+	return b.String()
+}
+
+// latencyHistograms returns the calls and the cumulative latency
+// distribution of the given commands, or of every command called when none
+// is given. The buckets are bounded by powers of two microseconds, only
+// the non empty ones are listed.
+func (s *Server) latencyHistograms(names []string) []any {
+	if len(names) == 0 {
+		names = s.stats.CommandNames()
+	}
+	reply := []any{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		cs := s.stats.Command(name)
+		if cs == nil || seen[name] || cs.Calls.Load() == 0 {
+			continue
+		}
+		seen[name] = true
+		buckets, count, _ := cs.Latency.Snapshot()
+		histogram := []any{}
+		var cumulative int64
+		for i, n := range buckets {
+			if n == 0 {
+				continue
+			}
+			cumulative += n
+			bound := int64(math.MaxInt64)
+			if i < core.LatencyBuckets {
+				bound = core.LatencyBucketBound(i)
+			}
+			histogram = append(histogram, bound, cumulative)
+		}
+		reply = append(reply, strings.ToLower(name), []any{"calls", count, "histogram_usec", histogram})
+	}
+	return reply
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencySeries(t *testing.T) {
+	ts := &latencySeries{}
+	ts.add(100, 5)
+	ts.add(100, 9)
+	ts.add(100, 3)
+	ts.add(101, 4)
+	assert.Equal(t, []latencySample{{time: 100, latency: 9}, {time: 101, latency: 4}}, ts.history())
+	assert.Equal(t, int64(9), ts.max)
+
+	for i := 0; i < latencySeriesLen; i++ {
+		ts.add(int64(200+i), 1)
+	}
+	samples := ts.history()
+	assert.Len(t, samples, latencySeriesLen)
+	assert.Equal(t, int64(200), samples[0].time)
+	assert.Equal(t, int64(9), ts.max)
+}
+
+func TestLatencyCommand(t *testing.T) {
+	s := NewServer(config.NewConfig())
+	s.addLatencySampleIfNeeded(latencyEventCommand, time.Second)
+	assert.Equal(t, "*0\r\n", runCommand(s, "LATENCY", "LATEST"))
+	assert.True(t, strings.HasPrefix(runCommand(s, "LATENCY", "DOCTOR"), "$"))
+
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "SET", "latency-monitor-threshold", "100"))
+	s.addLatencySampleIfNeeded(latencyEventCommand, 50*time.Millisecond)
+	assert.Equal(t, "*0\r\n", runCommand(s, "LATENCY", "LATEST"))
+	s.addLatencySampleIfNeeded(latencyEventCommand, 250*time.Millisecond)
+
+	value, err := core.Decode([]byte(runCommand(s, "LATENCY", "LATEST")))
+	assert.Nil(t, err)
+	latest := value.([]any)
+	if assert.Len(t, latest, 1) {
+		event := latest[0].([]any)
+		assert.Equal(t, "command", event[0])
+		assert.Equal(t, int64(250), event[2])
+		assert.Equal(t, int64(250), event[3])
+	}
+	value, err = core.Decode([]byte(runCommand(s, "LATENCY", "HISTORY", "command")))
+	assert.Nil(t, err)
+	assert.Len(t, value.([]any), 1)
+	assert.Equal(t, "*0\r\n", runCommand(s, "LATENCY", "HISTORY", "nope"))
+
+	value, err = core.Decode([]byte(runCommand(s, "LATENCY", "GRAPH", "command")))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(value.(string), "command - high 250 ms, low 250 ms (all time high 250 ms)\n"))
+	assert.Equal(t, "-ERR No samples available for event 'nope'\r\n", runCommand(s, "LATENCY", "GRAPH", "nope"))
+
+	value, err = core.Decode([]byte(runCommand(s, "LATENCY", "DOCTOR")))
+	assert.Nil(t, err)
+	assert.Contains(t, value.(string), "1. command: 1 latency spikes (average 250ms")
+
+	assert.Equal(t, ":0\r\n", runCommand(s, "LATENCY", "RESET", "nope"))
+	assert.Equal(t, ":1\r\n", runCommand(s, "LATENCY", "RESET"))
+	assert.Equal(t, "*0\r\n", runCommand(s, "LATENCY", "LATEST"))
+	assert.Equal(t, "-ERR unknown subcommand 'NOPE'. Try LATENCY HELP.\r\n", runCommand(s, "LATENCY", "NOPE"))
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	s := NewServer(config.NewConfig())
+	s.stats.RecordCall(core.CmdSet, 3*time.Microsecond, nil)
+	s.stats.RecordCall(core.CmdSet, 3*time.Microsecond, nil)
+	s.stats.RecordCall(core.CmdSet, 100*time.Microsecond, nil)
+
+	value, err := core.Decode([]byte(runCommand(s, "LATENCY", "HISTOGRAM", "set", "get", "nope")))
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"set", []any{"calls", int64(3), "histogram_usec", []any{int64(4), int64(2), int64(128), int64(3)}}}, value)
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// reservedFds is the number of fds kept on top of maxclients for the
+// listeners, the multiplexers and the wake up sockets
+const reservedFds = 32
+
+var (
+	// errMaxClients is sent to the connections accepted beyond maxclients
+	errMaxClients = []byte("-ERR max number of clients reached\r\n")
+	// errProtectedMode is sent to the clients refused by the protected mode
+	errProtectedMode = []byte("-DENIED Redis is running in protected mode because protected mode is enabled and no password is set " +
+		"for the default user. In this mode connections are only accepted from the loopback interface. " +
+		"If you want to connect from external computers to Redis you may adopt one of the following solutions: " +
+		"1) Just disable protected mode sending the command 'CONFIG SET protected-mode no' from the loopback interface " +
+		"by connecting to Redis from the same host the server is running, however MAKE SURE Redis is not publicly " +
+		"accessible from internet if you do so. Use CONFIG REWRITE to make this change permanent. " +
+		"2) Alternatively you can just disable the protected mode by editing the Redis configuration file, " +
+		"and setting the protected mode option to 'no', and then restarting the server. " +
+		"3) If you started the server manually just for testing, restart it with the '--protected-mode no' option. " +
+		"4) Set up an authentication password for the default user. " +
+		"NOTE: You only need to do one of the above things in order for the server to start accepting " +
+		"connections from the outside.\r\n")
+	// errOOM is the reply to the commands refused over maxmemory
+	errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+)
+
+// checkMaxMemory refuses the commands that may grow the dataset while the
+// used memory is over maxmemory, the noeviction policy. The used memory is
+// the one last sampled by sampleStats.
+func (s *Server) checkMaxMemory(cmd *core.Command) error {
+	maxMemory := s.cfg().MaxMemory
+	if maxMemory == 0 || s.stats.UsedMemory.Load() <= maxMemory {
+		return nil
+	}
+	if spec, ok := core.LookupCommand(cmd.Cmd); ok && spec.DenyOOM {
+		return errOOM
+	}
+	return nil
+}
+
+// adjustOpenFilesLimit raises the open files limit of the process so that
+// maxclients clients fit. When the limit cannot be raised enough maxclients
+// is lowered to what it allows.
+func (s *Server) adjustOpenFilesLimit() error {
+	maxClients := s.cfg().MaxConnections
+	needed := uint64(maxClients) + reservedFds
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		logf(logWarning, "unable to obtain the open files limit (%v), assuming 1024", err)
+		limit.Cur = 1024
+	}
+	if limit.Cur >= needed {
+		return nil
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: needed, Max: max(needed, limit.Max)}); err == nil {
+		logf(logNotice, "increased the open files limit to %d (it was %d)", needed, limit.Cur)
+		return nil
+	}
+	// without privileges the soft limit can still go up to the hard one
+	if limit.Max > limit.Cur && syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: limit.Max, Max: limit.Max}) == nil {
+		limit.Cur = limit.Max
+	}
+	if limit.Cur <= reservedFds {
+		return fmt.Errorf("the open files limit of %d is not enough for the server to start", limit.Cur)
+	}
+	allowed := int(limit.Cur - reservedFds)
+	logf(logWarning, "maxclients %d requires an open files limit of at least %d, it is %d: maxclients lowered to %d",
+		maxClients, needed, limit.Cur, allowed)
+	_, err := s.configStore.Update(func(c *config.Config) error {
+		c.MaxConnections = allowed
+		return nil
+	})
+	return err
+}
+
+// maxClientsReached reports whether a connection that was just accepted
+// goes over maxclients, it is then counted as rejected. The clients still
+// in their TLS handshake count. In the thread pool mode the clients are
+// also capped at the number of workers.
+func (s *Server) maxClientsReached() bool {
+	limit := s.cfg().MaxConnections
+	if s.threadPool != nil {
+		limit = min(limit, s.cfg().ThreadPoolSize)
+	}
+	if s.stats.ConnectedClients.Load()+s.life.handshaking.Load() < int64(limit) {
+		return false
+	}
+	s.stats.RejectedConnections.Add(1)
+	logf(logVerbose, "max number of clients reached, connection rejected")
+	return true
+}
+
+// isLocalAddr reports whether a client connecting from addr is local: it
+// came through the Unix socket or the loopback interface
+func isLocalAddr(addr string, unix bool) bool {
+	if unix {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// protectedModeDenies reports whether the protected mode refuses a client
+// connecting from addr, while the default user has no password only the
+// clients of the loopback interface and of the Unix socket are served. The
+// refused clients are counted as rejected.
+func (s *Server) protectedModeDenies(addr string, unix bool) bool {
+	if !s.cfg().ProtectedMode || isLocalAddr(addr, unix) || !s.acl.user(core.DefaultUser).nopass {
+		return false
+	}
+	s.stats.RejectedConnections.Add(1)
+	logf(logVerbose, "protected mode is enabled, connection from %s rejected", addr)
+	return true
+}
+
+// rejectClient sends reply to an accepted connection the server does not
+// serve and closes it, the reply is not retried when the socket does not
+// take it
+func rejectClient(fd int, reply []byte) {
+	_, _ = syscall.Write(fd, reply)
+	_ = syscall.Close(fd)
+}
+
+// rejectConn is rejectClient for a connection of the thread pool mode
+func rejectConn(conn net.Conn, reply []byte) {
+	_, _ = conn.Write(reply)
+	_ = conn.Close()
+}
+
+// setBacklog resizes the accept queue of the listener to tcp-backlog, Go
+// listens with the largest one the system allows. Calling listen again on
+// a listening socket only updates its backlog.
+func (s *Server) setBacklog(listener net.Listener) error {
+	backlog := s.cfg().TCPBacklog
+	sc, ok := listener.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if ctrlErr := raw.Control(func(fd uintptr) {
+		err = syscall.Listen(int(fd), backlog)
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set the listen backlog: %v", err)
+	}
+	// the kernel silently caps the backlog, only Linux tells by how much
+	if data, readErr := os.ReadFile("/proc/sys/net/core/somaxconn"); readErr == nil {
+		if somaxconn, convErr := strconv.Atoi(strings.TrimSpace(string(data))); convErr == nil && somaxconn < backlog {
+			logf(logWarning, "the TCP backlog setting of %d cannot be enforced because /proc/sys/net/core/somaxconn is set to the lower value of %d", backlog, somaxconn)
+		}
+	}
+	return nil
+}
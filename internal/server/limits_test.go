@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxClients(t *testing.T) {
+	modes := map[string]func(cfg *config.Config){
+		"event-loop": testModes["event-loop"],
+		"io_uring":   testModes["io_uring"],
+		"sharded":    testModes["sharded"],
+		// the clients are capped at the workers of the pool as well
+		"thread-pool": func(cfg *config.Config) {
+			cfg.ServerMode = config.ServerModeThreadPool
+			cfg.MaxConnections = 100
+			cfg.ThreadPoolSize = 1
+		},
+	}
+	for name, setup := range modes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, func(cfg *config.Config) {
+				cfg.MaxConnections = 1
+				setup(cfg)
+			})
+			conn, reader := server.dial(t)
+			assert.Equal(t, "+PONG", roundTrip(t, conn, reader, "PING"))
+
+			rejected, _ := server.dial(t)
+			data, err := io.ReadAll(rejected)
+			assert.Nil(t, err)
+			assert.Equal(t, "-ERR max number of clients reached\r\n", string(data))
+			assert.Equal(t, int64(1), server.stats.RejectedConnections.Load())
+			assert.Equal(t, "+PONG", roundTrip(t, conn, reader, "PING"))
+		})
+	}
+}
+
+func TestAdjustOpenFilesLimit(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.MaxConnections = 1
+	s := NewServer(cfg)
+	assert.Nil(t, s.adjustOpenFilesLimit())
+	assert.Equal(t, 1, s.cfg().MaxConnections)
+
+	// no system allows that many files, maxclients is lowered to the limit
+	cfg = config.NewConfig()
+	cfg.MaxConnections = 1 << 30
+	s = NewServer(cfg)
+	assert.Nil(t, s.adjustOpenFilesLimit())
+	assert.Less(t, s.cfg().MaxConnections, 1<<30)
+	var limit syscall.Rlimit
+	assert.Nil(t, syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit))
+	assert.Equal(t, limit.Cur-reservedFds, uint64(s.cfg().MaxConnections))
+}
+
+func TestMaxMemory(t *testing.T) {
+	cfg := config.NewConfig()
+	s := NewServer(cfg)
+	client := s.newClient(-1)
+	run := func(args ...string) string {
+		client.Commands = append(client.Commands, &core.Command{Cmd: args[0], Args: args[1:]})
+		s.executeCommands(client)
+		return string(takeReplies(client))
+	}
+	assert.Equal(t, "+OK\r\n", run("SET", "k", "v"))
+
+	// the heap of any Go program is over a byte
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "SET", "maxmemory", "1"))
+	s.sampleUsedMemory()
+	assert.Equal(t, "-OOM command not allowed when used memory > 'maxmemory'.\r\n", run("SET", "k", "w"))
+	assert.Equal(t, int64(1), s.stats.ErrorCounts()["OOM"])
+	// the commands that do not grow the dataset still run
+	assert.Equal(t, "$1\r\nv\r\n", run("GET", "k"))
+	assert.Equal(t, ":1\r\n", run("EXPIRE", "k", "100"))
+	assert.Equal(t, ":1\r\n", run("DEL", "k"))
+
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "SET", "maxmemory", "0"))
+	assert.Equal(t, "+OK\r\n", run("SET", "k", "w"))
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+)
+
+// serverListener is a socket the server accepts connections on. The event
+// loops accept from fd, which file holds open; file.Fd() must not be called
+// again, it would put the fd back in blocking mode.
+type serverListener struct {
+	net.Listener
+	file *os.File
+	fd   int
+	// tls is set for the listeners of the TLS port
+	tls bool
+}
+
+// bindAddr is an address a TCP port is bound to
+type bindAddr struct {
+	network string
+	address string
+	// optional addresses are skipped when they are not available
+	optional bool
+}
+
+// bindAddrs returns the addresses port is listened on, the bind addresses
+// or the host of the port setting when there are none. The data and TLS
+// ports share them.
+func bindAddrs(cfg *config.Config, port string) []bindAddr {
+	if len(cfg.Bind) == 0 {
+		host, _, _ := net.SplitHostPort(cfg.Port)
+		return []bindAddr{{network: cfg.Protocol, address: net.JoinHostPort(host, port)}}
+	}
+	return parseBindAddrs(cfg.Bind, port)
+}
+
+// parseBindAddrs returns the addresses of binds for port, binds following
+// the syntax of the bind directive. The IPv6 ones are listened on with
+// tcp6, which leaves the IPv4 addresses of the same port to the tcp4
+// listeners.
+func parseBindAddrs(binds []string, port string) []bindAddr {
+	addrs := make([]bindAddr, 0, len(binds))
+	for _, bind := range binds {
+		host := strings.TrimPrefix(bind, "-")
+		addr := bindAddr{network: "tcp4", optional: host != bind}
+		switch host {
+		case "*":
+			host = "0.0.0.0"
+		case "::*":
+			host = "::"
+			addr.network = "tcp6"
+		default:
+			if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+				addr.network = "tcp6"
+			}
+		}
+		addr.address = net.JoinHostPort(host, port)
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// listenTCP listens on addrs, the optional addresses that are not
+// available are skipped
+func listenTCP(addrs []bindAddr) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		listener, err := net.Listen(addr.network, addr.address)
+		if err != nil {
+			if addr.optional && (errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EAFNOSUPPORT)) {
+				logf(logWarning, "skipping the unavailable bind address %s: %v", addr.address, err)
+				continue
+			}
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %v", addr.address, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// openListeners listens on the bind addresses, unless the port is 0, on
+// the same addresses with the TLS port when it is set and on the Unix
+// socket when one is configured
+func (s *Server) openListeners() ([]*serverListener, error) {
+	cfg := s.cfg()
+	var listeners []*serverListener
+	listenPort := func(port string, secure bool) error {
+		tcpListeners, err := listenTCP(bindAddrs(cfg, port))
+		if err != nil {
+			return err
+		}
+		for _, listener := range tcpListeners {
+			listeners = append(listeners, &serverListener{Listener: listener, fd: -1, tls: secure})
+		}
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(cfg.Port); err != nil || port != "0" {
+		if err := listenPort(port, false); err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+	}
+	if cfg.TLSPort > 0 {
+		if err := listenPort(strconv.Itoa(cfg.TLSPort), true); err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+	}
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, &serverListener{Listener: listener, fd: -1})
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("configured to not listen anywhere: port is 0 and no unixsocket is set")
+	}
+	for _, l := range listeners {
+		if err := s.setBacklog(l.Listener); err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on the Unix socket at path, replacing the socket a
+// previous run may have left there, and gives it the perm mode
+func listenUnix(path string, perm uint32) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on unix socket %s: %v", path, err)
+	}
+	if perm != 0 {
+		if err = os.Chmod(path, os.FileMode(perm)); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set the permissions of unix socket %s: %v", path, err)
+		}
+	}
+	return listener, nil
+}
+
+// listen opens the listeners along with their fds for the event loops.
+// They must be closed with closeListeners.
+func (s *Server) listen() ([]*serverListener, error) {
+	if s.cfg().EdgeTriggered && !s.cfg().NonBlockingClients {
+		return nil, fmt.Errorf("edge-triggered mode requires non-blocking client sockets")
+	}
+	listeners, err := s.openListeners()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range listeners {
+		filer, ok := l.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("listener %s has no file descriptor", l.Addr())
+		}
+		if l.file, err = filer.File(); err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to get file descriptor from listener: %v", err)
+		}
+		l.fd = int(l.file.Fd())
+		if l.tls {
+			s.tlsFds = append(s.tlsFds, l.fd)
+		}
+		if s.cfg().NonBlockingClients {
+			// the listener is drained until EAGAIN, it must not block
+			if err = syscall.SetNonblock(l.fd, true); err != nil {
+				closeListeners(listeners)
+				return nil, fmt.Errorf("failed to set the listener non-blocking: %v", err)
+			}
+		}
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []*serverListener) {
+	for _, l := range listeners {
+		if l.file != nil {
+			l.file.Close()
+		}
+		l.Listener.Close()
+	}
+}
+
+// listenerFds returns the fds the event loops accept from
+func listenerFds(listeners []*serverListener) []int {
+	fds := make([]int, len(listeners))
+	for i, l := range listeners {
+		fds[i] = l.fd
+	}
+	return fds
+}
+
+// listenerAddrs lists the addresses of the listeners for the logs
+func listenerAddrs(listeners []*serverListener) string {
+	addrs := make([]string, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr().String()
+	}
+	return strings.Join(addrs, ", ")
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixSocket(t *testing.T) {
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "redis.sock")
+			server := startTestServer(t, func(cfg *config.Config) {
+				cfg.UnixSocket = path
+				cfg.UnixSocketPerm = 0700
+				setup(cfg)
+			})
+			conn, reader := dialTest(t, "unix", path)
+			assert.Equal(t, "+PONG", roundTrip(t, conn, reader, "PING"))
+			info := roundTrip(t, conn, reader, "CLIENT", "INFO")
+			assert.Contains(t, info, " addr="+path+":0 laddr="+path+":0 ")
+			assert.Contains(t, info, " flags=U ")
+			stat, err := os.Stat(path)
+			if assert.Nil(t, err) {
+				assert.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+			}
+
+			// TCP is still served alongside
+			tcpConn, tcpReader := server.dial(t)
+			assert.Equal(t, "+PONG", roundTrip(t, tcpConn, tcpReader, "PING"))
+		})
+	}
+}
+
+func TestNoListener(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Port = ":0"
+	assert.ErrorContains(t, NewServer(cfg).Run(), "configured to not listen anywhere")
+}
+
+func TestBindAddrs(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Port = "127.0.0.1:6379"
+	assert.Equal(t, []bindAddr{{network: "tcp", address: "127.0.0.1:6379"}}, bindAddrs(cfg, "6379"))
+	// the other ports are bound to the host of the data port
+	assert.Equal(t, []bindAddr{{network: "tcp", address: "127.0.0.1:9121"}}, bindAddrs(cfg, "9121"))
+
+	cfg.Bind = []string{"10.0.0.5", "-::1", "*", "::*"}
+	assert.Equal(t, []bindAddr{
+		{network: "tcp4", address: "10.0.0.5:6379"},
+		{network: "tcp6", address: "[::1]:6379", optional: true},
+		{network: "tcp4", address: "0.0.0.0:6379"},
+		{network: "tcp6", address: "[::]:6379"},
+	}, bindAddrs(cfg, "6379"))
+}
+
+// externalIPv4 returns an IPv4 address of the host outside of the
+// loopback, the test is skipped when there is none
+func externalIPv4(t *testing.T) string {
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	t.Skip("no IPv4 address outside of the loopback")
+	return ""
+}
+
+func TestBindAndProtectedMode(t *testing.T) {
+	log.SetOutput(io.Discard)
+	// the protected mode needs a client that is not on the loopback
+	external := externalIPv4(t)
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, func(cfg *config.Config) {
+				_, port, _ := net.SplitHostPort(cfg.Port)
+				cfg.Port = ":" + port
+				// ::1 is skipped where there is no IPv6
+				cfg.Bind = []string{"127.0.0.1", external, "-::1"}
+				setup(cfg)
+			})
+			_, port, _ := net.SplitHostPort(server.addr)
+			dial := func(host string) (net.Conn, *bufio.Reader) {
+				return dialTest(t, "tcp", net.JoinHostPort(host, port))
+			}
+			local, localReader := dial("127.0.0.1")
+			assert.Equal(t, "+PONG", roundTrip(t, local, localReader, "PING"))
+
+			_, remoteReader := dial(external)
+			line, err := remoteReader.ReadString('\n')
+			assert.Nil(t, err)
+			assert.True(t, strings.HasPrefix(line, "-DENIED Redis is running in protected mode"))
+			_, err = remoteReader.ReadString('\n')
+			assert.Equal(t, io.EOF, err)
+
+			assert.Equal(t, "+OK", roundTrip(t, local, localReader, "CONFIG", "SET", "protected-mode", "no"))
+			remote, remoteReader := dial(external)
+			assert.Equal(t, "+PONG", roundTrip(t, remote, remoteReader, "PING"))
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+)
+
+// Levels of the server log messages, a message is written when the
+// configured loglevel is at least as verbose as its level
+const (
+	logDebug int32 = iota
+	logVerbose
+	logNotice
+	logWarning
+)
+
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(logNotice)
+}
+
+// setLogLevel applies the loglevel setting, it affects every server of the
+// process
+func setLogLevel(level string) {
+	switch level {
+	case config.LogLevelDebug:
+		logLevel.Store(logDebug)
+	case config.LogLevelVerbose:
+		logLevel.Store(logVerbose)
+	case config.LogLevelNotice:
+		logLevel.Store(logNotice)
+	case config.LogLevelWarning:
+		logLevel.Store(logWarning)
+	}
+}
+
+// logf writes a message of the given level to the server log
+func logf(level int32, format string, args ...any) {
+	if level >= logLevel.Load() {
+		log.Printf(format, args...)
+	}
+}
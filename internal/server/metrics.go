@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/lyxuansang91/redis-crash-course/threadpool"
+)
+
+// listenMetrics starts listening for the /metrics scrapes on metrics-port,
+// on the metrics-bind addresses. The scrapes go through neither the
+// protected mode nor authentication, so by default only the loopback
+// interface is listened on.
+func (s *Server) listenMetrics() ([]net.Listener, error) {
+	listeners, err := listenTCP(parseBindAddrs(s.cfg().MetricsBind, strconv.Itoa(s.cfg().MetricsPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics: %v", err)
+	}
+	return listeners, nil
+}
+
+// serveMetrics serves /metrics on listeners until done is closed
+func (s *Server) serveMetrics(listeners []net.Listener, done <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-done
+		_ = srv.Close()
+	}()
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			logf(logNotice, "serving metrics on http://%s/metrics", listener.Addr())
+			err := srv.Serve(listener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+				logf(logWarning, "metrics listener failed: %v", err)
+			}
+		}(listener)
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(s.metrics()))
+}
+
+// metricsWriter formats metrics in the Prometheus text exposition format
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (w *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricsWriter) sample(name string, labels string, value any) {
+	fmt.Fprintf(&w.b, "%s%s %v\n", name, labels, value)
+}
+
+// metric writes a metric made of a single sample without labels
+func (w *metricsWriter) metric(name, kind, help string, value any) {
+	w.header(name, kind, help)
+	w.sample(name, "", value)
+}
+
+// histogram writes the samples of a histogram of durations in seconds,
+// extra holds the labels other than le
+func (w *metricsWriter) histogram(name string, extra []string, h *core.LatencyHistogram) {
+	bucket := func(le string) string {
+		// the full slice expression makes append copy extra
+		return labels(append(extra[:len(extra):len(extra)], "le", le)...)
+	}
+	buckets, count, sumUsec := h.Snapshot()
+	var cumulative int64
+	for i := 0; i < core.LatencyBuckets; i++ {
+		cumulative += buckets[i]
+		le := strconv.FormatFloat(float64(core.LatencyBucketBound(i))/1e6, 'g', -1, 64)
+		w.sample(name+"_bucket", bucket(le), cumulative)
+	}
+	w.sample(name+"_bucket", bucket("+Inf"), count)
+	w.sample(name+"_sum", labels(extra...), float64(sumUsec)/1e6)
+	w.sample(name+"_count", labels(extra...), count)
+}
+
+// labels formats name value pairs as the labels of a sample
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// metrics returns the text served on /metrics
+func (s *Server) metrics() string {
+	w := &metricsWriter{}
+	st := s.stats
+
+	w.metric("redis_uptime_seconds", "gauge", "Seconds since the server started.",
+		int64(time.Since(st.StartTime).Seconds()))
+	w.metric("redis_connected_clients", "gauge", "Clients currently connected.", st.ConnectedClients.Load())
+	w.metric("redis_blocked_clients", "gauge", "Clients waiting for the reply of another event loop.", st.BlockedClients.Load())
+	w.metric("redis_connections_received_total", "counter", "Connections accepted.", st.TotalConnectionsReceived.Load())
+	w.metric("redis_rejected_connections_total", "counter", "Connections closed for going over maxclients.", st.RejectedConnections.Load())
+	w.metric("redis_commands_processed_total", "counter", "Commands executed.", st.TotalCommandsProcessed.Load())
+
+	names := st.CommandNames()
+	w.header("redis_command_duration_seconds", "histogram", "Execution time of the commands.")
+	for _, name := range names {
+		w.histogram("redis_command_duration_seconds", []string{"cmd", strings.ToLower(name)}, &st.Command(name).Latency)
+	}
+	w.header("redis_command_failed_calls_total", "counter", "Commands that replied an error.")
+	for _, name := range names {
+		w.sample("redis_command_failed_calls_total", labels("cmd", strings.ToLower(name)), st.Command(name).FailedCalls.Load())
+	}
+	w.header("redis_errors_total", "counter", "Error replies by error code.")
+	counts := st.ErrorCounts()
+	for _, code := range sortedKeys(counts) {
+		w.sample("redis_errors_total", labels("code", code), counts[code])
+	}
+
+	var keys, expires, expired int64
+	for _, dict := range s.dicts() {
+		k, e := dict.Len()
+		keys += k
+		expires += e
+		expired += dict.ExpiredKeys()
+	}
+	w.header("redis_db_keys", "gauge", "Keys of the database.")
+	w.sample("redis_db_keys", labels("db", "db0"), keys)
+	w.header("redis_db_keys_expiring", "gauge", "Keys of the database with an expiry.")
+	w.sample("redis_db_keys_expiring", labels("db", "db0"), expires)
+	w.metric("redis_expired_keys_total", "counter", "Keys deleted for having expired.", expired)
+	w.metric("redis_evicted_keys_total", "counter", "Keys evicted to stay under maxmemory.", 0)
+
+	w.metric("redis_net_input_bytes_total", "counter", "Bytes read from the clients.", st.NetInputBytes.Load())
+	w.metric("redis_net_output_bytes_total", "counter", "Bytes written to the clients.", st.NetOutputBytes.Load())
+	w.metric("redis_client_query_buffer_limit_disconnections_total", "counter",
+		"Clients closed for going over their query buffer limit.", st.ClientQueryBufferLimitDisconnections.Load())
+	w.metric("redis_client_output_buffer_limit_disconnections_total", "counter",
+		"Clients closed for going over their output buffer limit.", st.ClientOutputBufferLimitDisconnections.Load())
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	w.metric("redis_memory_used_bytes", "gauge", "Heap allocated by the server.", m.HeapAlloc)
+
+	w.header("redis_event_loop_iteration_duration_seconds", "histogram", "Time spent handling the events of an event loop iteration.")
+	w.histogram("redis_event_loop_iteration_duration_seconds", nil, &st.EventLoopLatency)
+
+	pools := map[string]*threadpool.Pool{}
+	if s.threadPool != nil {
+		pools["clients"] = s.threadPool
+	}
+	if s.ioThreads != nil {
+		pools["io"] = s.ioThreads.pool
+	}
+	w.header("redis_thread_pool_queue_depth", "gauge", "Jobs waiting for a worker of the thread pool.")
+	for _, name := range sortedKeys(pools) {
+		w.sample("redis_thread_pool_queue_depth", labels("pool", name), pools[name].QueueDepth())
+	}
+	w.header("redis_thread_pool_busy_workers", "gauge", "Workers of the thread pool running a job.")
+	for _, name := range sortedKeys(pools) {
+		w.sample("redis_thread_pool_busy_workers", labels("pool", name), pools[name].Busy())
+	}
+	return w.b.String()
+}
+
+// sortedKeys returns the keys of m in alphabetical order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := startTestServer(t, func(cfg *config.Config) {
+		cfg.MetricsPort = freePort(t)
+	})
+	conn, reader := server.dial(t)
+	assert.Equal(t, "+OK", roundTrip(t, conn, reader, "SET", "key", "value"))
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/metrics", server.cfg().MetricsPort)
+	resp, err := http.Get(url)
+	if assert.Nil(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Contains(t, string(body), "# TYPE redis_command_duration_seconds histogram\n")
+		assert.Contains(t, string(body), "redis_command_duration_seconds_bucket{cmd=\"set\",le=\"+Inf\"} 1\n")
+		assert.Contains(t, string(body), "redis_command_duration_seconds_count{cmd=\"set\"} 1\n")
+		assert.Contains(t, string(body), "redis_connected_clients 1\n")
+		assert.Contains(t, string(body), "redis_db_keys{db=\"db0\"} 1\n")
+		assert.Contains(t, string(body), "redis_event_loop_iteration_duration_seconds_count ")
+	}
+
+	assert.Nil(t, server.stop())
+	// the metrics listener is closed along with the server
+	_, err = http.Get(url)
+	assert.NotNil(t, err)
+}
+
+func TestMetricsLoopbackOnly(t *testing.T) {
+	external := externalIPv4(t)
+	for _, binds := range [][]string{nil, {"*"}} {
+		server := startTestServer(t, func(cfg *config.Config) {
+			// the data port is served on every address
+			_, port, _ := net.SplitHostPort(cfg.Port)
+			cfg.Port = ":" + port
+			cfg.MetricsPort = freePort(t)
+			if binds != nil {
+				cfg.MetricsBind = binds
+			}
+		})
+		port := strconv.Itoa(server.cfg().MetricsPort)
+		resp, err := http.Get("http://" + net.JoinHostPort("127.0.0.1", port) + "/metrics")
+		if assert.Nil(t, err) {
+			_ = resp.Body.Close()
+		}
+		// the metrics port is only exposed when metrics-bind says so
+		resp, err = http.Get("http://" + net.JoinHostPort(external, port) + "/metrics")
+		if binds == nil {
+			assert.NotNil(t, err)
+		} else if assert.Nil(t, err) {
+			_ = resp.Body.Close()
+		}
+	}
+}
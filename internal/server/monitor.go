@@ -0,0 +1,256 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// Monitors are the clients that ran MONITOR, every command the server
+// executes is streamed to them. The loop executing a command is not always
+// the one serving a monitor: the line is appended to the pending lines of
+// the monitor and the goroutine serving it is woken up to move them to the
+// output buffer of the client, which is written like any other reply. A
+// monitor that does not keep up is closed once its output reaches the
+// client-output-buffer-limit.
+
+type monitor struct {
+	client *core.Client
+	// owner is the server whose loop serves the client
+	owner *Server
+	// wake tells the goroutine serving the client that lines are pending,
+	// signal receives the wake ups when it is not an event loop
+	wake   func()
+	signal chan struct{}
+
+	mu      sync.Mutex
+	pending []byte
+	// overflowed is set when the pending lines reached the hard output
+	// buffer limit, the client is closed rather than sent more
+	overflowed bool
+}
+
+// monitors lists the monitors of the server, in sharded mode the loops
+// share it
+type monitors struct {
+	mu   sync.RWMutex
+	list []*monitor
+	// count lets the loops skip formatting the commands when nobody is
+	// monitoring
+	count atomic.Int32
+}
+
+// push appends a line for the monitor and wakes its goroutine up
+func (m *monitor) push(line []byte, hardLimit int64) {
+	m.mu.Lock()
+	if !m.overflowed {
+		if hardLimit > 0 && int64(len(m.pending)+len(line)) >= hardLimit {
+			m.overflowed = true
+			m.pending = nil
+		} else {
+			m.pending = append(m.pending, line...)
+		}
+	}
+	m.mu.Unlock()
+	m.wake()
+}
+
+// take returns the pending lines and whether the monitor overflowed
+func (m *monitor) take() ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lines := m.pending
+	m.pending = nil
+	return lines, m.overflowed
+}
+
+// monitorCommand implements MONITOR, a client already monitoring is left
+// as is without a reply
+func (s *Server) monitorCommand(client *core.Client) {
+	if client.Flags&core.ClientFlagMonitor != 0 {
+		return
+	}
+	m := &monitor{client: client, owner: s}
+	if s.waker != nil {
+		m.wake = s.waker.wake
+	} else {
+		m.signal = make(chan struct{}, 1)
+		m.wake = func() {
+			select {
+			case m.signal <- struct{}{}:
+			default:
+			}
+		}
+	}
+	client.Flags |= core.ClientFlagMonitor
+	ms := s.monitors
+	ms.mu.Lock()
+	ms.list = append(ms.list, m)
+	ms.count.Add(1)
+	ms.mu.Unlock()
+	client.AddReply(core.Encode("OK", true))
+}
+
+// removeMonitor stops streaming the commands to a client that is closed
+func (s *Server) removeMonitor(client *core.Client) {
+	if client.Flags&core.ClientFlagMonitor == 0 {
+		return
+	}
+	ms := s.monitors
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for i, m := range ms.list {
+		if m.client == client {
+			ms.list = append(ms.list[:i], ms.list[i+1:]...)
+			ms.count.Add(-1)
+			return
+		}
+	}
+}
+
+// findMonitor returns the monitor of a client, nil when it is not
+// monitoring
+func (s *Server) findMonitor(client *core.Client) *monitor {
+	ms := s.monitors
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, m := range ms.list {
+		if m.client == client {
+			return m
+		}
+	}
+	return nil
+}
+
+// ownMonitors returns the monitors served by the loop of s
+func (s *Server) ownMonitors() []*monitor {
+	ms := s.monitors
+	if ms.count.Load() == 0 {
+		return nil
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var own []*monitor
+	for _, m := range ms.list {
+		if m.owner == s {
+			own = append(own, m)
+		}
+	}
+	return own
+}
+
+// feedMonitors streams a command run by client at now to the monitors.
+// Unknown commands are not streamed.
+func (s *Server) feedMonitors(cmd *core.Command, client *core.Client, now time.Time) {
+	ms := s.monitors
+	if ms.count.Load() == 0 {
+		return
+	}
+	if _, ok := core.LookupCommand(cmd.Cmd); !ok {
+		return
+	}
+	line := monitorLine(cmd, client, now)
+	// monitors are normal clients, like in Redis
+	hardLimit := s.cfg().ClientOutputBufferLimits[config.ClientClassNormal].HardLimitBytes
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, m := range ms.list {
+		m.push(line, hardLimit)
+	}
+}
+
+// flushMonitors writes the pending lines of the monitors served by the
+// event loop, the ones that overflowed are closed
+func (s *Server) flushMonitors() {
+	for _, m := range s.ownMonitors() {
+		lines, overflowed := m.take()
+		if overflowed {
+			s.stats.ClientOutputBufferLimitDisconnections.Add(1)
+			logf(logWarning, "err write: %v", errOutputBufferLimit)
+			s.closeClient(m.client)
+			continue
+		}
+		if len(lines) > 0 {
+			m.client.AddReply(lines)
+			s.writeToClient(m.client)
+		}
+	}
+}
+
+// monitorLine formats a command the way MONITOR streams it
+func monitorLine(cmd *core.Command, client *core.Client, now time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "+%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, client.Addr)
+	for _, arg := range monitorArgs(cmd) {
+		b.WriteByte(' ')
+		b.WriteString(quoteArg(arg))
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// monitorArgs returns the command name and arguments, with the credentials
+// given to AUTH, HELLO and ACL SETUSER redacted
+func monitorArgs(cmd *core.Command) []string {
+	args := append([]string{cmd.Cmd}, cmd.Args...)
+	switch cmd.Cmd {
+	case "AUTH":
+		for i := 1; i < len(args); i++ {
+			args[i] = "(redacted)"
+		}
+	case "HELLO":
+		for i := 1; i < len(args); i++ {
+			if strings.EqualFold(args[i], "AUTH") {
+				for j := i + 1; j <= i+2 && j < len(args); j++ {
+					args[j] = "(redacted)"
+				}
+				i += 2
+			}
+		}
+	case "ACL":
+		if len(args) > 1 && strings.EqualFold(args[1], "SETUSER") {
+			for i := 3; i < len(args); i++ {
+				if strings.HasPrefix(args[i], ">") || strings.HasPrefix(args[i], "<") {
+					args[i] = "(redacted)"
+				}
+			}
+		}
+	}
+	return args
+}
+
+// quoteArg quotes an argument escaping the quotes, backslashes and the non
+// printable bytes
+func quoteArg(arg string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch {
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\a':
+			b.WriteString(`\a`)
+		case c == '\b':
+			b.WriteString(`\b`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
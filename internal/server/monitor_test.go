@@ -0,0 +1,47 @@
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorStreamsCommands(t *testing.T) {
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, setup)
+			monitorConn, monitor := server.dial(t)
+			_, err := monitorConn.Write(core.Encode([]string{"MONITOR"}, false))
+			assert.Nil(t, err)
+			line, err := monitor.ReadString('\n')
+			assert.Nil(t, err)
+			assert.Equal(t, "+OK\r\n", line)
+
+			conn, _ := server.dial(t)
+			for _, args := range [][]string{{"SET", "key", "a \"b\"\n"}, {"GET", "key"}} {
+				_, err = conn.Write(core.Encode(args, false))
+				assert.Nil(t, err)
+			}
+
+			expected := []*regexp.Regexp{
+				regexp.MustCompile(`^\+\d+\.\d{6} \[0 127\.0\.0\.1:\d+\] "SET" "key" "a \\"b\\"\\n"\r\n$`),
+				regexp.MustCompile(`^\+\d+\.\d{6} \[0 127\.0\.0\.1:\d+\] "GET" "key"\r\n$`),
+			}
+			for _, re := range expected {
+				line, err = monitor.ReadString('\n')
+				assert.Nil(t, err)
+				assert.Regexp(t, re, line)
+			}
+		})
+	}
+}
+
+func TestMonitorArgs(t *testing.T) {
+	assert.Equal(t, []string{"AUTH", "(redacted)", "(redacted)"},
+		monitorArgs(&core.Command{Cmd: "AUTH", Args: []string{"user", "secret"}}))
+	assert.Equal(t, []string{"HELLO", "3", "AUTH", "(redacted)", "(redacted)", "SETNAME", "app"},
+		monitorArgs(&core.Command{Cmd: "HELLO", Args: []string{"3", "AUTH", "user", "secret", "SETNAME", "app"}}))
+	assert.Equal(t, `"\x00\xff\t\\"`, quoteArg("\x00\xff\t\\"))
+}
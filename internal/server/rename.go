@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// errUnknownCommand is replied to the commands that do not exist, the
+// renamed ones included when called by their old name
+var errUnknownCommand = errors.New("CMD NOT FOUND")
+
+// renameCommands applies the rename-command directives. The renamed
+// commands are only known by their new name afterwards, an empty new name
+// disables them. The commands this server does not have are skipped with a
+// warning so that the configs written for Redis load.
+func (s *Server) renameCommands() error {
+	renames := s.cfg().RenamedCommands
+	names := make([]string, 0, len(renames))
+	for name := range renames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// the old names are all freed before the new ones are taken, so that
+	// commands can swap their names
+	commandNames := make(map[string]string)
+	var known []string
+	for _, name := range names {
+		if _, ok := core.LookupCommand(name); !ok {
+			logf(logWarning, "rename-command: no such command '%s', ignored", strings.ToLower(name))
+			continue
+		}
+		commandNames[name] = ""
+		known = append(known, name)
+	}
+	for _, name := range known {
+		renamed := strings.ToUpper(renames[name])
+		if renamed == "" {
+			continue
+		}
+		_, taken := core.LookupCommand(renamed)
+		if original := commandNames[renamed]; original != "" || taken && renamed != name && !hasRename(renames, renamed) {
+			return fmt.Errorf("rename-command %s %s: the command name is already taken", strings.ToLower(name), renames[name])
+		}
+		commandNames[renamed] = name
+	}
+	s.commandNames = commandNames
+	return nil
+}
+
+// hasRename reports whether the command called name is renamed to another
+// name, its name is then free to be taken
+func hasRename(renames map[string]string, name string) bool {
+	renamed, ok := renames[name]
+	return ok && strings.ToUpper(renamed) != name
+}
+
+// resolveCommand returns cmd under the name of the command in the command
+// table, cmd itself when it was not renamed. The queued cmd keeps the name
+// it was called by, a command held by CLIENT PAUSE resolves the same way
+// once resumed. It returns errUnknownCommand for the old name of a renamed
+// command.
+func (s *Server) resolveCommand(cmd *core.Command) (*core.Command, error) {
+	name, ok := s.commandNames[cmd.Cmd]
+	if !ok {
+		return cmd, nil
+	}
+	if name == "" {
+		return nil, errUnknownCommand
+	}
+	return &core.Command{Cmd: name, Args: cmd.Args}, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameCommands(t *testing.T) {
+	log.SetOutput(io.Discard)
+	cfg := config.NewConfig()
+	cfg.RenamedCommands = map[string]string{"CONFIG": "some-secret-name", "SHUTDOWN": "", "FLUSHALL": "", "GET": "SET", "SET": "GET"}
+	s := NewServer(cfg)
+	assert.Nil(t, s.renameCommands())
+	client := s.newClient(-1)
+	run := func(args ...string) string {
+		client.Commands = append(client.Commands, &core.Command{Cmd: args[0], Args: args[1:]})
+		s.executeCommands(client)
+		return string(takeReplies(client))
+	}
+
+	assert.Equal(t, "-CMD NOT FOUND\r\n", run("CONFIG", "GET", "maxclients"))
+	assert.Equal(t, "*2\r\n$14\r\nacllog-max-len\r\n$3\r\n128\r\n", run("SOME-SECRET-NAME", "GET", "acllog-max-len"))
+	assert.Equal(t, "-CMD NOT FOUND\r\n", run("SHUTDOWN"))
+	// the names can be swapped
+	assert.Equal(t, "+OK\r\n", run("GET", "k", "v"))
+	assert.Equal(t, "$1\r\nv\r\n", run("SET", "k"))
+
+	// a command held by CLIENT PAUSE runs under the name it was sent with
+	assert.Equal(t, "+OK\r\n", run("CLIENT", "PAUSE", "10000", "WRITE"))
+	assert.Equal(t, "", run("GET", "k", "w"))
+	assert.NotZero(t, client.Flags&core.ClientFlagPaused)
+	s.unpauseClients()
+	client.Flags &^= core.ClientFlagPaused
+	assert.Equal(t, "+OK\r\n$1\r\nw\r\n", run("SET", "k"))
+
+	cfg = config.NewConfig()
+	cfg.RenamedCommands = map[string]string{"CONFIG": "get"}
+	assert.EqualError(t, NewServer(cfg).renameCommands(), "rename-command config get: the command name is already taken")
+	cfg.RenamedCommands = map[string]string{"CONFIG": "admin", "INFO": "ADMIN"}
+	assert.NotNil(t, NewServer(cfg).renameCommands())
+}
+
+func TestProtectedConfigs(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.EnableProtectedConfigs = config.EnableLocal
+	s := NewServer(cfg)
+	client := s.newClient(-1)
+	run := func(args ...string) string {
+		client.Commands = append(client.Commands, &core.Command{Cmd: args[0], Args: args[1:]})
+		s.executeCommands(client)
+		return string(takeReplies(client))
+	}
+
+	client.Addr = "10.0.0.1:5000"
+	assert.Equal(t, "-ERR CONFIG SET failed (possibly related to argument 'tls-key-file') - can't set protected config\r\n",
+		run("CONFIG", "SET", "maxclients", "100", "tls-key-file", "/etc/shadow"))
+	for _, name := range []string{"aclfile", "unixsocket"} {
+		assert.Equal(t, "-ERR CONFIG SET failed (possibly related to argument '"+name+"') - can't set protected config\r\n",
+			run("CONFIG", "SET", name, "/etc/passwd"))
+	}
+	assert.Equal(t, "+OK\r\n", run("CONFIG", "SET", "maxclients", "100"))
+	client.Addr = "127.0.0.1:5000"
+	assert.Equal(t, "+OK\r\n", run("CONFIG", "SET", "tls-key-file", "/etc/redis/server.key"))
+	assert.Equal(t, "-ERR CONFIG SET failed (possibly related to argument 'enable-protected-configs') - can't set immutable config\r\n",
+		run("CONFIG", "SET", "enable-protected-configs", "yes"))
+
+	// no client may change them by default
+	assert.Equal(t, "-ERR CONFIG SET failed (possibly related to argument 'tls-key-file') - can't set protected config\r\n",
+		runCommand(NewServer(config.NewConfig()), "CONFIG", "SET", "tls-key-file", "/etc/redis/server.key"))
+}
@@ -0,0 +1,408 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/lyxuansang91/redis-crash-course/internal/core/io_multiplexing"
+	"github.com/lyxuansang91/redis-crash-course/internal/data_structure"
+)
+
+// The sharded mode runs one event loop per shard in a shared-nothing
+// fashion: every loop owns the keys hashing to it and a part of the
+// connections. A command is executed by the loop owning its keys; when that
+// is not the loop of the client, the command is forwarded through the
+// lock-free inbox of the owner, which sends the reply back the same way.
+// Multi-key commands are split into one sub-command per owner and their
+// replies merged once all of them arrived, they are not atomic across
+// shards.
+
+type shardMessageKind int
+
+const (
+	// msgConnection hands an accepted connection over to a loop, secure is
+	// set for the ones of the TLS port
+	msgConnection shardMessageKind = iota
+	// msgExecute asks the owner of the keys to execute a command
+	msgExecute
+	// msgReply carries the reply of a forwarded command back to the loop
+	// of the client
+	msgReply
+	// msgCall asks a loop to run a function of a command broadcast to all
+	// the loops
+	msgCall
+)
+
+type shardMessage struct {
+	kind    shardMessageKind
+	connFd  int
+	secure  bool
+	cmd     *core.Command
+	request *forwardedCommand
+	call    func(s *Server) []byte
+	reply   []byte
+	// duration is the time the owner took to execute the command
+	duration time.Duration
+}
+
+// forwardedCommand is a command of a client waiting for the replies of
+// other loops. It is only modified by the loop of the client.
+type forwardedCommand struct {
+	client  *core.Client
+	cmd     *core.Command
+	origin  *shard
+	pending int
+	// scatter commands have their integer replies summed, errReply keeps
+	// the first error
+	scatter  bool
+	sum      int64
+	reply    []byte
+	errReply []byte
+	// broadcast commands have the results of the loops merged
+	merge   func(results [][]byte) []byte
+	results [][]byte
+	// duration sums the execution times of the owners
+	duration time.Duration
+}
+
+// scatterCommands are the multi-key commands whose keys may live in
+// different shards, they take only keys as arguments and reply an integer
+var scatterCommands = map[string]bool{
+	core.CmdDel:    true,
+	core.CmdExists: true,
+}
+
+// errCrossSlot is replied to the other multi-key commands when their keys
+// live in different shards
+var errCrossSlot = errors.New("CROSSSLOT Keys in request don't hash to the same shard")
+
+type shard struct {
+	id     int
+	set    *shardSet
+	server *Server
+	// inbox is read by the loop of the shard, which is woken up through
+	// the waker of its server when messages are queued
+	inbox *data_structure.MPSCQueue[*shardMessage]
+	// scratch collects the replies of the commands executed for other loops
+	scratch *core.Client
+}
+
+type shardSet struct {
+	shards []*shard
+	// nextConnection picks the loop of the next accepted connection, only
+	// the accepting loop uses it
+	nextConnection int
+}
+
+func newShard(id int, set *shardSet, server *Server) *shard {
+	sh := &shard{
+		id:      id,
+		set:     set,
+		server:  server,
+		inbox:   data_structure.NewMPSCQueue[*shardMessage](),
+		scratch: core.NewClient(-1),
+	}
+	server.shard = sh
+	return sh
+}
+
+// close drops the messages left in the inbox once the loop returned, the
+// connections that were handed over to it are closed
+func (sh *shard) close() {
+	for {
+		msg, ok := sh.inbox.Pop()
+		if !ok {
+			return
+		}
+		if msg.kind == msgConnection {
+			_ = syscall.Close(msg.connFd)
+		}
+	}
+}
+
+// send queues a message for the loop of sh, it may be called from any loop
+func (sh *shard) send(msg *shardMessage) {
+	sh.inbox.Push(msg)
+	sh.server.waker.wake()
+}
+
+// processInbox handles the messages sent to the loop, the waker of the
+// loop must be drained first
+func (sh *shard) processInbox() error {
+	for {
+		msg, ok := sh.inbox.Pop()
+		if !ok {
+			return nil
+		}
+		switch msg.kind {
+		case msgConnection:
+			if err := sh.server.registerClient(msg.connFd, msg.secure); err != nil {
+				return err
+			}
+		case msgExecute:
+			reply, duration := sh.executeCaptured(msg.cmd)
+			msg.request.origin.send(&shardMessage{
+				kind:     msgReply,
+				request:  msg.request,
+				reply:    reply,
+				duration: duration,
+			})
+		case msgCall:
+			start := time.Now()
+			result := msg.call(sh.server)
+			msg.request.origin.send(&shardMessage{
+				kind:     msgReply,
+				request:  msg.request,
+				reply:    result,
+				duration: time.Since(start),
+			})
+		case msgReply:
+			sh.completeForwarded(msg.request, msg.reply, msg.duration)
+		}
+	}
+}
+
+// assignConnection gives an accepted connection to the loops in turn
+func (sh *shard) assignConnection(connFd int, secure bool) error {
+	target := sh.set.shards[sh.set.nextConnection%len(sh.set.shards)]
+	sh.set.nextConnection++
+	if target == sh {
+		return sh.server.registerClient(connFd, secure)
+	}
+	target.send(&shardMessage{kind: msgConnection, connFd: connFd, secure: secure})
+	return nil
+}
+
+func (set *shardSet) owner(key string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return set.shards[h.Sum32()%uint32(len(set.shards))]
+}
+
+// executeCaptured executes a command on the keyspace of the loop and
+// returns its reply instead of queueing it for a client, along with the time
+// it took
+func (sh *shard) executeCaptured(cmd *core.Command) ([]byte, time.Duration) {
+	start := time.Now()
+	if err := sh.server.executor.ExecuteAndResponse(cmd, sh.scratch); err != nil {
+		logf(logWarning, "err execute: %v", err)
+	}
+	duration := time.Since(start)
+	out := &sh.scratch.Out
+	reply := make([]byte, 0, out.Size())
+	for chunk := out.Peek(); len(chunk) > 0; chunk = out.Peek() {
+		reply = append(reply, chunk...)
+		out.Advance(len(chunk))
+	}
+	return reply, duration
+}
+
+// dispatch executes a command of a client of this loop. Commands without
+// keys and commands on keys owned by this loop run right away, the others
+// block the client until the owners replied.
+func (sh *shard) dispatch(cmd *core.Command, client *core.Client) {
+	keys := cmd.Keys()
+	owners := make(map[*shard][]string)
+	var order []*shard
+	for _, key := range keys {
+		owner := sh.set.owner(key)
+		if _, ok := owners[owner]; !ok {
+			order = append(order, owner)
+		}
+		owners[owner] = append(owners[owner], key)
+	}
+
+	if len(order) == 0 || (len(order) == 1 && order[0] == sh) {
+		if err := sh.server.executor.ExecuteAndResponse(cmd, client); err != nil {
+			logf(logWarning, "err execute: %v", err)
+		}
+		return
+	}
+
+	request := &forwardedCommand{client: client, cmd: cmd, origin: sh}
+	if len(order) == 1 {
+		request.pending = 1
+		sh.block(client)
+		order[0].send(&shardMessage{kind: msgExecute, cmd: cmd, request: request})
+		return
+	}
+
+	if !scatterCommands[cmd.Cmd] {
+		client.AddReply(core.Encode(errCrossSlot, false))
+		return
+	}
+	request.scatter = true
+	request.pending = len(order)
+	sh.block(client)
+	for _, owner := range order {
+		sub := &core.Command{Cmd: cmd.Cmd, Args: owners[owner]}
+		if owner == sh {
+			reply, duration := sh.executeCaptured(sub)
+			sh.completeForwarded(request, reply, duration)
+			continue
+		}
+		owner.send(&shardMessage{kind: msgExecute, cmd: sub, request: request})
+	}
+}
+
+// broadcast runs call on every loop for a command of a client of this
+// loop, the client is blocked until the merge of the results is replied
+func (sh *shard) broadcast(cmd *core.Command, client *core.Client, call func(s *Server) []byte, merge func(results [][]byte) []byte) {
+	request := &forwardedCommand{client: client, cmd: cmd, origin: sh, merge: merge, pending: len(sh.set.shards)}
+	sh.block(client)
+	for _, other := range sh.set.shards {
+		if other != sh {
+			other.send(&shardMessage{kind: msgCall, call: call, request: request})
+		}
+	}
+	start := time.Now()
+	result := call(sh.server)
+	sh.completeForwarded(request, result, time.Since(start))
+}
+
+// block stops running the commands of the client until the replies of the
+// other loops arrived
+func (sh *shard) block(client *core.Client) {
+	client.Flags |= core.ClientFlagBlocked
+	sh.server.stats.BlockedClients.Add(1)
+}
+
+// completeForwarded records a reply of a forwarded command. Once all of
+// them arrived the client gets the merged reply and its next commands run.
+func (sh *shard) completeForwarded(request *forwardedCommand, reply []byte, duration time.Duration) {
+	request.duration += duration
+	if request.merge != nil {
+		request.results = append(request.results, reply)
+	} else if len(reply) > 0 && reply[0] == '-' {
+		if request.errReply == nil {
+			request.errReply = reply
+		}
+	} else if request.scatter {
+		if value, err := core.Decode(reply); err == nil {
+			if n, ok := value.(int64); ok {
+				request.sum += n
+			}
+		}
+	} else {
+		request.reply = reply
+	}
+	request.pending--
+	if request.pending > 0 {
+		return
+	}
+
+	client := request.client
+	client.Flags &^= core.ClientFlagBlocked
+	stats := sh.server.stats
+	stats.BlockedClients.Add(-1)
+	// the call was counted when it was forwarded, without the time the
+	// owners took and as succeeded
+	if cs := stats.Command(request.cmd.Cmd); cs != nil {
+		cs.Usec.Add(request.duration.Microseconds())
+		if request.errReply != nil {
+			cs.FailedCalls.Add(1)
+		}
+	}
+	if request.errReply != nil {
+		stats.RecordError(request.errReply)
+	}
+	sh.server.recordCommandDuration(request.cmd, client, request.duration)
+	if sh.server.clients[client.Fd] != client {
+		// the client went away while waiting
+		return
+	}
+	switch {
+	case request.errReply != nil:
+		client.AddReply(request.errReply)
+	case request.merge != nil:
+		client.AddReply(request.merge(request.results))
+	case request.scatter:
+		client.AddReply(core.Encode(request.sum, false))
+	default:
+		client.AddReply(request.reply)
+	}
+	client.ReplyDone()
+	sh.server.executeCommands(client)
+	sh.server.writeToClient(client)
+}
+
+// RunShardedServer serves clients with config.Shards event loops, each one
+// locked to its own OS thread and owning the part of the keyspace hashing
+// to it. The first loop accepts the connections and spreads them over all
+// loops. When a loop fails the others are shut down as well.
+func (s *Server) RunShardedServer() error {
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	defer closeListeners(listeners)
+	logf(logNotice, "starting a sharded I/O Multiplexing TCP server with %d event loops on %s", s.cfg().Shards, listenerAddrs(listeners))
+
+	set := &shardSet{}
+	var wakers []*waker
+	for i := 0; i < s.cfg().Shards; i++ {
+		server := s
+		if i > 0 {
+			server = NewServer(s.cfg())
+			server.stats = s.stats
+			server.slowlog = s.slowlog
+			server.latency = s.latency
+			server.monitors = s.monitors
+			server.pause = s.pause
+			server.acl = s.acl
+			server.commandNames = s.commandNames
+			server.life = s.life
+			server.configStore = s.configStore
+			server.tlsConfig = s.tlsConfig
+		}
+		sh := newShard(i, set, server)
+		defer sh.close()
+
+		w, err := newWaker()
+		if err != nil {
+			return err
+		}
+		defer w.close()
+		server.waker = w
+		wakers = append(wakers, w)
+
+		ioMultiplexer, err := io_multiplexing.NewIOMultiplexer(s.cfg())
+		if err != nil {
+			return fmt.Errorf("failed to create io multiplexer: %v", err)
+		}
+		defer ioMultiplexer.Close()
+		server.ioMultiplexer = ioMultiplexer
+		set.shards = append(set.shards, sh)
+	}
+
+	if !s.begin(nil, wakers...) {
+		return nil
+	}
+	defer s.end()
+
+	errs := make(chan error, len(set.shards))
+	for _, sh := range set.shards {
+		var serverFds []int
+		if sh.id == 0 {
+			serverFds = listenerFds(listeners)
+		}
+		go func(sh *shard, serverFds []int) {
+			runtime.LockOSThread()
+			errs <- sh.server.runEventLoop(serverFds)
+		}(sh, serverFds)
+	}
+
+	var firstErr error
+	for range set.shards {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			_ = s.startShutdown(ShutdownOptions{NoSave: true, Now: true})
+		}
+	}
+	return firstErr
+}
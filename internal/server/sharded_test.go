@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedServerRoutesKeys(t *testing.T) {
+	server := startTestServer(t, testModes["sharded"])
+	conn, reader := server.dial(t)
+
+	// pipeline the writes so replies of forwarded commands must stay in order
+	keys := make([]string, 32)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+		_, err := conn.Write(core.Encode([]string{"SET", keys[i], fmt.Sprintf("value:%d", i)}, false))
+		assert.Nil(t, err)
+	}
+	for range keys {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "+OK\r\n", line)
+	}
+
+	for i, key := range keys {
+		_, err := conn.Write(core.Encode([]string{"GET", key}, false))
+		assert.Nil(t, err)
+		_, _ = reader.ReadString('\n')
+		value, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("value:%d\r\n", i), value)
+	}
+
+	// keys spread over every shard are deleted through scatter-gather
+	_, err := conn.Write(core.Encode(append([]string{"DEL", "missing"}, keys...), false))
+	assert.Nil(t, err)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf(":%d\r\n", len(keys)), line)
+
+	_, err = conn.Write(core.Encode(append([]string{"EXISTS"}, keys...), false))
+	assert.Nil(t, err)
+	line, err = reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, ":0\r\n", line)
+}
+
+func TestShardedServerCrossSlot(t *testing.T) {
+	// EXISTS stands for a multi-key command that can't be scattered
+	delete(scatterCommands, core.CmdExists)
+	t.Cleanup(func() {
+		scatterCommands[core.CmdExists] = true
+	})
+	server := startTestServer(t, testModes["sharded"])
+	conn, reader := server.dial(t)
+
+	keys := make([]string, 32)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+	assert.Equal(t, "-CROSSSLOT Keys in request don't hash to the same shard",
+		roundTrip(t, conn, reader, append([]string{"EXISTS"}, keys...)...))
+	// the connection is still in sync
+	assert.Equal(t, "+PONG", roundTrip(t, conn, reader, "PING"))
+}
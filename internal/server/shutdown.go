@@ -0,0 +1,306 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// A shutdown goes through the following steps: the listeners stop
+// accepting, the loops keep serving until the replicas caught up with their
+// output or shutdown-timeout elapsed, then every client is marked to be
+// closed once its output buffer is flushed. The commands already read are
+// not run anymore, the loops return when all their clients are gone or at
+// the deadline, closing their multiplexer. Until clients are being closed a
+// shutdown can be aborted with SHUTDOWN ABORT.
+
+// ShutdownOptions are the flags of SHUTDOWN
+type ShutdownOptions struct {
+	// NoSave skips persisting the dataset, Save persists it even when no
+	// save point is configured
+	NoSave bool
+	Save   bool
+	// Now does not wait for the replicas to catch up
+	Now bool
+	// Force shuts down even when the dataset could not be persisted
+	Force bool
+}
+
+var (
+	errShutdownSave = errors.New("ERR Errors trying to SHUTDOWN. Check logs.")
+	errNoShutdown   = errors.New("ERR No shutdown in progress.")
+	errSyntax       = errors.New("ERR syntax error")
+)
+
+// lifecycle is the running state of a server shared by all its event
+// loops, Stop and SHUTDOWN go through it to reach every one of them
+type lifecycle struct {
+	// stopping is set while a shutdown is in progress
+	stopping atomic.Bool
+	// closing is set once the clients are being closed, the shutdown can no
+	// longer be aborted
+	closing atomic.Bool
+	// cronTicks counts the runs of the server cron, a loop runs its
+	// periodic tasks when it changed since it last looked
+	cronTicks atomic.Int64
+	// handshaking counts the TLS handshakes in progress, which count toward
+	// maxclients, handshakeSlots bounds the ones running at once
+	handshaking    atomic.Int64
+	handshakeSlots chan struct{}
+
+	mu sync.Mutex
+	// listeners are the listeners of the thread pool mode
+	listeners []net.Listener
+	// wakers wake up the event loops
+	wakers []*waker
+	// conns are the connections of the thread pool mode, connsWg counts
+	// the ones still served
+	conns   map[net.Conn]struct{}
+	connsWg sync.WaitGroup
+	// done is closed once the running server returned
+	done     chan struct{}
+	shutdown ShutdownOptions
+	deadline time.Time
+	// timer wakes the loops up at the deadline
+	timer *time.Timer
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		conns:          make(map[net.Conn]struct{}),
+		handshakeSlots: make(chan struct{}, maxTLSHandshakes),
+	}
+}
+
+// begin records what a shutdown needs to reach the server that is
+// starting and starts sampling the stats, running the cron and serving the
+// metrics until it ends. It returns false when Stop was already called, the
+// server must not run then.
+func (s *Server) begin(listeners []net.Listener, wakers ...*waker) bool {
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopping.Load() {
+		return false
+	}
+	l.listeners = listeners
+	l.wakers = wakers
+	l.done = make(chan struct{})
+	go s.sampleStats(l.done)
+	go s.serverCron(l.done)
+	if s.metricsListeners != nil {
+		s.serveMetrics(s.metricsListeners, l.done)
+	}
+	return true
+}
+
+// end marks the running server as returned
+func (s *Server) end() {
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = nil
+	l.wakers = nil
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	close(l.done)
+}
+
+// wakeLoops wakes every event loop up so that they look at the shutdown
+// state again
+func (s *Server) wakeLoops() {
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.wakers {
+		w.wake()
+	}
+}
+
+// startShutdown starts shutting the server down, it returns without
+// waiting for the loops. It fails when the dataset had to be persisted and
+// could not be, unless opts.Force is set.
+func (s *Server) startShutdown(opts ShutdownOptions) error {
+	if opts.Save {
+		logf(logWarning, "can't save the dataset before shutting down: persistence is not supported")
+		if !opts.Force {
+			return errShutdownSave
+		}
+	}
+
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopping.Load() {
+		return nil
+	}
+	logf(logNotice, "shutting down the server")
+	timeout := time.Duration(s.cfg().ShutdownTimeout) * time.Second
+	l.shutdown = opts
+	l.deadline = time.Now().Add(timeout)
+	l.stopping.Store(true)
+	for _, w := range l.wakers {
+		w.wake()
+	}
+	l.timer = time.AfterFunc(timeout, s.wakeLoops)
+
+	if l.listeners != nil {
+		// the thread pool mode has no replicas to wait for, its clients are
+		// closed right away once they sent their replies
+		l.closing.Store(true)
+		for _, listener := range l.listeners {
+			_ = listener.Close()
+		}
+		// the workers held by CLIENT PAUSE are released to be closed
+		p := s.pause
+		p.mu.Lock()
+		p.stop()
+		p.mu.Unlock()
+		for conn := range l.conns {
+			closeRead(conn)
+		}
+	}
+	return nil
+}
+
+// abortShutdown cancels the shutdown in progress, the listeners accept
+// connections again
+func (s *Server) abortShutdown() error {
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.stopping.Load() || l.closing.Load() {
+		return errNoShutdown
+	}
+	logf(logNotice, "shutdown aborted")
+	l.timer.Stop()
+	l.stopping.Store(false)
+	for _, w := range l.wakers {
+		w.wake()
+	}
+	return nil
+}
+
+// enterClosing moves the shutdown to the step where clients are closed. It
+// returns false when the shutdown was aborted in the meantime.
+func (s *Server) enterClosing() bool {
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.stopping.Load() {
+		return false
+	}
+	l.closing.Store(true)
+	return true
+}
+
+// pastDeadline reports whether shutdown-timeout elapsed since the shutdown
+// started
+func (s *Server) pastDeadline() bool {
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !time.Now().Before(l.deadline)
+}
+
+// replicasCaughtUp reports whether the loop can stop waiting for its
+// replicas: they were all sent their output, SHUTDOWN NOW was used or the
+// deadline passed
+func (s *Server) replicasCaughtUp() bool {
+	s.life.mu.Lock()
+	now := s.life.shutdown.Now
+	s.life.mu.Unlock()
+	if now || s.pastDeadline() {
+		return true
+	}
+	for _, client := range s.clients {
+		if client.Flags&core.ClientFlagReplica != 0 && client.HasPendingOutput() {
+			return false
+		}
+	}
+	return true
+}
+
+// closeClientsAfterReply marks every client of the loop to be closed once
+// its output buffer is flushed and flushes them
+func (s *Server) closeClientsAfterReply() {
+	for _, client := range s.clients {
+		client.Flags |= core.ClientFlagCloseAfterReply
+		if client.Flags&core.ClientFlagBlocked == 0 {
+			s.writeToClient(client)
+		}
+	}
+}
+
+// Stop gracefully shuts down the server like SHUTDOWN NOSAVE does, it is
+// called on SIGINT and SIGTERM. It returns once the running server
+// returned.
+func (s *Server) Stop() error {
+	if err := s.startShutdown(ShutdownOptions{NoSave: true}); err != nil {
+		return err
+	}
+	s.life.mu.Lock()
+	done := s.life.done
+	s.life.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	return nil
+}
+
+// shutdownCommand implements SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT].
+// The client gets no reply when the shutdown starts, it is disconnected
+// along with the others.
+func (s *Server) shutdownCommand(cmd *core.Command, client *core.Client) {
+	var opts ShutdownOptions
+	abort := false
+	for _, arg := range cmd.Args {
+		switch strings.ToUpper(arg) {
+		case "NOSAVE":
+			opts.NoSave = true
+		case "SAVE":
+			opts.Save = true
+		case "NOW":
+			opts.Now = true
+		case "FORCE":
+			opts.Force = true
+		case "ABORT":
+			abort = true
+		default:
+			client.AddReply(core.Encode(errSyntax, false))
+			return
+		}
+	}
+	if (opts.NoSave && opts.Save) || (abort && opts != ShutdownOptions{}) {
+		client.AddReply(core.Encode(errSyntax, false))
+		return
+	}
+
+	if abort {
+		if err := s.abortShutdown(); err != nil {
+			client.AddReply(core.Encode(err, false))
+			return
+		}
+		client.AddReply(core.Encode("OK", true))
+		return
+	}
+	if err := s.startShutdown(opts); err != nil {
+		client.AddReply(core.Encode(err, false))
+	}
+}
+
+// closeRead shuts down the reading side of a connection of the thread pool
+// mode, its worker sends the replies of what it already read and leaves
+func closeRead(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseRead() error }); ok {
+		_ = c.CloseRead()
+		return
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"io"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownCommand(t *testing.T) {
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, setup)
+			conn, reader := server.dial(t)
+			send := func(args ...string) string {
+				_, err := conn.Write(core.Encode(args, false))
+				assert.Nil(t, err)
+				line, _ := reader.ReadString('\n')
+				return line
+			}
+
+			assert.Equal(t, "-ERR No shutdown in progress.\r\n", send("SHUTDOWN", "ABORT"))
+			assert.Equal(t, "-ERR syntax error\r\n", send("SHUTDOWN", "SAVE", "NOSAVE"))
+			assert.Equal(t, "-ERR syntax error\r\n", send("SHUTDOWN", "NOW", "ABORT"))
+			// there is no persistence, saving fails unless forced
+			assert.Equal(t, "-ERR Errors trying to SHUTDOWN. Check logs.\r\n", send("SHUTDOWN", "SAVE"))
+
+			// the reply of a command sent before the shutdown is still sent
+			_, err := conn.Write(append(core.Encode([]string{"SET", "key", "value"}, false),
+				core.Encode([]string{"SHUTDOWN", "NOSAVE"}, false)...))
+			assert.Nil(t, err)
+			line, err := reader.ReadString('\n')
+			assert.Nil(t, err)
+			assert.Equal(t, "+OK\r\n", line)
+			_, err = reader.ReadString('\n')
+			assert.Equal(t, io.EOF, err)
+			assert.Nil(t, server.wait())
+		})
+	}
+}
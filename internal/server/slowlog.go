@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// The arguments kept in a slow log entry are bounded, the last one kept
+// tells how many more there were and long arguments are truncated
+const (
+	slowlogEntryMaxArgc = 32
+	slowlogEntryMaxArgs = 128
+)
+
+// slowlogEntry is a command that ran for longer than slowlog-log-slower-than
+type slowlogEntry struct {
+	id       int64
+	time     int64
+	duration int64
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowLog keeps the latest slow commands, oldest first. In sharded mode the
+// loops share it.
+type slowLog struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry
+	nextID  int64
+}
+
+// recordSlowCommand adds the command to the slow log when it ran for long
+// enough, the oldest entries are dropped to keep slowlog-max-len of them
+func (s *Server) recordSlowCommand(cmd *core.Command, client *core.Client, d time.Duration) {
+	c := s.cfg()
+	if c.SlowlogLogSlowerThan < 0 || d.Microseconds() < int64(c.SlowlogLogSlowerThan) {
+		return
+	}
+	entry := &slowlogEntry{
+		time:     time.Now().Unix(),
+		duration: d.Microseconds(),
+		args:     slowlogArgs(cmd),
+		addr:     client.Addr,
+		name:     client.Name,
+	}
+
+	l := s.slowlog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.id = l.nextID
+	l.nextID++
+	l.entries = append(l.entries, entry)
+	if extra := len(l.entries) - c.SlowlogMaxLen; extra > 0 {
+		n := copy(l.entries, l.entries[extra:])
+		clear(l.entries[n:])
+		l.entries = l.entries[:n]
+	}
+}
+
+// slowlogArgs returns the arguments of the command the way the slow log
+// keeps them, the credentials redacted like for the monitors
+func slowlogArgs(cmd *core.Command) []string {
+	argv := monitorArgs(cmd)
+	argc := len(argv)
+	if argc > slowlogEntryMaxArgc {
+		argc = slowlogEntryMaxArgc
+	}
+	args := make([]string, argc)
+	for i := 0; i < argc; i++ {
+		switch {
+		case i == argc-1 && argc != len(argv):
+			args[i] = fmt.Sprintf("... (%d more arguments)", len(argv)-argc+1)
+		case len(argv[i]) > slowlogEntryMaxArgs:
+			args[i] = fmt.Sprintf("%s... (%d more bytes)", argv[i][:slowlogEntryMaxArgs], len(argv[i])-slowlogEntryMaxArgs)
+		default:
+			args[i] = argv[i]
+		}
+	}
+	return args
+}
+
+// slowlogCommand implements SLOWLOG GET [count], LEN and RESET
+func (s *Server) slowlogCommand(cmd *core.Command, client *core.Client) {
+	if len(cmd.Args) == 0 {
+		client.AddReply(core.Encode(errors.New("ERR wrong number of arguments for 'slowlog' command"), false))
+		return
+	}
+	sub := strings.ToUpper(cmd.Args[0])
+	args := cmd.Args[1:]
+	wrongArgs := func() {
+		client.AddReply(core.Encode(fmt.Errorf("ERR wrong number of arguments for 'slowlog|%s' command", strings.ToLower(sub)), false))
+	}
+
+	l := s.slowlog
+	switch sub {
+	case "GET":
+		if len(args) > 1 {
+			wrongArgs()
+			return
+		}
+		count := 10
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < -1 {
+				client.AddReply(core.Encode(errors.New("ERR count should be greater than or equal to -1"), false))
+				return
+			}
+			count = n
+		}
+		l.mu.Lock()
+		if count == -1 || count > len(l.entries) {
+			count = len(l.entries)
+		}
+		// newest first
+		reply := make([]any, count)
+		for i := range reply {
+			entry := l.entries[len(l.entries)-1-i]
+			reply[i] = []any{entry.id, entry.time, entry.duration, entry.args, entry.addr, entry.name}
+		}
+		l.mu.Unlock()
+		client.AddReply(core.Encode(reply, false))
+	case "LEN":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		l.mu.Lock()
+		n := len(l.entries)
+		l.mu.Unlock()
+		client.AddReply(core.Encode(n, false))
+	case "RESET":
+		if len(args) != 0 {
+			wrongArgs()
+			return
+		}
+		l.mu.Lock()
+		l.entries = nil
+		l.mu.Unlock()
+		client.AddReply(core.Encode("OK", true))
+	default:
+		client.AddReply(core.Encode(fmt.Errorf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", cmd.Args[0]), false))
+	}
+}
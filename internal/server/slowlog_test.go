@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowlogCommand(t *testing.T) {
+	s := NewServer(config.NewConfig())
+	runCommand(s, "SET", "k", "v")
+	assert.Equal(t, ":0\r\n", runCommand(s, "SLOWLOG", "LEN"))
+
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "SET", "slowlog-log-slower-than", "0", "slowlog-max-len", "2"))
+	runCommand(s, "GET", "k")
+	runCommand(s, "GET", "other")
+	// the oldest entries are dropped, CONFIG SET itself was logged first
+	assert.Equal(t, ":2\r\n", runCommand(s, "SLOWLOG", "LEN"))
+
+	value, err := core.Decode([]byte(runCommand(s, "SLOWLOG", "GET")))
+	assert.Nil(t, err)
+	entries := value.([]any)
+	// SLOWLOG LEN was logged after the GETs
+	if assert.Len(t, entries, 2) {
+		newest := entries[0].([]any)
+		assert.Equal(t, int64(3), newest[0])
+		assert.Equal(t, []any{"SLOWLOG", "LEN"}, newest[3])
+		assert.Equal(t, []any{"GET", "other"}, entries[1].([]any)[3])
+	}
+	assert.Equal(t, "*1\r\n", runCommand(s, "SLOWLOG", "GET", "1")[:4])
+
+	assert.Equal(t, "+OK\r\n", runCommand(s, "SLOWLOG", "RESET"))
+	// SLOWLOG RESET itself is recorded once it returned
+	assert.Equal(t, ":1\r\n", runCommand(s, "SLOWLOG", "LEN"))
+	assert.Equal(t, "-ERR count should be greater than or equal to -1\r\n", runCommand(s, "SLOWLOG", "GET", "-2"))
+	assert.Equal(t, "-ERR unknown subcommand 'NOPE'. Try SLOWLOG HELP.\r\n", runCommand(s, "SLOWLOG", "NOPE"))
+
+	assert.Equal(t, "+OK\r\n", runCommand(s, "CONFIG", "SET", "slowlog-log-slower-than", "-1"))
+	runCommand(s, "SLOWLOG", "RESET")
+	runCommand(s, "GET", "k")
+	assert.Equal(t, ":0\r\n", runCommand(s, "SLOWLOG", "LEN"))
+}
+
+func TestSlowlogArgs(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = fmt.Sprint(i)
+	}
+	args[0] = strings.Repeat("x", 200)
+	kept := slowlogArgs(&core.Command{Cmd: "DEL", Args: args})
+	assert.Len(t, kept, slowlogEntryMaxArgc)
+	assert.Equal(t, "DEL", kept[0])
+	assert.Equal(t, strings.Repeat("x", 128)+"... (72 more bytes)", kept[1])
+	assert.Equal(t, "... (10 more arguments)", kept[31])
+}
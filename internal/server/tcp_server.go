@@ -1,12 +1,16 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/lyxuansang91/redis-crash-course/internal/config"
 	"github.com/lyxuansang91/redis-crash-course/internal/core"
@@ -17,155 +21,696 @@ import (
 
 // Server represents our TCP server
 type Server struct {
-	config *config.Config
-	listener net.Listener
-	port     string
-	executor core.CommandExecutor
+	// configStore holds the live config, CONFIG SET replaces it
+	configStore *config.Store
+	listener    net.Listener
+	port        string
+	executor    core.CommandExecutor
+	// dict is the keyspace the executor works on
+	dict    *data_structure.Dict
+	clients map[int]*core.Client
+	stats   *core.Stats
+	slowlog *slowLog
+	latency *latencyMonitor
+	// monitors are the clients streamed the executed commands
+	monitors *monitors
+	// pause is the state of CLIENT PAUSE, pausedClients the clients of the
+	// loop whose commands wait for it to end
+	pause         *clientPause
+	pausedClients []*core.Client
+	// acl holds the users and their permissions
+	acl *acl
+	// commandNames maps the names changed by rename-command to the commands
+	// they now call, the old names to nothing
+	commandNames map[string]string
+	// poolConns maps the clients of the thread pool mode to their
+	// connection, it is guarded by execMu
+	poolConns map[*core.Client]net.Conn
+	// ioMultiplexer is set while RunIoMultiplexingServer is running
+	ioMultiplexer io_multiplexing.IOMultiplexer
+	// readBuf is the scratch buffer socket reads land in before they are
+	// appended to the client query buffer
+	readBuf []byte
+	// ioThreads is set when threaded I/O is enabled
+	ioThreads *ioThreads
+	// threadPool serves the connections of the thread pool mode
+	threadPool *threadpool.Pool
+	// metricsListeners accept the /metrics scrapes when metrics-port is set
+	metricsListeners []net.Listener
+	// shard is set for the event loops of the sharded mode
+	shard *shard
+	// waker wakes the event loop up from other goroutines
+	waker *waker
+	// tlsConfig holds the current TLS settings, CONFIG SET replaces them.
+	// tlsFds are the listening fds of the TLS port and handshakes the
+	// clients handed back to the loop after their handshake.
+	tlsConfig  *atomic.Pointer[tls.Config]
+	tlsFds     []int
+	handshakes handshakes
+	// cronTicks is the value of life.cronTicks when the loop last ran its
+	// periodic tasks
+	cronTicks int64
+
+	// life is the running state shared by the loops of the server
+	life *lifecycle
+	// execMu serializes the commands of the thread pool mode, the keyspace
+	// is not safe for concurrent use
+	execMu sync.Mutex
 }
 
+// readBufSize is the number of bytes read from a client socket per syscall
+const readBufSize = 16 * 1024
 
 // NewServer creates a new TCP server instance
-func NewServer(config *config.Config) *Server {
+func NewServer(cfg *config.Config) *Server {
+	dict := data_structure.CreateDict()
 	return &Server{
-		config: config,
-		port: config.Port,
-		executor: core.NewCommandExecutor(data_structure.CreateDict()),
+		configStore: config.NewStore(cfg),
+		port:        cfg.Port,
+		executor:    core.NewCommandExecutor(dict),
+		dict:        dict,
+		clients:     make(map[int]*core.Client),
+		stats:       core.NewStats(),
+		slowlog:     &slowLog{},
+		latency:     newLatencyMonitor(),
+		monitors:    &monitors{},
+		pause:       newClientPause(),
+		acl:         newACL(cfg.RequirePass),
+		readBuf:     make([]byte, readBufSize),
+		life:        newLifecycle(),
+		tlsConfig:   &atomic.Pointer[tls.Config]{},
 	}
 }
 
-func (s *Server) readCommand(fd int) (*core.Command, error) {
-	var buf = make([]byte, 512)
-	n, err := syscall.Read(fd, buf)
-	if err != nil {
-		return nil, err
+// cfg returns the current config of the server
+func (s *Server) cfg() *config.Config {
+	return s.configStore.Load()
+}
+
+// Run serves clients in the configured server mode until Stop is called or
+// an error occurs
+func (s *Server) Run() error {
+	setLogLevel(s.cfg().LogLevel)
+	for _, name := range s.cfg().IgnoredDirectives {
+		logf(logWarning, "%s is not supported, ignored", name)
+	}
+	if err := s.adjustOpenFilesLimit(); err != nil {
+		return err
+	}
+	if err := s.loadTLSConfig(); err != nil {
+		return err
+	}
+	if err := s.loadACL(); err != nil {
+		return err
+	}
+	if err := s.renameCommands(); err != nil {
+		return err
+	}
+	if s.cfg().MetricsPort > 0 {
+		listeners, err := s.listenMetrics()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+		}()
+		s.metricsListeners = listeners
 	}
-	if n == 0 {
-		return nil, io.EOF
+	switch s.cfg().ServerMode {
+	case config.ServerModeEventLoop:
+		if s.cfg().Shards > 1 {
+			return s.RunShardedServer()
+		}
+		return s.RunIoMultiplexingServer()
+	case config.ServerModeThreadPool:
+		if s.cfg().Shards > 1 || s.cfg().IOThreads > 1 {
+			return fmt.Errorf("shards and io threads are only supported by the %s server mode", config.ServerModeEventLoop)
+		}
+		return s.RunThreadPoolServer()
+	default:
+		return fmt.Errorf("unknown server mode %q", s.cfg().ServerMode)
 	}
-	return core.ParseCmd(buf)
 }
 
-func (s *Server) RunIoMultiplexingServer() error {
-	log.Println("starting an I/O Multiplexing TCP server on", s.config.Port)
-	listener, err := net.Listen(s.config.Protocol, s.config.Port)
+// readQuery reads from the client socket into its query buffer, using buf
+// as scratch space. In edge-triggered mode the socket is drained until
+// EAGAIN, as no further event is reported for data that is already there.
+func (s *Server) readQuery(client *core.Client, buf []byte) error {
+	for {
+		n, err := syscall.Read(client.Fd, buf)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				return nil
+			}
+			return err
+		}
+		if n == 0 {
+			return io.EOF
+		}
+		s.stats.NetInputBytes.Add(int64(n))
+		if err := client.AppendInput(buf[:n]); err != nil {
+			// the TLS session failed, the client is closed like on a
+			// disconnection
+			if err != io.EOF {
+				logf(logVerbose, "TLS error: %v", err)
+			}
+			return io.EOF
+		}
+		client.LastInteraction = time.Now()
+		// a client over its query buffer limit is closed by the parser, the
+		// rest of its data is not worth reading
+		if !s.cfg().EdgeTriggered || s.queryBufferLimitReached(client) {
+			return nil
+		}
+	}
+}
+
+// parseQueryBuffer moves every complete command in the query buffer of the
+// client to client.Commands. The parser keeps the arguments of a partially
+// received command, only the bytes that follow them stay in the query
+// buffer. A client whose query goes over client-query-buffer-limit is
+// closed. It only touches the client and atomic counters, so I/O threads can
+// parse different clients in parallel.
+func (s *Server) parseQueryBuffer(client *core.Client) {
+	if s.queryBufferLimitReached(client) {
+		logf(logWarning, "closing client that reached max query buffer length (qbuf=%d)", len(client.QueryBuf))
+		s.stats.ClientQueryBufferLimitDisconnections.Add(1)
+		client.QueryBuf = nil
+		client.Flags |= core.ClientFlagCloseASAP
+		return
+	}
+	maxBulkLen := s.cfg().ProtoMaxBulkLen
+	pos := 0
+	for pos < len(client.QueryBuf) && client.QueryErr == nil {
+		cmd, n, err := client.Parser.Parse(client.QueryBuf[pos:], maxBulkLen)
+		pos += n
+		if err == core.ErrIncomplete {
+			break
+		}
+		if err != nil {
+			client.QueryErr = err
+			break
+		}
+		if cmd != nil {
+			client.Commands = append(client.Commands, cmd)
+		}
+	}
+	if pos > 0 {
+		client.QueryBuf = append(client.QueryBuf[:0], client.QueryBuf[pos:]...)
+	}
+}
+
+// queryBufferLimitReached reports whether the bytes held for the command
+// the client is sending are over client-query-buffer-limit
+func (s *Server) queryBufferLimitReached(client *core.Client) bool {
+	return int64(len(client.QueryBuf))+client.Parser.Pending() > s.cfg().ClientQueryBufferLimit
+}
+
+// executeCommands runs the parsed commands of the client in order. It stops
+// at a command that blocks the client or that CLIENT PAUSE holds, the others
+// are run once it is unblocked. A protocol error is replied after the
+// commands that preceded it and closes the client, and so does going over
+// the hard output buffer limit.
+func (s *Server) executeCommands(client *core.Client) {
+	client.OutputHardLimit = s.cfg().ClientOutputBufferLimits[client.Class()].HardLimitBytes
+	for len(client.Commands) > 0 {
+		if client.Flags&(core.ClientFlagCloseAfterReply|core.ClientFlagCloseASAP|core.ClientFlagBlocked|core.ClientFlagPaused|core.ClientFlagOutputLimitReached) != 0 {
+			return
+		}
+		cmd, err := s.resolveCommand(client.Commands[0])
+		if err == nil {
+			err = s.checkAccess(client, cmd)
+		}
+		if err == nil {
+			err = s.checkMaxMemory(cmd)
+		}
+		if err != nil {
+			client.Commands[0] = nil
+			client.Commands = client.Commands[1:]
+			reply := core.Encode(err, false)
+			s.stats.RecordError(reply)
+			client.AddReply(reply)
+			continue
+		}
+		if client.Flags&core.ClientFlagReplica == 0 && s.pause.blocks(cmd) {
+			client.Flags |= core.ClientFlagPaused
+			// the loops resume their paused clients once woken up, the
+			// workers of the thread pool wait for the pause themselves
+			if s.waker != nil {
+				s.pausedClients = append(s.pausedClients, client)
+			}
+			return
+		}
+		client.Commands[0] = nil
+		client.Commands = client.Commands[1:]
+		s.execute(cmd, client)
+	}
+	client.Commands = nil
+	if client.QueryErr != nil {
+		reply := core.Encode(client.QueryErr, false)
+		s.stats.RecordError(reply)
+		client.AddReply(reply)
+		client.Flags |= core.ClientFlagCloseAfterReply
+		client.QueryErr = nil
+	}
+}
+
+// execute streams a single command to the monitors, runs it and records it
+// in the command stats, the slow log and the latency monitor. The commands
+// acting on the server are run by the server itself, in sharded mode the
+// others are routed to the loops owning their keys.
+func (s *Server) execute(cmd *core.Command, client *core.Client) {
+	start := time.Now()
+	client.LastError = nil
+	client.LastInteraction = start
+	client.LastCmd = cmd.Cmd
+	s.feedMonitors(cmd, client, start)
+	s.dispatch(cmd, client)
+	d := time.Since(start)
+	s.stats.RecordCall(cmd.Cmd, d, client.LastError)
+	if client.Flags&core.ClientFlagBlocked == 0 {
+		// forwarded commands are logged once the owners replied
+		s.recordCommandDuration(cmd, client, d)
+		client.ReplyDone()
+	}
+}
+
+// recordCommandDuration records a command that ran for d in the slow log
+// and the latency monitor
+func (s *Server) recordCommandDuration(cmd *core.Command, client *core.Client, d time.Duration) {
+	s.recordSlowCommand(cmd, client, d)
+	s.addLatencySampleIfNeeded(latencyEventCommand, d)
+}
+
+func (s *Server) dispatch(cmd *core.Command, client *core.Client) {
+	if s.executeServerCommand(cmd, client) {
+		return
+	}
+	if s.shard != nil {
+		s.shard.dispatch(cmd, client)
+		return
+	}
+	if err := s.executor.ExecuteAndResponse(cmd, client); err != nil {
+		logf(logWarning, "err execute: %v", err)
+	}
+}
+
+// executeServerCommand runs cmd when it acts on the server rather than on
+// the keyspace, it reports whether it did
+func (s *Server) executeServerCommand(cmd *core.Command, client *core.Client) bool {
+	switch cmd.Cmd {
+	case core.CmdShutdown:
+		s.shutdownCommand(cmd, client)
+	case core.CmdConfig:
+		s.configCommand(cmd, client)
+	case core.CmdInfo:
+		s.infoCommand(cmd, client)
+	case core.CmdSlowlog:
+		s.slowlogCommand(cmd, client)
+	case core.CmdLatency:
+		s.latencyCommand(cmd, client)
+	case core.CmdMonitor:
+		s.monitorCommand(client)
+	case core.CmdClient:
+		s.clientCommand(cmd, client)
+	case core.CmdAuth:
+		s.authCommand(cmd, client)
+	case core.CmdHello:
+		s.helloCommand(cmd, client)
+	case core.CmdQuit:
+		s.quitCommand(client)
+	case core.CmdAcl:
+		s.aclCommand(cmd, client)
+	default:
+		return false
+	}
+	return true
+}
+
+// processQueryBuffer executes every complete command in the query buffer of
+// the client
+func (s *Server) processQueryBuffer(client *core.Client) {
+	s.parseQueryBuffer(client)
+	s.executeCommands(client)
+}
+
+// errOutputBufferLimit is returned by flushClient when the client has to be
+// disconnected for going over its client-output-buffer-limit
+var errOutputBufferLimit = errors.New("client output buffer limit reached")
+
+// checkOutputBufferLimit returns errOutputBufferLimit when the pending
+// output of the client is over the limits of its class
+func (s *Server) checkOutputBufferLimit(client *core.Client) error {
+	limit := s.cfg().ClientOutputBufferLimits[client.Class()]
+	if client.OutputBufferLimitReached(limit, time.Now()) {
+		s.stats.ClientOutputBufferLimitDisconnections.Add(1)
+		return errOutputBufferLimit
+	}
+	return nil
+}
+
+// flushClient writes the pending replies of the client. When the socket
+// does not accept everything, the fd is monitored for write events so the
+// rest is sent once it becomes writable; the write interest is dropped again
+// as soon as the output buffer is drained.
+func (s *Server) flushClient(client *core.Client) error {
+	n, err := client.Flush()
+	s.stats.NetOutputBytes.Add(int64(n))
 	if err != nil {
-		return fmt.Errorf("failed to listen on port %s: %v", s.config.Port, err)
+		return err
+	}
+	return s.updateWriteInterest(client)
+}
+
+// updateWriteInterest enforces the output buffer limits after a flush and
+// monitors the client for write events only while replies are pending
+func (s *Server) updateWriteInterest(client *core.Client) error {
+	if err := s.checkOutputBufferLimit(client); err != nil {
+		return err
+	}
+	pending := client.HasPendingOutput()
+	if pending == client.WriteMonitored {
+		return nil
+	}
+	op := io_multiplexing.OpRead
+	if pending {
+		op |= io_multiplexing.OpWrite
+	}
+	if err := s.ioMultiplexer.Modify(io_multiplexing.Event{
+		Fd: client.Fd,
+		Op: op,
+	}); err != nil {
+		return err
+	}
+	client.WriteMonitored = pending
+	return nil
+}
+
+// writeToClient flushes the client and closes it when the write fails, when
+// it was killed or when it was asked to be closed once its replies are sent
+// and is not waiting for one. It reports whether the client is still
+// connected.
+func (s *Server) writeToClient(client *core.Client) bool {
+	if client.Flags&core.ClientFlagCloseASAP != 0 {
+		s.closeClient(client)
+		return false
+	}
+	if err := s.flushClient(client); err != nil {
+		logf(logWarning, "err write: %v", err)
+		s.closeClient(client)
+		return false
+	}
+	if client.Flags&(core.ClientFlagCloseAfterReply|core.ClientFlagBlocked) == core.ClientFlagCloseAfterReply && !client.HasPendingOutput() {
+		s.closeClient(client)
+		return false
+	}
+	return true
+}
+
+func (s *Server) closeClient(client *core.Client) {
+	s.removeMonitor(client)
+	delete(s.clients, client.Fd)
+	s.stats.ConnectedClients.Add(-1)
+	if err := s.ioMultiplexer.Remove(client.Fd); err != nil {
+		logf(logWarning, "err remove fd %d from io multiplexer: %v", client.Fd, err)
 	}
-	defer listener.Close()
+	_ = syscall.Close(client.Fd)
+}
 
-	// Get the file descriptor from the listener
-	tcpListener, ok := listener.(*net.TCPListener)
+// handleClientEvent serves an I/O event reported for a client fd
+func (s *Server) handleClientEvent(event io_multiplexing.Event) {
+	client, ok := s.clients[event.Fd]
 	if !ok {
-		return fmt.Errorf("listener is not a TCPListener")
+		return
+	}
+	if event.Op.Has(io_multiplexing.OpError) {
+		logf(logWarning, "client connection error")
+		s.closeClient(client)
+		return
+	}
+	if event.Op.Has(io_multiplexing.OpWrite) && !s.writeToClient(client) {
+		return
+	}
+	if !event.Op.Has(io_multiplexing.OpRead) {
+		if event.Op.Has(io_multiplexing.OpHangup) {
+			logf(logVerbose, "client disconnected")
+			s.closeClient(client)
+		}
+		return
 	}
-	listenerFile, err := tcpListener.File()
 
+	err := s.readQuery(client, s.readBuf)
+	if err != nil && err != io.EOF && err != syscall.ECONNRESET {
+		logf(logWarning, "read error: %v", err)
+		return
+	}
+	// commands that arrived before the peer closed its side are still served
+	s.processQueryBuffer(client)
+	if !s.writeToClient(client) {
+		return
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get file descriptor from listener: %v", err)
+		logf(logVerbose, "client disconnected")
+		s.closeClient(client)
+	}
+}
+
+// acceptClients accepts the pending connections of the listening fd. With
+// non-blocking sockets every queued connection is taken until EAGAIN,
+// otherwise a single one is accepted per event.
+func (s *Server) acceptClients(serverFd int) error {
+	secure := slices.Contains(s.tlsFds, serverFd)
+	for {
+		logf(logDebug, "new client is trying to connect")
+		// set up new connection
+		connFd, _, err := accept(serverFd, s.cfg().NonBlockingClients)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.ECONNABORTED {
+				return nil
+			}
+			logf(logWarning, "err accept: %v", err)
+			return nil
+		}
+		logf(logVerbose, "set up a new connection")
+		switch {
+		case s.maxClientsReached():
+			rejectClient(connFd, errMaxClients)
+		case s.shard != nil:
+			// in sharded mode the connection may be served by another loop
+			err = s.shard.assignConnection(connFd, secure)
+		default:
+			err = s.registerClient(connFd, secure)
+		}
+		if err != nil {
+			return err
+		}
+		if !s.cfg().NonBlockingClients {
+			return nil
+		}
+	}
+}
+
+// registerClient starts serving an accepted connection, unless the server
+// is closing its clients. The connections of the TLS port are served once
+// their handshake succeeded.
+func (s *Server) registerClient(connFd int, secure bool) error {
+	if s.life.closing.Load() {
+		_ = syscall.Close(connFd)
+		return nil
+	}
+	client := s.newClient(connFd)
+	setClientAddrs(client, connFd)
+	if s.protectedModeDenies(client.Addr, client.Flags&core.ClientFlagUnixSocket != 0) {
+		rejectClient(connFd, errProtectedMode)
+		return nil
+	}
+	s.keepAlive(client)
+	if secure {
+		s.handshakeTLS(client)
+		return nil
 	}
-	defer listenerFile.Close()
+	return s.addClient(client)
+}
+
+// addClient adds a client to the ones served by the loop
+func (s *Server) addClient(client *core.Client) error {
+	s.clients[client.Fd] = client
+	s.stats.ConnectedClients.Add(1)
+	s.stats.TotalConnectionsReceived.Add(1)
+	// ask epoll to monitor this connection
+	if err := s.ioMultiplexer.Monitor(io_multiplexing.Event{
+		Fd: client.Fd,
+		Op: io_multiplexing.OpRead,
+	}); err != nil {
+		return fmt.Errorf("failed to monitor connection fd: %v", err)
+	}
+	return nil
+}
 
-	serverFd := int(listenerFile.Fd())
+// RunIoMultiplexingServer serves clients from a single event loop
+func (s *Server) RunIoMultiplexingServer() error {
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	defer closeListeners(listeners)
+	logf(logNotice, "starting an I/O Multiplexing TCP server on %s", listenerAddrs(listeners))
 
-	// Create an ioMultiplexer instance (epoll in Linux, kqueue in MacOS)
-	ioMultiplexer, err := io_multiplexing.CreateIOMultiplexer(s.config)
+	// Create an ioMultiplexer instance (epoll in Linux, kqueue in MacOS,
+	// or io_uring when it is selected and supported)
+	ioMultiplexer, err := io_multiplexing.NewIOMultiplexer(s.cfg())
 	if err != nil {
 		return fmt.Errorf("failed to create io multiplexer: %v", err)
 	}
 	defer ioMultiplexer.Close()
+	s.ioMultiplexer = ioMultiplexer
 
-	// Monitor "read" events on the Server FD
-	if err = ioMultiplexer.Monitor(io_multiplexing.Event{
-		Fd: serverFd,
+	w, err := newWaker()
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	s.waker = w
+	if !s.begin(nil, w) {
+		return nil
+	}
+	defer s.end()
+
+	if completionMultiplexer, ok := ioMultiplexer.(io_multiplexing.CompletionIOMultiplexer); ok {
+		return s.runCompletionLoop(listenerFds(listeners), completionMultiplexer)
+	}
+
+	// threaded I/O only applies to readiness based multiplexers
+	if s.cfg().IOThreads > 1 {
+		s.ioThreads = newIOThreads(s.cfg().IOThreads)
+		defer s.ioThreads.pool.Stop()
+	}
+	return s.runEventLoop(listenerFds(listeners))
+}
+
+// runEventLoop serves the fds monitored by s.ioMultiplexer until the server
+// is shut down or an error occurs. serverFds are the listening fds, none
+// for a loop that does not accept connections itself. The clients of the
+// loop are closed when it returns.
+func (s *Server) runEventLoop(serverFds []int) error {
+	defer s.closeClients()
+
+	// Monitor "read" events on the Server FDs
+	monitorServerFds := func() error {
+		for _, serverFd := range serverFds {
+			if err := s.ioMultiplexer.Monitor(io_multiplexing.Event{
+				Fd: serverFd,
+				Op: io_multiplexing.OpRead,
+			}); err != nil {
+				return fmt.Errorf("failed to monitor server fd: %v", err)
+			}
+		}
+		return nil
+	}
+	accepting := len(serverFds) > 0
+	if accepting {
+		if err := monitorServerFds(); err != nil {
+			return err
+		}
+	}
+	if err := s.ioMultiplexer.Monitor(io_multiplexing.Event{
+		Fd: s.waker.fd,
 		Op: io_multiplexing.OpRead,
 	}); err != nil {
-		return fmt.Errorf("failed to monitor server fd: %v", err)
+		return fmt.Errorf("failed to monitor the wake up socket: %v", err)
 	}
 
-	var events = make([]io_multiplexing.Event, config.MaxConnections)
+	closing := false
 	for {
+		if s.life.stopping.Load() {
+			if accepting {
+				for _, serverFd := range serverFds {
+					if err := s.ioMultiplexer.Remove(serverFd); err != nil {
+						return fmt.Errorf("failed to stop monitoring server fd: %v", err)
+					}
+				}
+				accepting = false
+			}
+			if !closing && s.replicasCaughtUp() && s.enterClosing() {
+				closing = true
+				s.closeClientsAfterReply()
+			}
+			if closing && (len(s.clients) == 0 || s.pastDeadline()) {
+				return nil
+			}
+		} else if !accepting && len(serverFds) > 0 {
+			// the shutdown was aborted
+			if err := monitorServerFds(); err != nil {
+				return err
+			}
+			accepting = true
+		}
+
 		// wait for file descriptors in the monitoring list to be ready for I/O
 		// it is a blocking call.
-		events, err = ioMultiplexer.Wait()
+		events, err := s.ioMultiplexer.Wait()
 		if err != nil {
-			continue
+			if err == syscall.EINTR {
+				continue
+			}
+			return fmt.Errorf("failed to wait for events: %v", err)
 		}
+		start := time.Now()
 
+		clientEvents := events[:0]
 		for i := 0; i < len(events); i++ {
-			if events[i].Fd == serverFd {
-				log.Println("new client is trying to connect")
-				// set up new connection
-				connFd, _, err := syscall.Accept(serverFd)
-				if err != nil {
-					log.Printf("err accept: %v\n", err)
+			switch {
+			case slices.Contains(serverFds, events[i].Fd):
+				if !accepting {
 					continue
 				}
-				log.Println("set up a new connection")
-				// ask epoll to monitor this connection
-				if err = ioMultiplexer.Monitor(io_multiplexing.Event{
-					Fd: connFd,
-					Op: io_multiplexing.OpRead,
-				}); err != nil {
-					return fmt.Errorf("failed to monitor connection fd: %v", err)
+				if err = s.acceptClients(events[i].Fd); err != nil {
+					return err
 				}
-			} else {
-				cmd, err := s.readCommand(events[i].Fd)
-				if err != nil {
-					if err == io.EOF || err == syscall.ECONNRESET {
-						log.Println("client disconnected")
-						_ = syscall.Close(events[i].Fd)
-						continue
-					}
-					log.Printf("read error: %v\n", err)
+			case events[i].Fd == s.waker.fd:
+				s.waker.drain()
+				s.runCron()
+				for _, client := range s.resumePausedClients() {
+					s.writeToClient(client)
+				}
+				s.flushMonitors()
+				if err = s.startTLSClients(); err != nil {
+					return err
+				}
+				if s.shard == nil {
 					continue
 				}
-				if err = s.executor.ExecuteAndResponse(cmd, events[i].Fd); err != nil {
-					log.Printf("err write: %v\n", err)
+				if err = s.shard.processInbox(); err != nil {
+					return err
 				}
+			default:
+				clientEvents = append(clientEvents, events[i])
 			}
 		}
-	}
-}
 
-// Start initializes and starts the TCP server
-func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", ":"+s.port)
-	if err != nil {
-		return fmt.Errorf("failed to start server: %v", err)
-	}
-	s.listener = listener
-	
-	fmt.Printf("TCP Server started on port %s\n", s.port)
-    fmt.Println("Waiting for connections...")
-
-    pool := threadpool.NewPool(10)
-    pool.Start()
-	// Accept connections in a loop
-	for {
-        conn, err := s.listener.Accept()
-		
-		if err != nil {
-            if errors.Is(err, net.ErrClosed) {
-                // Listener closed via Stop(); exit accept loop
-                return nil
-            }
-            log.Printf("Error accepting connection: %v", err)
-            continue
+		if s.ioThreads != nil && s.ioThreads.worthIt(len(clientEvents)) {
+			s.handleClientEventsThreaded(clientEvents)
+		} else {
+			for i := 0; i < len(clientEvents); i++ {
+				s.handleClientEvent(clientEvents[i])
+			}
 		}
-		
-        client := NewClient(conn)
-
-        // Queue a function task for the pool instead of calling directly
-        pool.AddJob(func() {
-            client.handleConnection()
-        })
+		s.stats.EventLoopLatency.Record(time.Since(start))
 	}
 }
 
-// Stop gracefully shuts down the server
-func (s *Server) Stop() error {
-	if s.listener != nil {
-		return s.listener.Close()
+// closeClients closes every client of the loop. Their pending replies are
+// sent first, as far as the sockets accept them right away.
+func (s *Server) closeClients() {
+	s.closeHandshakes()
+	for _, client := range s.clients {
+		n, _ := client.Flush()
+		s.stats.NetOutputBytes.Add(int64(n))
+		s.closeClient(client)
 	}
-	return nil
-} 
\ No newline at end of file
+}
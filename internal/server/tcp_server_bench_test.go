@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+)
+
+var (
+	benchServersMu sync.Mutex
+	benchServers   = make(map[string]string)
+)
+
+// Server modes compared by the benchmarks
+const (
+	benchLevelTriggered = "level-triggered"
+	benchEdgeTriggered  = "edge-triggered"
+	benchIOUring        = "io_uring"
+	benchIOThreads      = "io-threads"
+	benchSharded        = "sharded"
+	benchThreadPool     = "thread-pool"
+)
+
+// benchServer returns the address of a server running in the given mode, starting it on first use
+func benchServer(b *testing.B, mode string) string {
+	benchServersMu.Lock()
+	defer benchServersMu.Unlock()
+	if addr, ok := benchServers[mode]; ok {
+		return addr
+	}
+
+	log.SetOutput(io.Discard)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	cfg := *config.NewConfig()
+	cfg.Port = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.NonBlockingClients = true
+	cfg.EdgeTriggered = mode == benchEdgeTriggered
+	if mode == benchIOUring {
+		cfg.IOBackend = config.IOBackendIOUring
+	}
+	if mode == benchIOThreads {
+		cfg.IOThreads = 4
+	}
+	if mode == benchSharded {
+		cfg.Shards = 4
+	}
+	if mode == benchThreadPool {
+		cfg.ServerMode = config.ServerModeThreadPool
+		// every connection holds a worker
+		cfg.ThreadPoolSize = 6000
+	}
+	go func() {
+		_ = NewServer(&cfg).Run()
+	}()
+
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", cfg.Port)
+		if err == nil {
+			_ = conn.Close()
+			benchServers[mode] = cfg.Port
+			return cfg.Port
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.Fatalf("server did not start on %s", cfg.Port)
+	return ""
+}
+
+// benchmarkSetGet sends b.N SET and GET commands on random keys, spread
+// over the given number of connections
+func benchmarkSetGet(b *testing.B, mode string, connections int) {
+	addr := benchServer(b, mode)
+	conns := make([]net.Conn, connections)
+	for i := range conns {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	var remaining atomic.Int64
+	remaining.Store(int64(b.N))
+	wg := &sync.WaitGroup{}
+	b.ResetTimer()
+	for c, conn := range conns {
+		wg.Add(1)
+		go func(c int, conn net.Conn) {
+			defer wg.Done()
+			reader := bufio.NewReader(conn)
+			for i := 0; remaining.Add(-1) >= 0; i++ {
+				key := fmt.Sprintf("key:%d:%d", c, i%1000)
+				cmd := fmt.Sprintf("*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$1\r\nv\r\n*2\r\n$3\r\nGET\r\n$%d\r\n%s\r\n",
+					len(key), key, len(key), key)
+				if _, err := conn.Write([]byte(cmd)); err != nil {
+					b.Error(err)
+					return
+				}
+				// +OK, then $1 and v
+				for line := 0; line < 3; line++ {
+					if _, err := reader.ReadString('\n'); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			}
+		}(c, conn)
+	}
+	wg.Wait()
+}
+
+// benchmarkPing sends b.N PING commands spread over the given number of
+// connections, each connection waiting for its reply before the next one
+func benchmarkPing(b *testing.B, mode string, connections int) {
+	addr := benchServer(b, mode)
+	conns := make([]net.Conn, connections)
+	for i := range conns {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	ping := []byte("*1\r\n$4\r\nPING\r\n")
+	var remaining atomic.Int64
+	remaining.Store(int64(b.N))
+	wg := &sync.WaitGroup{}
+	b.ResetTimer()
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			reader := bufio.NewReader(conn)
+			for remaining.Add(-1) >= 0 {
+				if _, err := conn.Write(ping); err != nil {
+					b.Error(err)
+					return
+				}
+				if _, err := reader.ReadString('\n'); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}(conn)
+	}
+	wg.Wait()
+}
+
+func BenchmarkPingLevelTriggered(b *testing.B) {
+	for _, connections := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("conns=%d", connections), func(b *testing.B) {
+			benchmarkPing(b, benchLevelTriggered, connections)
+		})
+	}
+}
+
+func BenchmarkPingEdgeTriggered(b *testing.B) {
+	for _, connections := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("conns=%d", connections), func(b *testing.B) {
+			benchmarkPing(b, benchEdgeTriggered, connections)
+		})
+	}
+}
+
+func BenchmarkPingIOUring(b *testing.B) {
+	for _, connections := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("conns=%d", connections), func(b *testing.B) {
+			benchmarkPing(b, benchIOUring, connections)
+		})
+	}
+}
+
+func BenchmarkPingIOThreads(b *testing.B) {
+	for _, connections := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("conns=%d", connections), func(b *testing.B) {
+			benchmarkPing(b, benchIOThreads, connections)
+		})
+	}
+}
+
+func BenchmarkPingThreadPool(b *testing.B) {
+	for _, connections := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("conns=%d", connections), func(b *testing.B) {
+			benchmarkPing(b, benchThreadPool, connections)
+		})
+	}
+}
+
+func BenchmarkSetGetSingleLoop(b *testing.B) {
+	benchmarkSetGet(b, benchLevelTriggered, 100)
+}
+
+func BenchmarkSetGetSharded(b *testing.B) {
+	benchmarkSetGet(b, benchSharded, 100)
+}
+
+func BenchmarkSetGetThreadPool(b *testing.B) {
+	benchmarkSetGet(b, benchThreadPool, 100)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// testModes are the server modes the integration tests run in
+var testModes = map[string]func(cfg *config.Config){
+	"event-loop":  func(cfg *config.Config) {},
+	"io_uring":    func(cfg *config.Config) { cfg.IOBackend = config.IOBackendIOUring },
+	"sharded":     func(cfg *config.Config) { cfg.Shards = 4 },
+	"thread-pool": func(cfg *config.Config) { cfg.ServerMode = config.ServerModeThreadPool },
+}
+
+// testServer is a server started by startTestServer
+type testServer struct {
+	*Server
+	// addr is the address of the TCP port
+	addr string
+	// done is closed once Run returned err
+	done chan struct{}
+	err  error
+}
+
+// startTestServer runs a server on a free port of the loopback interface,
+// with the config adjusted by setup, and returns once it listens. It is
+// stopped when the test ends. The ports are picked before the server binds
+// them and may be taken in between, the server is then started again on
+// other ones.
+func startTestServer(t testing.TB, setup func(cfg *config.Config)) *testServer {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	for attempt := 1; ; attempt++ {
+		cfg := config.NewConfig()
+		cfg.Port = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+		setup(cfg)
+		ts := &testServer{Server: NewServer(cfg), addr: cfg.Port, done: make(chan struct{})}
+		go func() {
+			ts.err = ts.Run()
+			close(ts.done)
+		}()
+		if ts.listening() {
+			t.Cleanup(func() {
+				assert.Nil(t, ts.stop())
+			})
+			return ts
+		}
+		select {
+		case <-ts.done:
+			if ts.err != nil && strings.Contains(ts.err.Error(), syscall.EADDRINUSE.Error()) && attempt < 3 {
+				continue
+			}
+			t.Fatalf("the server did not start: %v", ts.err)
+		default:
+			t.Fatal("the server did not start")
+		}
+	}
+}
+
+// listening waits for the server to listen, it returns false when Run
+// returned or the server is still not listening after 5 seconds
+func (ts *testServer) listening() bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		select {
+		case <-ts.done:
+			return false
+		default:
+		}
+		ts.life.mu.Lock()
+		running := ts.life.done != nil
+		ts.life.mu.Unlock()
+		if running {
+			return true
+		}
+	}
+	return false
+}
+
+// stop stops the server and returns what Run returned, the server may have
+// been stopped already
+func (ts *testServer) stop() error {
+	if err := ts.Stop(); err != nil {
+		return err
+	}
+	return ts.wait()
+}
+
+// wait waits for Run to return, for a server that stops by itself
+func (ts *testServer) wait() error {
+	select {
+	case <-ts.done:
+		return ts.err
+	case <-time.After(5 * time.Second):
+		return errors.New("the server did not stop")
+	}
+}
+
+// dial connects to the TCP port of the server
+func (ts *testServer) dial(t testing.TB) (net.Conn, *bufio.Reader) {
+	return dialTest(t, "tcp", ts.addr)
+}
+
+// dialTest connects to address, the connection is closed when the test
+// ends and its reads and writes time out after 5 seconds
+func dialTest(t testing.TB, network, address string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial(network, address)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+// freePort returns a port of the loopback interface nothing listens on
+func freePort(t testing.TB) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// roundTrip sends a command and returns its reply without the trailing
+// CRLF, the content of a bulk string reply
+func roundTrip(t testing.TB, conn net.Conn, reader *bufio.Reader, args ...string) string {
+	_, err := conn.Write(core.Encode(args, false))
+	assert.Nil(t, err)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	line = strings.TrimSuffix(line, "\r\n")
+	if !strings.HasPrefix(line, "$") || line == "$-1" {
+		return line
+	}
+	n, _ := strconv.Atoi(line[1:])
+	data := make([]byte, n+2)
+	_, err = io.ReadFull(reader, data)
+	assert.Nil(t, err)
+	return string(data[:n])
+}
+
+func TestServerModesStop(t *testing.T) {
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, setup)
+			conn, reader := server.dial(t)
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "SET", "key", "value"))
+
+			assert.Nil(t, server.stop())
+			// the client was disconnected and the port released
+			_, err := conn.Read(make([]byte, 1))
+			assert.NotNil(t, err)
+			l, err := net.Listen("tcp", server.addr)
+			if assert.Nil(t, err) {
+				_ = l.Close()
+			}
+		})
+	}
+}
+
+func TestParseQueryBuffer(t *testing.T) {
+	log.SetOutput(io.Discard)
+	cfg := config.NewConfig()
+	cfg.ClientQueryBufferLimit = 1 << 20
+	s := NewServer(cfg)
+	feed := func(client *core.Client, data string) string {
+		client.QueryBuf = append(client.QueryBuf, data...)
+		s.processQueryBuffer(client)
+		return string(takeReplies(client))
+	}
+
+	// the arguments already received are not parsed again
+	client := s.newClient(-1)
+	assert.Equal(t, "", feed(client, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nva"))
+	assert.Equal(t, "va", string(client.QueryBuf))
+	assert.Equal(t, "+OK\r\n$5\r\nvalue\r\n", feed(client, "lue\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+
+	// the elements of a command must be bulk strings
+	client = s.newClient(-1)
+	assert.Equal(t, "-ERR Protocol error: expected '$', got '*'\r\n", feed(client, "*1048576\r\n*1048576\r\n"))
+	assert.NotZero(t, client.Flags&core.ClientFlagCloseAfterReply)
+
+	// a client sending a command over the query buffer limit is closed
+	client = s.newClient(-1)
+	feed(client, "*2\r\n$4\r\nPING\r\n$2000000\r\n")
+	assert.Equal(t, "", feed(client, string(make([]byte, 1<<20))))
+	assert.NotZero(t, client.Flags&core.ClientFlagCloseASAP)
+	assert.Equal(t, int64(1), s.stats.ClientQueryBufferLimitDisconnections.Load())
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+	"github.com/lyxuansang91/redis-crash-course/threadpool"
+)
+
+// RunThreadPoolServer serves every connection from its own worker of a
+// thread pool with blocking reads and writes. The commands go through the
+// same parsing and executor as in the event loop, one client at a time.
+func (s *Server) RunThreadPoolServer() error {
+	listeners, err := s.openListeners()
+	if err != nil {
+		return err
+	}
+	logf(logNotice, "starting a thread pool TCP server with %d workers on %s", s.cfg().ThreadPoolSize, listenerAddrs(listeners))
+	pool := threadpool.NewPool(s.cfg().ThreadPoolSize)
+	pool.Start()
+	defer pool.Stop()
+	s.threadPool = pool
+
+	netListeners := make([]net.Listener, len(listeners))
+	for i, l := range listeners {
+		netListeners[i] = l.Listener
+		if l.tls {
+			// the handshake runs on the first read of the worker
+			netListeners[i] = tls.NewListener(l.Listener, s.listenerTLSConfig())
+		}
+	}
+	if !s.begin(netListeners) {
+		closeListeners(listeners)
+		return nil
+	}
+	defer s.end()
+
+	var accepting sync.WaitGroup
+	for _, listener := range netListeners {
+		accepting.Add(1)
+		go func(listener net.Listener) {
+			defer accepting.Done()
+			s.acceptConns(listener, pool)
+		}(listener)
+	}
+	accepting.Wait()
+	// the listeners were closed by a shutdown, wait for the connections to
+	// send their replies
+	s.waitConns()
+	return nil
+}
+
+// acceptConns accepts the connections of a listener and hands them to the
+// pool until the listener is closed
+func (s *Server) acceptConns(listener net.Listener, pool *threadpool.Pool) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logf(logWarning, "Error accepting connection: %v", err)
+			continue
+		}
+		if s.maxClientsReached() {
+			rejectConn(conn, errMaxClients)
+			continue
+		}
+		if _, unix := conn.(*net.UnixConn); s.protectedModeDenies(conn.RemoteAddr().String(), unix) {
+			rejectConn(conn, errProtectedMode)
+			continue
+		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			continue
+		}
+
+		// A worker is busy with the connection until it is closed. The
+		// clients are capped at the pool size so the job waits at most for
+		// a worker releasing its connection, the accepting never waits.
+		if !pool.TryAddJob(func() { s.serveConn(conn) }) {
+			s.stats.RejectedConnections.Add(1)
+			rejectConn(conn, errMaxClients)
+			s.untrackConn(conn)
+		}
+	}
+}
+
+// trackConn records a connection for a shutdown to close, it returns
+// false when the server is shutting down
+func (s *Server) trackConn(conn net.Conn) bool {
+	l := s.life
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopping.Load() {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.connsWg.Add(1)
+	s.stats.ConnectedClients.Add(1)
+	s.stats.TotalConnectionsReceived.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	l := s.life
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+	conn.Close()
+	s.stats.ConnectedClients.Add(-1)
+	l.connsWg.Done()
+}
+
+// waitConns waits for the workers to release their connections, the ones
+// still open at the shutdown deadline are closed
+func (s *Server) waitConns() {
+	l := s.life
+	released := make(chan struct{})
+	go func() {
+		l.connsWg.Wait()
+		close(released)
+	}()
+
+	l.mu.Lock()
+	timeout := time.Until(l.deadline)
+	l.mu.Unlock()
+	select {
+	case <-released:
+		return
+	case <-time.After(timeout):
+	}
+	l.mu.Lock()
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+	<-released
+}
+
+// serveConn reads commands from the connection, executes them and writes
+// their replies until the client leaves or the server is stopped
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.untrackConn(conn)
+	client := s.newClient(-1)
+	setConnAddrs(client, conn)
+	s.keepAliveConn(conn)
+	s.execMu.Lock()
+	if s.poolConns == nil {
+		s.poolConns = make(map[*core.Client]net.Conn)
+	}
+	s.poolConns[client] = conn
+	s.execMu.Unlock()
+	defer func() {
+		s.execMu.Lock()
+		delete(s.poolConns, client)
+		s.execMu.Unlock()
+	}()
+
+	buf := make([]byte, readBufSize)
+	for {
+		n, readErr := conn.Read(buf)
+		serve, monitoring := s.serveInput(conn, client, buf[:n], readErr)
+		if !serve {
+			return
+		}
+		if monitoring {
+			s.serveMonitor(conn, client)
+			return
+		}
+	}
+}
+
+// serveInput executes the commands in data, read from the connection along
+// with readErr, and writes their replies. A client held by CLIENT PAUSE
+// waits for it to end. It reports whether the connection is still to be
+// served and whether the client ran MONITOR.
+//
+// The client is only touched while holding execMu, other clients may list
+// or kill it: its replies are taken out of the output buffer to be written.
+func (s *Server) serveInput(conn net.Conn, client *core.Client, data []byte, readErr error) (bool, bool) {
+	s.execMu.Lock()
+	s.stats.NetInputBytes.Add(int64(len(data)))
+	client.QueryBuf = append(client.QueryBuf, data...)
+	if len(data) > 0 {
+		client.LastInteraction = time.Now()
+	}
+	// commands that arrived before the peer closed its side are still served
+	s.parseQueryBuffer(client)
+	s.executeCommands(client)
+	replies, flags := takeReplies(client), client.Flags
+	s.execMu.Unlock()
+
+	for {
+		if flags&core.ClientFlagOutputLimitReached != 0 {
+			s.stats.ClientOutputBufferLimitDisconnections.Add(1)
+			logf(logWarning, "err write: %v", errOutputBufferLimit)
+			return false, false
+		}
+		if err := s.writeReplies(conn, replies); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logf(logWarning, "err write: %v", err)
+			}
+			return false, false
+		}
+		if flags&core.ClientFlagPaused == 0 {
+			break
+		}
+		<-s.pause.done()
+		s.execMu.Lock()
+		client.Flags &^= core.ClientFlagPaused
+		s.executeCommands(client)
+		replies, flags = takeReplies(client), client.Flags
+		s.execMu.Unlock()
+	}
+	if readErr != nil {
+		if readErr != io.EOF && !errors.Is(readErr, net.ErrClosed) {
+			logf(logWarning, "read error: %v", readErr)
+		}
+		return false, false
+	}
+	serve := flags&(core.ClientFlagCloseAfterReply|core.ClientFlagCloseASAP) == 0
+	return serve, flags&core.ClientFlagMonitor != 0
+}
+
+// takeReplies empties the output buffer of the client and returns what it
+// held
+func takeReplies(client *core.Client) []byte {
+	out := &client.Out
+	replies := make([]byte, 0, out.Size())
+	for chunk := out.Peek(); len(chunk) > 0; chunk = out.Peek() {
+		replies = append(replies, chunk...)
+		out.Advance(len(chunk))
+	}
+	return replies
+}
+
+// serveMonitor serves a client that ran MONITOR. The connection is read by
+// another goroutine so that the worker writes the streamed commands as soon
+// as they are pending, along with the replies of the commands the client
+// still sends.
+func (s *Server) serveMonitor(conn net.Conn, client *core.Client) {
+	m := s.findMonitor(client)
+	defer func() {
+		s.execMu.Lock()
+		s.removeMonitor(client)
+		s.execMu.Unlock()
+	}()
+	type read struct {
+		data []byte
+		err  error
+	}
+	reads := make(chan read)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		buf := make([]byte, readBufSize)
+		for {
+			n, err := conn.Read(buf)
+			select {
+			case reads <- read{data: append([]byte(nil), buf[:n]...), err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case r := <-reads:
+			if serve, _ := s.serveInput(conn, client, r.data, r.err); !serve {
+				return
+			}
+		case <-m.signal:
+			lines, overflowed := m.take()
+			if overflowed {
+				s.stats.ClientOutputBufferLimitDisconnections.Add(1)
+				logf(logWarning, "err write: %v", errOutputBufferLimit)
+				return
+			}
+			if err := s.writeReplies(conn, lines); err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					logf(logWarning, "err write: %v", err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// writeReplies writes replies to conn
+func (s *Server) writeReplies(conn net.Conn, replies []byte) error {
+	n, err := conn.Write(replies)
+	s.stats.NetOutputBytes.Add(int64(n))
+	return err
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/lyxuansang91/redis-crash-course/internal/core"
+)
+
+// The clients of the TLS port are served by the loops like the others, the
+// session only transforms the bytes they read and write. The handshake
+// needs to wait for the peer, it runs on its own goroutine over a duplicate
+// of the socket handled by the runtime poller, then the client is handed
+// back to its loop. From there on the loop feeds what it reads to the
+// session and writes the records the session produced.
+
+// tlsHandshakeTimeout bounds the handshake of a client, the time waiting
+// for the others to end included
+const tlsHandshakeTimeout = 10 * time.Second
+
+// maxTLSHandshakes bounds the handshakes running at once, the others wait
+// for one of them to end
+const maxTLSHandshakes = 128
+
+// tlsReadSize is the room made in the query buffer for the plaintext of a
+// record
+const tlsReadSize = 16 * 1024
+
+// errTLSWouldBlock is returned by the transport when the session needs
+// bytes that were not read from the socket yet. It is a temporary net.Error,
+// which crypto/tls does not keep as the error of the session.
+var errTLSWouldBlock error = wouldBlockError{}
+
+type wouldBlockError struct{}
+
+func (wouldBlockError) Error() string   { return "no TLS data available" }
+func (wouldBlockError) Timeout() bool   { return false }
+func (wouldBlockError) Temporary() bool { return true }
+
+// newTLSConfig builds the TLS settings of the server from the config, the
+// certificate files are read again on every call
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file must be set")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the certificate of the server: %v", err)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	switch cfg.TLSAuthClients {
+	case config.TLSAuthClientsYes:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	case config.TLSAuthClientsOptional:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if cfg.TLSCACertFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA certificates: %v", err)
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.TLSCACertFile)
+		}
+	} else if conf.ClientAuth != tls.NoClientCert {
+		return nil, errors.New("tls-ca-cert-file must be set to authenticate the clients")
+	}
+	if conf.MinVersion, conf.MaxVersion, err = parseTLSProtocols(cfg.TLSProtocols); err != nil {
+		return nil, err
+	}
+	if conf.CipherSuites, err = parseTLSCiphers(cfg.TLSCiphers); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"tlsv1":   tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+// parseTLSProtocols returns the range of versions of a tls-protocols list,
+// TLSv1.2 to TLSv1.3 when it is empty
+func parseTLSProtocols(protocols string) (lowest, highest uint16, err error) {
+	fields := strings.Fields(protocols)
+	if len(fields) == 0 {
+		return tls.VersionTLS12, tls.VersionTLS13, nil
+	}
+	for _, field := range fields {
+		version, ok := tlsVersions[strings.ToLower(field)]
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown TLS protocol '%s'", field)
+		}
+		if lowest == 0 || version < lowest {
+			lowest = version
+		}
+		highest = max(highest, version)
+	}
+	return lowest, highest, nil
+}
+
+// parseTLSCiphers returns the ids of a colon separated list of cipher
+// suite names, nil for the defaults when it is empty
+func parseTLSCiphers(ciphers string) ([]uint16, error) {
+	if ciphers == "" {
+		return nil, nil
+	}
+	var ids []uint16
+	for _, name := range strings.Split(ciphers, ":") {
+		i := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool { return suite.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown or insecure cipher suite '%s'", name)
+		}
+		ids = append(ids, tls.CipherSuites()[i].ID)
+	}
+	return ids, nil
+}
+
+// loadTLSConfig loads the TLS settings when the TLS port is enabled
+func (s *Server) loadTLSConfig() error {
+	if s.cfg().TLSPort == 0 {
+		return nil
+	}
+	conf, err := newTLSConfig(s.cfg())
+	if err != nil {
+		return err
+	}
+	s.tlsConfig.Store(conf)
+	return nil
+}
+
+// listenerTLSConfig returns the settings of the TLS listeners of the thread
+// pool mode, every handshake uses the current ones
+func (s *Server) listenerTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.tlsConfig.Load(), nil
+		},
+	}
+}
+
+// handshakes holds the clients whose handshake succeeded until their loop
+// picks them up
+type handshakes struct {
+	mu      sync.Mutex
+	clients []*core.Client
+	// closed is set once the loop returned, the clients are closed rather
+	// than handed to it
+	closed bool
+}
+
+// handshakeTLS runs the TLS handshake of an accepted client on its own
+// goroutine, the loop of s starts serving the client once it succeeded.
+// The client counts toward maxclients from now on.
+func (s *Server) handshakeTLS(client *core.Client) {
+	conf := s.tlsConfig.Load()
+	nonBlocking := s.cfg().NonBlockingClients
+	deadline := time.Now().Add(tlsHandshakeTimeout)
+	slots := s.life.handshakeSlots
+	s.life.handshaking.Add(1)
+	go func() {
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case slots <- struct{}{}:
+			timer.Stop()
+		case <-timer.C:
+			logf(logVerbose, "TLS handshake with %s timed out waiting for the other handshakes", client.Addr)
+			s.dropHandshake(client)
+			return
+		}
+		conn, err := newTLSConn(client.Fd, conf, nonBlocking, deadline)
+		<-slots
+		if err != nil {
+			logf(logVerbose, "TLS handshake with %s failed: %v", client.Addr, err)
+			s.dropHandshake(client)
+			return
+		}
+		client.Conn = conn
+		h := &s.handshakes
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.closed {
+			s.dropHandshake(client)
+			return
+		}
+		h.clients = append(h.clients, client)
+		// the waker is closed after the loop returned, which closed h
+		s.waker.wake()
+	}()
+}
+
+// dropHandshake closes a client whose handshake did not get it served
+func (s *Server) dropHandshake(client *core.Client) {
+	_ = syscall.Close(client.Fd)
+	s.life.handshaking.Add(-1)
+}
+
+// takeHandshaked returns the clients whose handshake succeeded, they are
+// no longer counted as handshaking since the loop adds them to its clients
+// right away
+func (s *Server) takeHandshaked() []*core.Client {
+	h := &s.handshakes
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients := h.clients
+	h.clients = nil
+	s.life.handshaking.Add(-int64(len(clients)))
+	return clients
+}
+
+// closeHandshakes closes the clients whose handshake succeeded once the
+// loop returned, and the ones whose handshake ends later
+func (s *Server) closeHandshakes() {
+	h := &s.handshakes
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, client := range h.clients {
+		s.dropHandshake(client)
+	}
+	h.clients = nil
+}
+
+// startTLSClients starts serving the clients whose handshake succeeded,
+// the commands read along with the handshake are run right away
+func (s *Server) startTLSClients() error {
+	for _, client := range s.takeHandshaked() {
+		if s.life.closing.Load() {
+			_ = syscall.Close(client.Fd)
+			continue
+		}
+		if err := s.addClient(client); err != nil {
+			return err
+		}
+		if err := client.AppendInput(nil); err != nil {
+			logf(logVerbose, "TLS error: %v", err)
+			s.closeClient(client)
+			continue
+		}
+		s.processQueryBuffer(client)
+		s.writeToClient(client)
+	}
+	return nil
+}
+
+// tlsConn is the TLS session of a client, it implements core.Conn
+type tlsConn struct {
+	conn      *tls.Conn
+	transport *tlsTransport
+}
+
+// newTLSConn runs the TLS handshake on the client socket fd until
+// deadline, over a duplicate of it handled by the runtime poller so the
+// handshake can wait for the peer. The socket is put back in the mode the
+// loops expect once done.
+func newTLSConn(fd int, conf *tls.Config, nonBlocking bool, deadline time.Time) (*tlsConn, error) {
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(dup), "")
+	netConn, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		netConn.Close()
+		if !nonBlocking {
+			_ = syscall.SetNonblock(fd, false)
+		}
+	}()
+	_ = netConn.SetDeadline(deadline)
+	transport := &tlsTransport{handshake: netConn, local: netConn.LocalAddr(), remote: netConn.RemoteAddr()}
+	conn := tls.Server(transport, conf)
+	if err = conn.Handshake(); err != nil {
+		return nil, err
+	}
+	transport.handshake = nil
+	return &tlsConn{conn: conn, transport: transport}, nil
+}
+
+// Decode feeds data to the session and appends the plaintext it decrypted
+// to dst
+func (c *tlsConn) Decode(dst, data []byte) ([]byte, error) {
+	c.transport.in = append(c.transport.in, data...)
+	for {
+		if cap(dst)-len(dst) < tlsReadSize {
+			dst = slices.Grow(dst, tlsReadSize)
+		}
+		n, err := c.conn.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if errors.Is(err, errTLSWouldBlock) {
+			return dst, nil
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+}
+
+// Encode encrypts replies into records to be written
+func (c *tlsConn) Encode(replies []byte) error {
+	_, err := c.conn.Write(replies)
+	return err
+}
+
+func (c *tlsConn) Peek() []byte {
+	t := c.transport
+	if len(t.out) == 0 {
+		return nil
+	}
+	return t.out[0][t.sent:]
+}
+
+func (c *tlsConn) Advance(n int) {
+	t := c.transport
+	t.sent += n
+	if t.sent == len(t.out[0]) {
+		t.out[0] = nil
+		t.out = t.out[1:]
+		t.sent = 0
+	}
+}
+
+// tlsTransport is the connection a TLS session runs over. The handshake
+// goes through the duplicate socket, then Read takes the bytes the loop
+// read and Write queues the records for the loop to write. Every record is
+// a chunk of its own, a chunk handed to the kernel is never moved.
+type tlsTransport struct {
+	handshake     net.Conn
+	local, remote net.Addr
+	in            []byte
+	out           [][]byte
+	// sent is the number of bytes of out[0] already written
+	sent int
+}
+
+func (t *tlsTransport) Read(b []byte) (int, error) {
+	if t.handshake != nil {
+		return t.handshake.Read(b)
+	}
+	if len(t.in) == 0 {
+		return 0, errTLSWouldBlock
+	}
+	n := copy(b, t.in)
+	if n == len(t.in) {
+		t.in = t.in[:0]
+	} else {
+		t.in = t.in[n:]
+	}
+	return n, nil
+}
+
+func (t *tlsTransport) Write(b []byte) (int, error) {
+	if t.handshake != nil {
+		return t.handshake.Write(b)
+	}
+	t.out = append(t.out, slices.Clone(b))
+	return len(b), nil
+}
+
+func (t *tlsTransport) Close() error {
+	if t.handshake != nil {
+		return t.handshake.Close()
+	}
+	return nil
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr  { return t.local }
+func (t *tlsTransport) RemoteAddr() net.Addr { return t.remote }
+
+func (t *tlsTransport) SetDeadline(deadline time.Time) error {
+	if t.handshake != nil {
+		return t.handshake.SetDeadline(deadline)
+	}
+	return nil
+}
+
+func (t *tlsTransport) SetReadDeadline(deadline time.Time) error {
+	if t.handshake != nil {
+		return t.handshake.SetReadDeadline(deadline)
+	}
+	return nil
+}
+
+func (t *tlsTransport) SetWriteDeadline(deadline time.Time) error {
+	if t.handshake != nil {
+		return t.handshake.SetWriteDeadline(deadline)
+	}
+	return nil
+}
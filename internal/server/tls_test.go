@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lyxuansang91/redis-crash-course/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a certificate and its key, signed by the CA of the test
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert issues a certificate for name signed by ca, self-signed when
+// ca is nil
+func newTestCert(t *testing.T, name string, ca *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writeFiles writes the certificate and its key to dir as PEM files
+func (c *testCert) writeFiles(t *testing.T, dir string) (certFile, keyFile string) {
+	name := c.cert.Subject.CommonName
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLS(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.writeFiles(t, dir)
+	serverCertFile, serverKeyFile := newTestCert(t, "server", ca).writeFiles(t, dir)
+	rotatedCertFile, rotatedKeyFile := newTestCert(t, "rotated", ca).writeFiles(t, dir)
+	client := newTestCert(t, "client", ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	for name, setup := range testModes {
+		t.Run(name, func(t *testing.T) {
+			server := startTestServer(t, func(cfg *config.Config) {
+				cfg.TLSPort = freePort(t)
+				cfg.TLSCertFile = serverCertFile
+				cfg.TLSKeyFile = serverKeyFile
+				cfg.TLSCACertFile = caFile
+				cfg.EnableProtectedConfigs = config.EnableLocal
+				setup(cfg)
+			})
+			tlsAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(server.cfg().TLSPort))
+
+			dial := func(certs ...tls.Certificate) (*tls.Conn, error) {
+				dialer := &net.Dialer{Timeout: 5 * time.Second}
+				conn, err := tls.DialWithDialer(dialer, "tcp", tlsAddr, &tls.Config{RootCAs: roots, Certificates: certs})
+				if err == nil {
+					t.Cleanup(func() {
+						_ = conn.Close()
+					})
+					_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+				}
+				return conn, err
+			}
+			conn, err := dial(client.tlsCertificate())
+			if !assert.Nil(t, err) {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			assert.Equal(t, "server", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+			assert.Equal(t, "+PONG", roundTrip(t, conn, reader, "PING"))
+			// a reply spanning many records
+			value := strings.Repeat("v", 200*1024)
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "SET", "key", value))
+			assert.Equal(t, value, roundTrip(t, conn, reader, "GET", "key"))
+
+			// the plain port is still served
+			plain, plainReader := server.dial(t)
+			assert.Equal(t, "+PONG", roundTrip(t, plain, plainReader, "PING"))
+
+			// clients without a certificate are refused
+			if anonymous, err := dial(); err == nil {
+				_, err = anonymous.Write([]byte("PING\r\n"))
+				if err == nil {
+					_, err = bufio.NewReader(anonymous).ReadString('\n')
+				}
+				assert.NotNil(t, err)
+			}
+
+			// the certificate is reloaded, only the valid settings are taken
+			assert.True(t, strings.HasPrefix(roundTrip(t, conn, reader, "CONFIG", "SET", "tls-cert-file", filepath.Join(dir, "missing.crt")),
+				"-ERR CONFIG SET failed (possibly related to argument 'tls-cert-file') - Unable to update TLS configuration."))
+			assert.Equal(t, "+OK", roundTrip(t, conn, reader, "CONFIG", "SET", "tls-cert-file", rotatedCertFile, "tls-key-file", rotatedKeyFile))
+			rotated, err := dial(client.tlsCertificate())
+			if assert.Nil(t, err) {
+				assert.Equal(t, "rotated", rotated.ConnectionState().PeerCertificates[0].Subject.CommonName)
+				assert.Equal(t, "+PONG", roundTrip(t, rotated, bufio.NewReader(rotated), "PING"))
+			}
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	lowest, highest, err := parseTLSProtocols("TLSv1.3 tlsv1.2")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), lowest)
+	assert.Equal(t, uint16(tls.VersionTLS13), highest)
+	_, _, err = parseTLSProtocols("SSLv3")
+	assert.Equal(t, "unknown TLS protocol 'SSLv3'", err.Error())
+
+	ids, err := parseTLSCiphers("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, ids)
+	_, err = parseTLSCiphers("TLS_RSA_WITH_RC4_128_SHA")
+	assert.NotNil(t, err)
+
+	cfg := config.NewConfig()
+	_, err = newTLSConfig(cfg)
+	assert.Equal(t, "tls-cert-file and tls-key-file must be set", err.Error())
+}
+
+func TestTLSHandshakesCountTowardMaxClients(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.writeFiles(t, dir)
+	certFile, keyFile := newTestCert(t, "server", ca).writeFiles(t, dir)
+
+	server := startTestServer(t, func(cfg *config.Config) {
+		cfg.TLSPort = freePort(t)
+		cfg.TLSCertFile = certFile
+		cfg.TLSKeyFile = keyFile
+		cfg.TLSCACertFile = caFile
+		cfg.MaxConnections = 1
+	})
+
+	// a client that never sends its hello holds the only slot
+	stalled, _ := dialTest(t, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(server.cfg().TLSPort)))
+	assert.Eventually(t, func() bool { return server.life.handshaking.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	rejected, _ := server.dial(t)
+	data, err := io.ReadAll(rejected)
+	assert.Nil(t, err)
+	assert.Equal(t, "-ERR max number of clients reached\r\n", string(data))
+
+	// the slot is released once the handshake failed
+	_ = stalled.Close()
+	assert.Eventually(t, func() bool { return server.life.handshaking.Load() == 0 }, 5*time.Second, 10*time.Millisecond)
+	conn, reader := server.dial(t)
+	assert.Equal(t, "+PONG", roundTrip(t, conn, reader, "PING"))
+}
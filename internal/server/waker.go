@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+)
+
+// waker lets other goroutines wake up an event loop blocked waiting for
+// I/O, to hand it messages or to stop it. The loop monitors fd, one end of
+// a socket pair, so that readiness and completion based multiplexers can
+// both wait on it; notified avoids a write per wake up.
+type waker struct {
+	fd       int
+	writeFd  int
+	notified atomic.Bool
+}
+
+func newWaker() (*waker, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the wake up socket pair: %v", err)
+	}
+	for _, fd := range fds {
+		syscall.CloseOnExec(fd)
+		if err = syscall.SetNonblock(fd, true); err != nil {
+			_ = syscall.Close(fds[0])
+			_ = syscall.Close(fds[1])
+			return nil, fmt.Errorf("failed to set the wake up socket pair non-blocking: %v", err)
+		}
+	}
+	return &waker{fd: fds[0], writeFd: fds[1]}, nil
+}
+
+// wake wakes the loop up, it may be called from any goroutine
+func (w *waker) wake() {
+	if w.notified.CompareAndSwap(false, true) {
+		_, _ = syscall.Write(w.writeFd, []byte{1})
+	}
+}
+
+// drain consumes the pending wake ups, the loop calls it before looking at
+// what it was woken up for. The socket is drained before notified is
+// cleared: a wake failing its CompareAndSwap then knows that another one
+// wrote after the drain, so the loop is woken up again for whatever was
+// queued while it was busy.
+func (w *waker) drain() {
+	var buf [64]byte
+	for {
+		if n, err := syscall.Read(w.fd, buf[:]); err != nil || n == 0 {
+			break
+		}
+	}
+	w.notified.Store(false)
+}
+
+func (w *waker) close() {
+	_ = syscall.Close(w.fd)
+	_ = syscall.Close(w.writeFd)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,34 +18,35 @@ type TestClient struct {
 
 // NewTestClient creates a new test client
 func NewTestClient(name string) (*TestClient, error) {
-	conn, err := net.Dial("tcp", "localhost:8080")
+	conn, err := net.Dial("tcp", "localhost:3000")
 	if err != nil {
 		return nil, err
 	}
 	
-	// Read welcome message
-	scanner := bufio.NewScanner(conn)
-	if scanner.Scan() {
-		fmt.Printf("[%s] %s\n", name, scanner.Text())
-	}
-	
 	return &TestClient{
 		conn: conn,
 		name: name,
 	}, nil
 }
 
-// SendMessage sends a message to the server
+// SendMessage sends a message to the server as the argument of a PING,
+// which the server echoes back
 func (tc *TestClient) SendMessage(message string) error {
-	_, err := fmt.Fprintln(tc.conn, message)
+	_, err := fmt.Fprintf(tc.conn, "*2\r\n$4\r\nPING\r\n$%d\r\n%s\r\n", len(message), message)
 	if err != nil {
 		return err
 	}
 	
-	// Read response
-	scanner := bufio.NewScanner(tc.conn)
-	if scanner.Scan() {
-		fmt.Printf("[%s] Server: %s\n", tc.name, scanner.Text())
+	// Read response, a bulk string: its length then its value
+	reader := bufio.NewReader(tc.conn)
+	for i := 0; i < 2; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if i == 1 {
+			fmt.Printf("[%s] Server: %s\n", tc.name, strings.TrimSpace(line))
+		}
 	}
 	
 	return nil
@@ -57,7 +59,7 @@ func (tc *TestClient) Close() {
 
 func main() {
 	fmt.Println("Starting TCP Server Test...")
-	fmt.Println("Make sure the server is running with: go run test/main.go")
+	fmt.Println("Make sure the server is running with: go run cmd/main.go")
 	
 	clients := make([]*TestClient, 0)
 	for i := 0; i < 10; i++ {
@@ -90,7 +92,7 @@ func main() {
 				time.Sleep(500 * time.Millisecond) // Small delay between messages	
 			}
 			fmt.Println("\nDisconnecting client...")
-			c.SendMessage("quit")
+			c.Close()
 		}(client)
 	}
 	wg.Wait()
@@ -1,8 +1,6 @@
 package threadpool
 
-import (
-	"log"
-)
+import "sync/atomic"
 
 // Job represents a unit of work to be executed by a worker
 type Job struct {
@@ -13,12 +11,17 @@ type Job struct {
 type Worker struct {
     id      int
     jobChan chan Job
+    pool    *Pool
 }
 
 // Pool represents a pool of workers processing jobs
 type Pool struct {
     jobQueue chan Job
     workers  []*Worker
+    // queued counts the jobs waiting for a worker, busy the workers
+    // running a job
+    queued atomic.Int64
+    busy   atomic.Int64
 }
 
 // NewWorker creates a new worker bound to the provided job channel
@@ -33,32 +36,68 @@ func NewWorker(id int, jobChan chan Job) *Worker {
 func (w *Worker) Start() {
     go func() {
         for job := range w.jobChan {
-            log.Printf("Worker %d is executing a job ", w.id)
+            if w.pool != nil {
+                w.pool.queued.Add(-1)
+                w.pool.busy.Add(1)
+            }
             job.task()
+            if w.pool != nil {
+                w.pool.busy.Add(-1)
+            }
         }
     }()
 }
 
-// NewPool creates a pool with the specified number of workers
+// NewPool creates a pool with the specified number of workers. As many
+// jobs can wait for a worker without blocking the caller.
 func NewPool(numOfWorkers int) *Pool {
     return &Pool{
-        jobQueue: make(chan Job),
+        jobQueue: make(chan Job, numOfWorkers),
         workers:  make([]*Worker, numOfWorkers),
     }
 }
 
 // AddJob enqueues a function to be executed by the pool
 func (p *Pool) AddJob(task func()) {
+    p.queued.Add(1)
     p.jobQueue <- Job{task: task}
 }
 
+// TryAddJob enqueues a function unless the queue is full, it never waits
+func (p *Pool) TryAddJob(task func()) bool {
+    p.queued.Add(1)
+    select {
+    case p.jobQueue <- Job{task: task}:
+        return true
+    default:
+        p.queued.Add(-1)
+        return false
+    }
+}
+
 // Start initializes and starts all workers
 func (p *Pool) Start() {
     for i := 0; i < len(p.workers); i++ {
         worker := NewWorker(i, p.jobQueue)
+        worker.pool = p
         p.workers[i] = worker
         worker.Start()
     }
 }
 
 
+// QueueDepth returns the number of jobs waiting for a worker
+func (p *Pool) QueueDepth() int64 {
+    return p.queued.Load()
+}
+
+// Busy returns the number of workers running a job
+func (p *Pool) Busy() int64 {
+    return p.busy.Load()
+}
+
+// Stop closes the job queue, the workers exit once their current job is
+// done. No job may be added afterwards.
+func (p *Pool) Stop() {
+    close(p.jobQueue)
+}